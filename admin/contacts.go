@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"hawx.me/code/tally-ho/micropub"
+)
+
+type ContactsBlog interface {
+	Contacts(filter string) ([]micropub.Contact, error)
+	SetContact(contact micropub.Contact) error
+	RemoveContact(url string) error
+}
+
+// Contacts returns a handler for curating the contacts returned by the
+// micropub q=contact query.
+//
+// A GET request lists the contacts, both those added and those found in
+// entries.
+//
+// A POST request with the action "set" adds, or replaces, the contact with the
+// given url, name, nickname and photo. Each silo is given as "name:username",
+// for instance "github:john".
+//
+// A POST request with the action "remove" deletes the added contact with url.
+func Contacts(blog ContactsBlog) http.Handler {
+	return &contactsHandler{blog: blog}
+}
+
+type contactsHandler struct {
+	blog ContactsBlog
+}
+
+func (h *contactsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r)
+	case http.MethodPost:
+		h.post(w, r)
+	default:
+		w.Header().Set("Accept", "GET,POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *contactsHandler) get(w http.ResponseWriter, r *http.Request) {
+	contacts, err := h.blog.Contacts("")
+	if err != nil {
+		slog.Error("admin contacts", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if contacts == nil {
+		contacts = []micropub.Contact{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Items []micropub.Contact `json:"items"`
+	}{
+		Items: contacts,
+	})
+}
+
+func (h *contactsHandler) post(w http.ResponseWriter, r *http.Request) {
+	url := r.FormValue("url")
+	if url == "" {
+		http.Error(w, "missing url", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "set":
+		contact := micropub.Contact{
+			Name:     r.FormValue("name"),
+			Nickname: r.FormValue("nickname"),
+			URL:      url,
+			Photo:    r.FormValue("photo"),
+		}
+
+		for _, silo := range r.Form["silo"] {
+			name, username, ok := strings.Cut(silo, ":")
+			if !ok || name == "" || username == "" {
+				http.Error(w, "invalid silo", http.StatusBadRequest)
+				return
+			}
+			if contact.Silos == nil {
+				contact.Silos = map[string]string{}
+			}
+			contact.Silos[name] = username
+		}
+
+		if err := h.blog.SetContact(contact); err != nil {
+			slog.Error("admin set contact", slog.String("url", url), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+	case "remove":
+		if err := h.blog.RemoveContact(url); err != nil {
+			slog.Error("admin remove contact", slog.String("url", url), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
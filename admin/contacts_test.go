@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/micropub"
+)
+
+type fakeContactsBlog struct {
+	contacts []micropub.Contact
+	removed  []string
+}
+
+func (b *fakeContactsBlog) Contacts(filter string) ([]micropub.Contact, error) {
+	return b.contacts, nil
+}
+
+func (b *fakeContactsBlog) SetContact(contact micropub.Contact) error {
+	b.contacts = append(b.contacts, contact)
+	return nil
+}
+
+func (b *fakeContactsBlog) RemoveContact(url string) error {
+	b.removed = append(b.removed, url)
+	return nil
+}
+
+func TestContacts(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeContactsBlog{}
+
+	s := httptest.NewServer(Contacts(blog))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"action":   {"set"},
+		"url":      {"https://jane.example.com/"},
+		"name":     {"Jane"},
+		"nickname": {"jane"},
+		"silo":     {"github:jane", "twitter:janed"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(s.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		Items []micropub.Contact `json:"items"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal([]micropub.Contact{{
+		Name:     "Jane",
+		Nickname: "jane",
+		URL:      "https://jane.example.com/",
+		Silos:    map[string]string{"github": "jane", "twitter": "janed"},
+	}}, v.Items)
+
+	resp, err = http.PostForm(s.URL, url.Values{"action": {"remove"}, "url": {"https://jane.example.com/"}})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal([]string{"https://jane.example.com/"}, blog.removed)
+}
+
+func TestContactsInvalid(t *testing.T) {
+	s := httptest.NewServer(Contacts(&fakeContactsBlog{}))
+	defer s.Close()
+
+	for name, form := range map[string]url.Values{
+		"missing url":    {"action": {"set"}, "name": {"Jane"}},
+		"invalid silo":   {"action": {"set"}, "url": {"https://jane.example.com/"}, "silo": {"jane"}},
+		"unknown action": {"action": {"forget"}, "url": {"https://jane.example.com/"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.PostForm(s.URL, form)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package admin implements handlers for managing the blog that are only
+// available to its owner.
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"hawx.me/code/numbersix"
+)
+
+type MentionsBlog interface {
+	PendingMentions() ([]numbersix.Group, error)
+	ApproveMention(source string) error
+	RejectMention(source string) error
+	Blocked() ([]string, error)
+	Allowed() ([]string, error)
+	Block(source string) error
+	Allow(source string) error
+	Unblock(source string) error
+}
+
+type pendingMention struct {
+	Source     string                   `json:"source"`
+	Properties map[string][]interface{} `json:"properties"`
+}
+
+// Mentions returns a handler for moderating received webmentions.
+//
+// A GET request lists the mentions waiting to be approved, along with the
+// blocked and allowed sources.
+//
+// A POST request takes a form with an action and source. The actions
+// "approve" and "reject" apply to a pending mention with the exact source
+// URL. The actions "block", "allow" and "unblock" change the rules for
+// future mentions, where source can be a domain or an exact URL.
+func Mentions(blog MentionsBlog) http.Handler {
+	return &mentionsHandler{blog: blog}
+}
+
+type mentionsHandler struct {
+	blog MentionsBlog
+}
+
+func (h *mentionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r)
+	case http.MethodPost:
+		h.post(w, r)
+	default:
+		w.Header().Set("Accept", "GET,POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *mentionsHandler) get(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.blog.PendingMentions()
+	if err != nil {
+		slog.Error("admin pending mentions", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	blocked, err := h.blog.Blocked()
+	if err != nil {
+		slog.Error("admin blocked", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := h.blog.Allowed()
+	if err != nil {
+		slog.Error("admin allowed", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	pending := make([]pendingMention, len(groups))
+	for i, group := range groups {
+		pending[i] = pendingMention{Source: group.Subject, Properties: group.Properties}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Pending []pendingMention `json:"pending"`
+		Blocked []string         `json:"blocked"`
+		Allowed []string         `json:"allowed"`
+	}{
+		Pending: pending,
+		Blocked: nonNil(blocked),
+		Allowed: nonNil(allowed),
+	})
+}
+
+func (h *mentionsHandler) post(w http.ResponseWriter, r *http.Request) {
+	source := r.FormValue("source")
+	if source == "" {
+		http.Error(w, "missing source", http.StatusBadRequest)
+		return
+	}
+
+	var action func(string) error
+	switch r.FormValue("action") {
+	case "approve":
+		action = h.blog.ApproveMention
+	case "reject":
+		action = h.blog.RejectMention
+	case "block":
+		action = h.blog.Block
+	case "allow":
+		action = h.blog.Allow
+	case "unblock":
+		action = h.blog.Unblock
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if err := action(source); err != nil {
+		slog.Error("admin mention action", slog.String("action", r.FormValue("action")), slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+
+	return list
+}
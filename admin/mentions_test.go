@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/numbersix"
+)
+
+type fakeMentionsBlog struct {
+	pending []numbersix.Group
+	blocked []string
+	allowed []string
+}
+
+func (b *fakeMentionsBlog) PendingMentions() ([]numbersix.Group, error) { return b.pending, nil }
+func (b *fakeMentionsBlog) Blocked() ([]string, error)                  { return b.blocked, nil }
+func (b *fakeMentionsBlog) Allowed() ([]string, error)                  { return b.allowed, nil }
+
+func (b *fakeMentionsBlog) ApproveMention(source string) error {
+	b.pending = nil
+	return nil
+}
+
+func (b *fakeMentionsBlog) RejectMention(source string) error {
+	b.pending = nil
+	return nil
+}
+
+func (b *fakeMentionsBlog) Block(source string) error {
+	b.blocked = append(b.blocked, source)
+	return nil
+}
+
+func (b *fakeMentionsBlog) Allow(source string) error {
+	b.allowed = append(b.allowed, source)
+	return nil
+}
+
+func (b *fakeMentionsBlog) Unblock(source string) error { return nil }
+
+func TestMentions(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeMentionsBlog{
+		pending: []numbersix.Group{{
+			Subject:    "https://stranger.example.com/1",
+			Properties: map[string][]interface{}{"hx-target": {"http://example.com/post"}},
+		}},
+	}
+
+	s := httptest.NewServer(Mentions(blog))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"action": {"block"},
+		"source": {"spam.example.com"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(s.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		Pending []struct {
+			Source string `json:"source"`
+		} `json:"pending"`
+		Blocked []string `json:"blocked"`
+		Allowed []string `json:"allowed"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+
+	if assert.Len(v.Pending, 1) {
+		assert.Equal("https://stranger.example.com/1", v.Pending[0].Source)
+	}
+	assert.Equal([]string{"spam.example.com"}, v.Blocked)
+	assert.Equal([]string{}, v.Allowed)
+
+	resp, err = http.PostForm(s.URL, url.Values{
+		"action": {"approve"},
+		"source": {"https://stranger.example.com/1"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Len(blog.pending, 0)
+}
+
+func TestMentionsBadAction(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(Mentions(&fakeMentionsBlog{}))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL, "application/x-www-form-urlencoded", strings.NewReader("action=what&source=x"))
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(s.URL, "application/x-www-form-urlencoded", strings.NewReader("action=block"))
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
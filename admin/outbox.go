@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"hawx.me/code/tally-ho/webmention"
+)
+
+type OutboxDB interface {
+	Deliveries(source string) ([]webmention.Delivery, error)
+}
+
+// Outbox returns a handler listing the status of sent webmentions. By default
+// all are listed, but passing the URL of an entry as the source parameter
+// lists only those sent for it.
+func Outbox(db OutboxDB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Accept", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		deliveries, err := db.Deliveries(r.FormValue("source"))
+		if err != nil {
+			slog.Error("admin outbox", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if deliveries == nil {
+			deliveries = []webmention.Delivery{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Items []webmention.Delivery `json:"items"`
+		}{
+			Items: deliveries,
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"hawx.me/code/tally-ho/websub"
+)
+
+type SubscriptionsDB interface {
+	Subscriptions(topic string) ([]websub.Subscription, error)
+	Unsubscribe(callback, topic string) error
+	RemoveCallback(callback string) error
+}
+
+// Subscriptions returns a handler for managing WebSub subscriptions.
+//
+// A GET request lists the subscriptions, with when they expire and the result
+// of delivering to them. Passing a topic parameter lists only those for it.
+//
+// A POST request with the action "remove" deletes the subscription of callback
+// to topic, or to every topic if topic is not given.
+func Subscriptions(db SubscriptionsDB) http.Handler {
+	return &subscriptionsHandler{db: db}
+}
+
+type subscriptionsHandler struct {
+	db SubscriptionsDB
+}
+
+func (h *subscriptionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r)
+	case http.MethodPost:
+		h.post(w, r)
+	default:
+		w.Header().Set("Accept", "GET,POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *subscriptionsHandler) get(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.db.Subscriptions(r.FormValue("topic"))
+	if err != nil {
+		slog.Error("admin subscriptions", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if subscriptions == nil {
+		subscriptions = []websub.Subscription{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Items []websub.Subscription `json:"items"`
+	}{
+		Items: subscriptions,
+	})
+}
+
+func (h *subscriptionsHandler) post(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("action") != "remove" {
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	callback := r.FormValue("callback")
+	if callback == "" {
+		http.Error(w, "missing callback", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if topic := r.FormValue("topic"); topic != "" {
+		err = h.db.Unsubscribe(callback, topic)
+	} else {
+		err = h.db.RemoveCallback(callback)
+	}
+
+	if err != nil {
+		slog.Error("admin remove subscription", slog.String("callback", callback), slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/websub"
+)
+
+type fakeSubscriptionsDB struct {
+	subscriptions []websub.Subscription
+	removed       []string
+}
+
+func (db *fakeSubscriptionsDB) Subscriptions(topic string) ([]websub.Subscription, error) {
+	var subscriptions []websub.Subscription
+	for _, subscription := range db.subscriptions {
+		if topic == "" || subscription.Topic == topic {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+func (db *fakeSubscriptionsDB) Unsubscribe(callback, topic string) error {
+	db.removed = append(db.removed, callback+" "+topic)
+	return nil
+}
+
+func (db *fakeSubscriptionsDB) RemoveCallback(callback string) error {
+	db.removed = append(db.removed, callback)
+	return nil
+}
+
+func TestSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &fakeSubscriptionsDB{
+		subscriptions: []websub.Subscription{{
+			Callback:   "https://reader.example.org/1",
+			Topic:      "http://example.com/feed/atom",
+			ExpiresAt:  time.Date(2019, time.January, 2, 15, 4, 5, 0, time.UTC),
+			Deliveries: 3,
+			Failures:   1,
+			LastStatus: websub.StatusDelivered,
+		}, {
+			Callback:  "https://reader.example.org/2",
+			Topic:     "http://example.com/",
+			ExpiresAt: time.Date(2019, time.January, 2, 15, 4, 5, 0, time.UTC),
+		}},
+	}
+
+	s := httptest.NewServer(Subscriptions(db))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?topic=" + url.QueryEscape("http://example.com/feed/atom"))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		Items []websub.Subscription `json:"items"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+
+	if assert.Len(v.Items, 1) {
+		assert.Equal(db.subscriptions[0], v.Items[0])
+	}
+
+	resp, err = http.PostForm(s.URL, url.Values{
+		"action":   {"remove"},
+		"callback": {"https://reader.example.org/1"},
+		"topic":    {"http://example.com/feed/atom"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.PostForm(s.URL, url.Values{
+		"action":   {"remove"},
+		"callback": {"https://reader.example.org/2"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	assert.Equal([]string{
+		"https://reader.example.org/1 http://example.com/feed/atom",
+		"https://reader.example.org/2",
+	}, db.removed)
+}
+
+func TestSubscriptionsRemoveWithoutCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(Subscriptions(&fakeSubscriptionsDB{}))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{"action": {"remove"}})
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
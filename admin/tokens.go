@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hawx.me/code/tally-ho/auth"
+)
+
+type TokensDB interface {
+	Create(label string, scopes []string, expiresAt time.Time) (string, auth.AppToken, error)
+	List() ([]auth.AppToken, error)
+	Revoke(id int64) error
+}
+
+// Tokens returns a handler for managing app tokens.
+//
+// A GET request lists the tokens, without their secrets.
+//
+// A POST request with the action "create" makes a token with the given label
+// and scopes, which can be repeated or space separated. If expires_in is given,
+// as a duration such as "720h", the token will expire after it. The response
+// includes the token, which can not be retrieved again.
+//
+// A POST request with the action "revoke" deletes the token with id.
+func Tokens(db TokensDB) http.Handler {
+	return &tokensHandler{db: db}
+}
+
+type tokensHandler struct {
+	db TokensDB
+}
+
+func (h *tokensHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r)
+	case http.MethodPost:
+		h.post(w, r)
+	default:
+		w.Header().Set("Accept", "GET,POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *tokensHandler) get(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.db.List()
+	if err != nil {
+		slog.Error("admin tokens", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if tokens == nil {
+		tokens = []auth.AppToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Items []auth.AppToken `json:"items"`
+	}{
+		Items: tokens,
+	})
+}
+
+func (h *tokensHandler) post(w http.ResponseWriter, r *http.Request) {
+	switch r.FormValue("action") {
+	case "create":
+		h.create(w, r)
+	case "revoke":
+		h.revoke(w, r)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+func (h *tokensHandler) create(w http.ResponseWriter, r *http.Request) {
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		http.Error(w, "missing label", http.StatusBadRequest)
+		return
+	}
+
+	var scopes []string
+	for _, scope := range r.Form["scope"] {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	if len(scopes) == 0 {
+		http.Error(w, "missing scope", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt time.Time
+	if expiresIn := r.FormValue("expires_in"); expiresIn != "" {
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		expiresAt = time.Now().Add(d)
+	}
+
+	secret, token, err := h.db.Create(label, scopes, expiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownScope) || errors.Is(err, auth.ErrLabelTaken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		slog.Error("admin create token", slog.String("label", label), slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		auth.AppToken
+		Token string `json:"token"`
+	}{
+		AppToken: token,
+		Token:    secret,
+	})
+}
+
+func (h *tokensHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Revoke(id); err != nil {
+		if errors.Is(err, auth.ErrNotFound) {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+
+		slog.Error("admin revoke token", slog.Int64("id", id), slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/auth"
+)
+
+type fakeTokensDB struct {
+	tokens  []auth.AppToken
+	revoked []int64
+}
+
+func (db *fakeTokensDB) Create(label string, scopes []string, expiresAt time.Time) (string, auth.AppToken, error) {
+	for _, scope := range scopes {
+		if scope == "admin" {
+			return "", auth.AppToken{}, auth.ErrUnknownScope
+		}
+	}
+
+	token := auth.AppToken{ID: int64(len(db.tokens) + 1), Label: label, Scopes: scopes}
+	if !expiresAt.IsZero() {
+		token.ExpiresAt = &expiresAt
+	}
+	db.tokens = append(db.tokens, token)
+
+	return "secret", token, nil
+}
+
+func (db *fakeTokensDB) List() ([]auth.AppToken, error) {
+	return db.tokens, nil
+}
+
+func (db *fakeTokensDB) Revoke(id int64) error {
+	if id > int64(len(db.tokens)) {
+		return auth.ErrNotFound
+	}
+
+	db.revoked = append(db.revoked, id)
+	return nil
+}
+
+func TestTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &fakeTokensDB{}
+
+	s := httptest.NewServer(Tokens(db))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"action":     {"create"},
+		"label":      {"phone"},
+		"scope":      {"create media", "update"},
+		"expires_in": {"720h"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	var created struct {
+		ID        int64      `json:"id"`
+		Label     string     `json:"label"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		Token     string     `json:"token"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(int64(1), created.ID)
+	assert.Equal("phone", created.Label)
+	assert.Equal([]string{"create", "media", "update"}, created.Scopes)
+	assert.Equal("secret", created.Token)
+	if assert.NotNil(created.ExpiresAt) {
+		assert.WithinDuration(time.Now().Add(720*time.Hour), *created.ExpiresAt, time.Minute)
+	}
+
+	resp, err = http.Get(s.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		Items []map[string]any `json:"items"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	if assert.Len(v.Items, 1) {
+		assert.Equal("phone", v.Items[0]["label"])
+		assert.NotContains(v.Items[0], "token")
+	}
+
+	resp, err = http.PostForm(s.URL, url.Values{"action": {"revoke"}, "id": {"1"}})
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal([]int64{1}, db.revoked)
+
+	resp, err = http.PostForm(s.URL, url.Values{"action": {"revoke"}, "id": {"5"}})
+	assert.Nil(err)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTokensCreateInvalid(t *testing.T) {
+	s := httptest.NewServer(Tokens(&fakeTokensDB{}))
+	defer s.Close()
+
+	for name, form := range map[string]url.Values{
+		"missing label":   {"action": {"create"}, "scope": {"create"}},
+		"missing scope":   {"action": {"create"}, "label": {"phone"}},
+		"unknown scope":   {"action": {"create"}, "label": {"phone"}, "scope": {"admin"}},
+		"invalid expires": {"action": {"create"}, "label": {"phone"}, "scope": {"create"}, "expires_in": {"soon"}},
+		"unknown action":  {"action": {"mint"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.PostForm(s.URL, form)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
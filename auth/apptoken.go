@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AppScopes are the scopes that an app token can be given.
+var AppScopes = []string{"create", "update", "delete", "undelete", "media"}
+
+var (
+	// ErrUnknownScope is returned when creating an app token with a scope not in
+	// AppScopes.
+	ErrUnknownScope = errors.New("unknown scope")
+
+	// ErrLabelTaken is returned when creating an app token with the same label
+	// as an existing token.
+	ErrLabelTaken = errors.New("label already used")
+)
+
+// AppToken is a token created by the owner for a client, rather than through
+// IndieAuth. The label is used as the client ID of requests made with it.
+type AppToken struct {
+	ID        int64      `json:"id"`
+	Label     string     `json:"label"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// AppTokenStore persists app tokens, which are keyed by the sha256 hash of the
+// token.
+type AppTokenStore interface {
+	// CreateAppToken records token, returning its ID.
+	CreateAppToken(hash string, token AppToken) (int64, error)
+
+	// AppToken returns the token with hash, or ErrNotFound.
+	AppToken(hash string) (AppToken, error)
+
+	// AppTokens lists all of the tokens.
+	AppTokens() ([]AppToken, error)
+
+	// RevokeAppToken deletes the token with id, or returns ErrNotFound.
+	RevokeAppToken(id int64) error
+}
+
+// AppTokens creates and verifies app tokens.
+type AppTokens struct {
+	store AppTokenStore
+	me    string
+	now   func() time.Time
+}
+
+// NewAppTokens returns AppTokens that verify tokens in store as being for me.
+func NewAppTokens(store AppTokenStore, me string) *AppTokens {
+	return &AppTokens{store: store, me: me, now: time.Now}
+}
+
+// Create makes a new token, returning the secret that should be given to the
+// client as it is not stored. If expiresAt is zero the token does not expire.
+func (a *AppTokens) Create(label string, scopes []string, expiresAt time.Time) (string, AppToken, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return "", AppToken{}, errors.New("label is required")
+	}
+
+	if len(scopes) == 0 {
+		return "", AppToken{}, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AppScopes, scope) {
+			return "", AppToken{}, fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+
+	existing, err := a.store.AppTokens()
+	if err != nil {
+		return "", AppToken{}, err
+	}
+	for _, token := range existing {
+		if token.Label == label {
+			return "", AppToken{}, ErrLabelTaken
+		}
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return "", AppToken{}, err
+	}
+
+	token := AppToken{
+		Label:     label,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedAt: a.now().UTC(),
+	}
+	if !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		token.ExpiresAt = &expiresAt
+	}
+
+	token.ID, err = a.store.CreateAppToken(hashSecret(secret), token)
+	if err != nil {
+		return "", AppToken{}, err
+	}
+
+	return secret, token, nil
+}
+
+// List returns all of the tokens, including any that have expired.
+func (a *AppTokens) List() ([]AppToken, error) {
+	return a.store.AppTokens()
+}
+
+// Revoke deletes the token with id, so it can no longer be used.
+func (a *AppTokens) Revoke(id int64) error {
+	return a.store.RevokeAppToken(id)
+}
+
+// Verify checks that token was created and has not expired or been revoked.
+func (a *AppTokens) Verify(token string) (Token, error) {
+	appToken, err := a.store.AppToken(hashSecret(token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Token{}, ErrInvalidToken
+		}
+		return Token{}, err
+	}
+
+	if appToken.ExpiresAt != nil && !a.now().Before(*appToken.ExpiresAt) {
+		return Token{}, ErrInvalidToken
+	}
+
+	return Token{
+		Me:       a.me,
+		ClientID: appToken.Label,
+		Scopes:   appToken.Scopes,
+	}, nil
+}
+
+// Verifiers tries each Verifier in turn, accepting a token if any of them do.
+type Verifiers []Verifier
+
+func (vs Verifiers) Verify(token string) (Token, error) {
+	var errs []error
+
+	for _, v := range vs {
+		verified, err := v.Verify(token)
+		if err == nil {
+			return verified, nil
+		}
+		if !errors.Is(err, ErrInvalidToken) {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return Token{}, errors.Join(errs...)
+	}
+
+	return Token{}, ErrInvalidToken
+}
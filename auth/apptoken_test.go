@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAppTokenStore struct {
+	tokens map[string]AppToken
+	nextID int64
+}
+
+func (s *fakeAppTokenStore) CreateAppToken(hash string, token AppToken) (int64, error) {
+	s.nextID++
+	token.ID = s.nextID
+	s.tokens[hash] = token
+	return token.ID, nil
+}
+
+func (s *fakeAppTokenStore) AppToken(hash string) (AppToken, error) {
+	token, ok := s.tokens[hash]
+	if !ok {
+		return AppToken{}, ErrNotFound
+	}
+	return token, nil
+}
+
+func (s *fakeAppTokenStore) AppTokens() ([]AppToken, error) {
+	var tokens []AppToken
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (s *fakeAppTokenStore) RevokeAppToken(id int64) error {
+	for hash, token := range s.tokens {
+		if token.ID == id {
+			delete(s.tokens, hash)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func TestAppTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := NewAppTokens(&fakeAppTokenStore{tokens: map[string]AppToken{}}, testMe)
+
+	secret, created, err := tokens.Create("phone", []string{"media", "create", "media"}, time.Time{})
+	assert.Nil(err)
+	assert.Equal("phone", created.Label)
+	assert.Equal([]string{"create", "media"}, created.Scopes)
+	assert.Nil(created.ExpiresAt)
+
+	token, err := tokens.Verify(secret)
+	assert.Nil(err)
+	assert.Equal(Token{Me: testMe, ClientID: "phone", Scopes: []string{"create", "media"}}, token)
+
+	_, err = tokens.Verify("not-" + secret)
+	assert.ErrorIs(err, ErrInvalidToken)
+
+	assert.Nil(tokens.Revoke(created.ID))
+	_, err = tokens.Verify(secret)
+	assert.ErrorIs(err, ErrInvalidToken)
+}
+
+func TestAppTokensExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	tokens := NewAppTokens(&fakeAppTokenStore{tokens: map[string]AppToken{}}, testMe)
+	tokens.now = func() time.Time { return now }
+
+	secret, _, err := tokens.Create("cron", []string{"create"}, now.Add(time.Hour))
+	assert.Nil(err)
+
+	_, err = tokens.Verify(secret)
+	assert.Nil(err)
+
+	now = now.Add(time.Hour)
+	_, err = tokens.Verify(secret)
+	assert.ErrorIs(err, ErrInvalidToken)
+}
+
+func TestAppTokensCreateInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := NewAppTokens(&fakeAppTokenStore{tokens: map[string]AppToken{}}, testMe)
+
+	_, _, err := tokens.Create("", []string{"create"}, time.Time{})
+	assert.NotNil(err)
+
+	_, _, err = tokens.Create("phone", nil, time.Time{})
+	assert.NotNil(err)
+
+	_, _, err = tokens.Create("phone", []string{"create", "admin"}, time.Time{})
+	assert.ErrorIs(err, ErrUnknownScope)
+
+	_, _, err = tokens.Create("phone", []string{"create"}, time.Time{})
+	assert.Nil(err)
+
+	_, _, err = tokens.Create("phone", []string{"update"}, time.Time{})
+	assert.ErrorIs(err, ErrLabelTaken)
+}
+
+type fakeVerifier struct {
+	token Token
+	err   error
+	calls int
+}
+
+func (v *fakeVerifier) Verify(token string) (Token, error) {
+	v.calls++
+	return v.token, v.err
+}
+
+func TestVerifiers(t *testing.T) {
+	assert := assert.New(t)
+
+	failing := errors.New("endpoint down")
+
+	first := &fakeVerifier{token: Token{ClientID: "first"}}
+	second := &fakeVerifier{token: Token{ClientID: "second"}}
+	token, err := Verifiers{first, second}.Verify("abc")
+	assert.Nil(err)
+	assert.Equal("first", token.ClientID)
+	assert.Equal(0, second.calls)
+
+	first = &fakeVerifier{err: ErrInvalidToken}
+	token, err = Verifiers{first, second}.Verify("abc")
+	assert.Nil(err)
+	assert.Equal("second", token.ClientID)
+
+	_, err = Verifiers{first, &fakeVerifier{err: ErrInvalidToken}}.Verify("abc")
+	assert.ErrorIs(err, ErrInvalidToken)
+
+	_, err = Verifiers{first, &fakeVerifier{err: failing}}.Verify("abc")
+	assert.ErrorIs(err, failing)
+	assert.NotErrorIs(err, ErrInvalidToken)
+}
+
+func TestOnlyWithAppTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := NewAppTokens(&fakeAppTokenStore{tokens: map[string]AppToken{}}, testMe)
+	secret, _, _ := tokens.Create("bookmarklet", []string{"create"}, time.Time{})
+
+	verifier := Verifiers{tokens, &fakeVerifier{err: ErrInvalidToken}}
+
+	handler := &clientHandler{}
+	req := httptest.NewRequest("POST", "http://localhost/", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	OnlyWith(testMe, verifier, handler).ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("bookmarklet", handler.client)
+
+	req = httptest.NewRequest("POST", "http://localhost/", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	OnlyWith(testMe, verifier, &clientHandler{}).ServeHTTP(w, req)
+	assert.Equal(http.StatusForbidden, w.Code)
+}
@@ -5,101 +5,115 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
 
-	"hawx.me/code/indieauth"
+	"hawx.me/code/tally-ho/internal/apierror"
 )
 
+// Token is what an access token was issued for.
+type Token struct {
+	Me       string
+	ClientID string
+	Scopes   []string
+}
+
+// A Verifier checks an access token. If the token is not valid ErrInvalidToken
+// is returned.
+type Verifier interface {
+	Verify(token string) (Token, error)
+}
+
 // Only delegates handling the request to next only if the user specified by me
 // has provided authentication as expected by IndieAuth, either:
 //
 //   - passing a valid token as the 'access_token' form parameter, or
 //   - including a valid token in the Authorization header with a prefix of
 //     'Bearer'.
+//
+// The token is verified with the token endpoint advertised by me.
 func Only(me string, next http.Handler) http.HandlerFunc {
+	return OnlyWith(me, TokenEndpoint(me, EndpointOptions{}), next)
+}
 
+// OnlyWith is like Only, but verifies the token with verifier.
+func OnlyWith(me string, verifier Verifier, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		slog.Info("Finding indieauth endpoint", slog.String("me", me))
-		endpoints, err := indieauth.FindEndpoints(me)
-		if err != nil {
-			slog.Error("find indieauth endpoints", slog.Any("err", err))
+		accessToken := requestToken(r)
+		if accessToken == "" {
+			apierror.Write(w, apierror.Unauthorized("no access token was provided"))
 			return
 		}
 
-		auth := r.Header.Get("Authorization")
-		if auth == "" || strings.TrimSpace(auth) == "Bearer" {
-			if r.FormValue("access_token") == "" {
-				w.Header().Set("Content-Type", "application/json")
-				http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		token, err := verifier.Verify(accessToken)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				apierror.Write(w, fmt.Errorf("auth verify token: %w", err))
 				return
 			}
 
-			auth = "Bearer " + r.FormValue("access_token")
-		}
-
-		req, err := http.NewRequest("GET", endpoints.Token.String(), nil)
-		if err != nil {
-			slog.Error("auth make request failed", slog.Any("err", err))
-			http.Error(w, "", http.StatusInternalServerError)
+			apierror.Write(w, apierror.Forbidden("the access token is not valid"))
 			return
 		}
-		req.Header.Add("Authorization", auth)
-		req.Header.Add("Accept", "application/json")
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			slog.Error("auth request failed", slog.Any("err", err))
-			http.Error(w, "", http.StatusInternalServerError)
-			return
-		}
-		defer resp.Body.Close()
-
-		var tokenData struct {
-			Me       string `json:"me"`
-			ClientID string `json:"client_id"`
-			Scope    string `json:"scope"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&tokenData); err != nil {
-			slog.Error("auth decode token", slog.Any("err", err))
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
-			return
-		}
-
-		if tokenData.Me != me {
-			slog.Warn("token does not match user", slog.String("me", me), slog.String("token", tokenData.Me))
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
+		if token.Me != me {
+			slog.Warn("token does not match user", slog.String("me", me), slog.String("token", token.Me))
+			apierror.Write(w, apierror.Forbidden("the access token was not issued for this site"))
 			return
 		}
 
 		next.ServeHTTP(w, r.WithContext(
 			context.WithValue(context.WithValue(r.Context(),
-				scopesKey, strings.Fields(tokenData.Scope)),
-				clientKey, tokenData.ClientID,
+				scopesKey, token.Scopes),
+				clientKey, token.ClientID,
 			),
 		))
 	}
 }
 
-func BypassAuth(me string, next http.Handler) http.HandlerFunc {
-	var tokenData struct {
-		Me       string `json:"me"`
-		ClientID string `json:"client_id"`
-		Scope    string `json:"scope"`
+// IsOwner checks whether r was made with a token, provided in the same way as
+// for Only, that verifier accepts as being for me. No response is written, so
+// it can be used to show more to the owner of a page than to anyone else.
+func IsOwner(me string, verifier Verifier, r *http.Request) bool {
+	accessToken := requestToken(r)
+	if accessToken == "" {
+		return false
 	}
-	tokenData.Me = me
-	tokenData.ClientID = "sparkles"
-	tokenData.Scope = "create"
 
+	token, err := verifier.Verify(accessToken)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidToken) {
+			slog.Error("auth verify token", slog.Any("err", err))
+		}
+		return false
+	}
+
+	return token.Me == me
+}
+
+// requestToken returns the access token from the Authorization header, or the
+// access_token form parameter.
+func requestToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" || strings.TrimSpace(auth) == "Bearer" {
+		return r.FormValue("access_token")
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// BypassAuth delegates handling the request to next as if it had been made by
+// me with a token allowing every scope. It must only be used when developing
+// locally.
+func BypassAuth(me string, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		next.ServeHTTP(w, r.WithContext(
 			context.WithValue(context.WithValue(r.Context(),
-				scopesKey, strings.Fields(tokenData.Scope)),
-				clientKey, tokenData.ClientID,
+				scopesKey, AllScopes()),
+				clientKey, "sparkles",
 			),
 		))
 	}
@@ -108,28 +122,6 @@ func BypassAuth(me string, next http.Handler) http.HandlerFunc {
 const scopesKey = "__hawx.me/code/tally-ho:Scopes__"
 const clientKey = "__hawx.me/code/tally-ho:ClientID__"
 
-// HasScope checks that a request, authenticated with Only, contains one of the
-// listed valid scopes.
-func HasScope(w http.ResponseWriter, r *http.Request, valid ...string) bool {
-	rv := r.Context().Value(scopesKey)
-	if rv == nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"insufficient_scope"}`, http.StatusUnauthorized)
-		return false
-	}
-
-	scopes := rv.([]string)
-
-	hasScope := intersects(valid, scopes)
-	if !hasScope {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"insufficient_scope"}`, http.StatusUnauthorized)
-		return false
-	}
-
-	return true
-}
-
 // ClientID returns the clientId that was issued for the token in a request that
 // has been authenticated with Only.
 func ClientID(r *http.Request) string {
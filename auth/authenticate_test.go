@@ -263,3 +263,23 @@ func TestAuthenticateClientID(t *testing.T) {
 		})
 	}
 }
+
+func TestIsOwner(t *testing.T) {
+	owner := &fakeVerifier{token: Token{Me: testMe}}
+	other := &fakeVerifier{token: Token{Me: "https://other.example.com/"}}
+	invalid := &fakeVerifier{err: ErrInvalidToken}
+
+	for name, req := range testCases("?access_token=abcde", "abcde") {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			assert.True(IsOwner(testMe, owner, req))
+			assert.False(IsOwner(testMe, other, req))
+			assert.False(IsOwner(testMe, invalid, req))
+		})
+	}
+
+	calls := owner.calls
+	assert.False(t, IsOwner(testMe, owner, httptest.NewRequest("GET", "http://localhost/", nil)))
+	assert.Equal(t, calls, owner.calls)
+}
@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"hawx.me/code/tally-ho/internal/fetch"
+	"hawx.me/code/tally-ho/internal/htmlutil"
+)
+
+const (
+	// DefaultDiscoveryTTL is how long the endpoints advertised by me are
+	// remembered for, unless EndpointOptions says otherwise.
+	DefaultDiscoveryTTL = time.Hour
+
+	// DefaultTokenTTL is how long a verified token is remembered for, unless
+	// EndpointOptions says otherwise or the token expires sooner.
+	DefaultTokenTTL = 2 * time.Minute
+
+	// maxDiscoverySize limits how much of the page at me is read to find links.
+	maxDiscoverySize = 1 << 20
+)
+
+// ErrNoTokenEndpoint is returned when me does not advertise a token endpoint.
+var ErrNoTokenEndpoint = errors.New("no token endpoint found")
+
+// EndpointOptions configures the Verifier returned by TokenEndpoint.
+type EndpointOptions struct {
+	// Client is used to make requests, if nil a client that gives up after
+	// fetch.Timeout is used.
+	Client *http.Client
+
+	// DiscoveryTTL is how long endpoints are remembered, if zero
+	// DefaultDiscoveryTTL is used.
+	DiscoveryTTL time.Duration
+
+	// TokenTTL is how long a verified token is remembered, if zero
+	// DefaultTokenTTL is used. A negative value disables remembering tokens.
+	TokenTTL time.Duration
+
+	// IntrospectionToken is sent as a Bearer token to the introspection
+	// endpoint, for servers that require requests to it to be authorized.
+	IntrospectionToken string
+}
+
+// TokenEndpoint returns a Verifier that checks tokens with the endpoints
+// advertised by me.
+//
+// If me advertises IndieAuth server metadata with an introspection_endpoint the
+// token is checked by POSTing it there, otherwise it is checked with a GET to
+// the token_endpoint. The endpoints found and the tokens verified are
+// remembered, see EndpointOptions.
+func TokenEndpoint(me string, options EndpointOptions) Verifier {
+	v := &endpointVerifier{
+		me:                 me,
+		client:             options.Client,
+		discoveryTTL:       options.DiscoveryTTL,
+		tokenTTL:           options.TokenTTL,
+		introspectionToken: options.IntrospectionToken,
+		now:                time.Now,
+		tokens:             map[string]cachedToken{},
+	}
+
+	if v.client == nil {
+		v.client = &http.Client{Timeout: fetch.Timeout}
+	}
+	if v.discoveryTTL == 0 {
+		v.discoveryTTL = DefaultDiscoveryTTL
+	}
+	if v.tokenTTL == 0 {
+		v.tokenTTL = DefaultTokenTTL
+	}
+
+	return v
+}
+
+type endpointVerifier struct {
+	me                 string
+	client             *http.Client
+	discoveryTTL       time.Duration
+	tokenTTL           time.Duration
+	introspectionToken string
+	now                func() time.Time
+
+	mu              sync.Mutex
+	endpoints       endpoints
+	endpointsExpire time.Time
+	tokens          map[string]cachedToken
+}
+
+type endpoints struct {
+	token         string
+	introspection string
+}
+
+type cachedToken struct {
+	token   Token
+	expires time.Time
+}
+
+// tokenResponse is the body returned by both a token endpoint, for a GET, and
+// an introspection endpoint.
+type tokenResponse struct {
+	Active   *bool  `json:"active"`
+	Me       string `json:"me"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Exp      int64  `json:"exp"`
+}
+
+func (v *endpointVerifier) Verify(token string) (Token, error) {
+	key := hashSecret(token)
+
+	v.mu.Lock()
+	cached, ok := v.tokens[key]
+	v.mu.Unlock()
+
+	if ok && v.now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	found, err := v.discover()
+	if err != nil {
+		return Token{}, err
+	}
+
+	var data tokenResponse
+	if found.introspection != "" {
+		data, err = v.introspect(found.introspection, token)
+	} else {
+		data, err = v.check(found.token, token)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrInvalidToken) {
+			// the endpoints may have moved, so look for them again next time
+			v.forgetEndpoints()
+		}
+		return Token{}, err
+	}
+
+	now := v.now()
+	expires := now.Add(v.tokenTTL)
+	if data.Exp > 0 {
+		exp := time.Unix(data.Exp, 0)
+		if !now.Before(exp) {
+			return Token{}, ErrInvalidToken
+		}
+		if exp.Before(expires) {
+			expires = exp
+		}
+	}
+
+	verified := Token{
+		Me:       data.Me,
+		ClientID: data.ClientID,
+		Scopes:   strings.Fields(data.Scope),
+	}
+
+	if v.tokenTTL > 0 {
+		v.mu.Lock()
+		for k, t := range v.tokens {
+			if !now.Before(t.expires) {
+				delete(v.tokens, k)
+			}
+		}
+		v.tokens[key] = cachedToken{token: verified, expires: expires}
+		v.mu.Unlock()
+	}
+
+	return verified, nil
+}
+
+// check verifies token with a GET to the token endpoint.
+func (v *endpointVerifier) check(endpoint, token string) (tokenResponse, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("make request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Accept", "application/json")
+
+	return v.tokenRequest(req)
+}
+
+// introspect verifies token with a POST to the introspection endpoint, see
+// https://indieauth.spec.indieweb.org/#access-token-verification.
+func (v *endpointVerifier) introspect(endpoint, token string) (tokenResponse, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(url.Values{
+		"token": {token},
+	}.Encode()))
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("make request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	if v.introspectionToken != "" {
+		req.Header.Add("Authorization", "Bearer "+v.introspectionToken)
+	}
+
+	data, err := v.tokenRequest(req)
+	if err != nil {
+		return data, err
+	}
+	if data.Active == nil || !*data.Active {
+		return data, ErrInvalidToken
+	}
+
+	return data, nil
+}
+
+func (v *endpointVerifier) tokenRequest(req *http.Request) (tokenResponse, error) {
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return tokenResponse{}, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		return tokenResponse{}, ErrInvalidToken
+	}
+
+	var data tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return tokenResponse{}, ErrInvalidToken
+	}
+
+	return data, nil
+}
+
+func (v *endpointVerifier) forgetEndpoints() {
+	v.mu.Lock()
+	v.endpointsExpire = time.Time{}
+	v.mu.Unlock()
+}
+
+// discover returns the endpoints advertised by me, using those found previously
+// if they have not expired.
+func (v *endpointVerifier) discover() (endpoints, error) {
+	v.mu.Lock()
+	found, expires := v.endpoints, v.endpointsExpire
+	v.mu.Unlock()
+
+	if v.now().Before(expires) {
+		return found, nil
+	}
+
+	found, err := v.findEndpoints()
+	if err != nil {
+		return endpoints{}, fmt.Errorf("find indieauth endpoints: %w", err)
+	}
+
+	v.mu.Lock()
+	v.endpoints = found
+	v.endpointsExpire = v.now().Add(v.discoveryTTL)
+	v.mu.Unlock()
+
+	return found, nil
+}
+
+// findEndpoints requests me looking for, in order of preference, an
+// indieauth-metadata link or a token_endpoint link, in either the Link header
+// or the HTML.
+func (v *endpointVerifier) findEndpoints() (endpoints, error) {
+	resp, err := v.client.Get(v.me)
+	if err != nil {
+		return endpoints{}, err
+	}
+	defer resp.Body.Close()
+
+	base := resp.Request.URL
+	links := map[string]string{}
+
+	for _, link := range linkheader.ParseMultiple(resp.Header["Link"]) {
+		for _, rel := range strings.Fields(link.Rel) {
+			if _, ok := links[rel]; !ok {
+				links[rel] = link.URL
+			}
+		}
+	}
+
+	root, err := html.Parse(io.LimitReader(resp.Body, maxDiscoverySize))
+	if err != nil {
+		return endpoints{}, err
+	}
+
+	for _, node := range htmlutil.SearchAll(root, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.DataAtom == atom.Link && htmlutil.Has(node, "href")
+	}) {
+		for _, rel := range strings.Fields(htmlutil.Attr(node, "rel")) {
+			if _, ok := links[rel]; !ok {
+				links[rel] = htmlutil.Attr(node, "href")
+			}
+		}
+	}
+
+	if href, ok := links["indieauth-metadata"]; ok {
+		metadataURL, err := resolve(base, href)
+		if err != nil {
+			return endpoints{}, err
+		}
+
+		return v.fetchMetadata(metadataURL)
+	}
+
+	if href, ok := links["token_endpoint"]; ok {
+		tokenURL, err := resolve(base, href)
+		if err != nil {
+			return endpoints{}, err
+		}
+
+		return endpoints{token: tokenURL.String()}, nil
+	}
+
+	return endpoints{}, ErrNoTokenEndpoint
+}
+
+// fetchMetadata reads the endpoints from an IndieAuth server metadata
+// document, see https://indieauth.spec.indieweb.org/#indieauth-server-metadata.
+func (v *endpointVerifier) fetchMetadata(metadataURL *url.URL) (endpoints, error) {
+	req, err := http.NewRequest("GET", metadataURL.String(), nil)
+	if err != nil {
+		return endpoints{}, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return endpoints{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return endpoints{}, fmt.Errorf("metadata returned %d", resp.StatusCode)
+	}
+
+	var metadata struct {
+		TokenEndpoint         string `json:"token_endpoint"`
+		IntrospectionEndpoint string `json:"introspection_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return endpoints{}, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	if metadata.TokenEndpoint == "" && metadata.IntrospectionEndpoint == "" {
+		return endpoints{}, ErrNoTokenEndpoint
+	}
+
+	return endpoints{
+		token:         metadata.TokenEndpoint,
+		introspection: metadata.IntrospectionEndpoint,
+	}, nil
+}
+
+func resolve(base *url.URL, href string) (*url.URL, error) {
+	hrefURL, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil, err
+	}
+
+	return base.ResolveReference(hrefURL), nil
+}
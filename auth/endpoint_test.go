@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/internal/fetch"
+)
+
+func TestTokenEndpointCaches(t *testing.T) {
+	assert := assert.New(t)
+
+	var pages, verifies atomic.Int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/verify" {
+			verifies.Add(1)
+			if r.Header.Get("Authorization") != "Bearer abcde" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"me":"https://me.example.com","client_id":"https://client.example.com/","scope":"create"}`)
+			return
+		}
+
+		pages.Add(1)
+		w.Header().Set("Link", `</verify>; rel="token_endpoint"`)
+	}))
+	defer s.Close()
+
+	verifier := TokenEndpoint(s.URL, EndpointOptions{}).(*endpointVerifier)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	for range 3 {
+		token, err := verifier.Verify("abcde")
+		assert.Nil(err)
+		assert.Equal("https://me.example.com", token.Me)
+		assert.Equal([]string{"create"}, token.Scopes)
+	}
+	assert.Equal(int32(1), pages.Load())
+	assert.Equal(int32(1), verifies.Load())
+
+	_, err := verifier.Verify("wrong")
+	assert.ErrorIs(err, ErrInvalidToken)
+	assert.Equal(int32(1), pages.Load())
+	assert.Equal(int32(2), verifies.Load())
+
+	now = now.Add(DefaultTokenTTL)
+	_, err = verifier.Verify("abcde")
+	assert.Nil(err)
+	assert.Equal(int32(1), pages.Load())
+	assert.Equal(int32(3), verifies.Load())
+
+	now = now.Add(DefaultDiscoveryTTL)
+	_, err = verifier.Verify("abcde")
+	assert.Nil(err)
+	assert.Equal(int32(2), pages.Load())
+	assert.Equal(int32(4), verifies.Load())
+}
+
+func TestTokenEndpointCachesUntilExp(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	exp := now.Add(30 * time.Second)
+	var verifies atomic.Int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/verify" {
+			verifies.Add(1)
+			fmt.Fprintf(w, `{"me":"https://me.example.com","scope":"create","exp":%d}`, exp.Unix())
+			return
+		}
+
+		fmt.Fprint(w, `<link rel="token_endpoint" href="/verify" />`)
+	}))
+	defer s.Close()
+
+	verifier := TokenEndpoint(s.URL, EndpointOptions{}).(*endpointVerifier)
+	verifier.now = func() time.Time { return now }
+
+	_, err := verifier.Verify("abcde")
+	assert.Nil(err)
+
+	now = now.Add(20 * time.Second)
+	_, err = verifier.Verify("abcde")
+	assert.Nil(err)
+	assert.Equal(int32(1), verifies.Load())
+
+	now = now.Add(20 * time.Second)
+	_, err = verifier.Verify("abcde")
+	assert.ErrorIs(err, ErrInvalidToken)
+	assert.Equal(int32(2), verifies.Load())
+}
+
+func TestTokenEndpointIntrospection(t *testing.T) {
+	assert := assert.New(t)
+
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			fmt.Fprintf(w, `{"issuer":"%[1]s/","token_endpoint":"%[1]s/token","introspection_endpoint":"%[1]s/introspect"}`, s.URL)
+
+		case "/introspect":
+			if r.Method != "POST" || r.Header.Get("Authorization") != "Bearer server-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if r.FormValue("token") == "abcde" {
+				fmt.Fprint(w, `{"active":true,"me":"https://me.example.com","client_id":"https://client.example.com/","scope":"create update"}`)
+			} else {
+				fmt.Fprint(w, `{"active":false}`)
+			}
+
+		case "/token":
+			t.Error("legacy token endpoint should not be used")
+
+		default:
+			fmt.Fprint(w, `
+      <link rel="token_endpoint" href="/token" />
+      <link rel="indieauth-metadata" href="/metadata" />
+    `)
+		}
+	}))
+	defer s.Close()
+
+	verifier := TokenEndpoint(s.URL, EndpointOptions{IntrospectionToken: "server-secret"})
+
+	token, err := verifier.Verify("abcde")
+	assert.Nil(err)
+	assert.Equal(Token{
+		Me:       "https://me.example.com",
+		ClientID: "https://client.example.com/",
+		Scopes:   []string{"create", "update"},
+	}, token)
+
+	_, err = verifier.Verify("wrong")
+	assert.ErrorIs(err, ErrInvalidToken)
+}
+
+func TestTokenEndpointNoEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>nothing here</p>`)
+	}))
+	defer s.Close()
+
+	_, err := TokenEndpoint(s.URL, EndpointOptions{}).Verify("abcde")
+	assert.ErrorIs(err, ErrNoTokenEndpoint)
+}
+
+func TestTokenEndpointDefaultClientHasTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	v := TokenEndpoint("https://me.example.com/", EndpointOptions{}).(*endpointVerifier)
+	assert.Equal(fetch.Timeout, v.client.Timeout)
+
+	client := &http.Client{}
+	v = TokenEndpoint("https://me.example.com/", EndpointOptions{Client: client}).(*endpointVerifier)
+	assert.Same(client, v.client)
+}
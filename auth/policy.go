@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"hawx.me/code/tally-ho/internal/apierror"
+)
+
+// An Operation is something that can be done with a token.
+type Operation string
+
+const (
+	Create   Operation = "create"
+	Update   Operation = "update"
+	Delete   Operation = "delete"
+	Undelete Operation = "undelete"
+	Upload   Operation = "media"
+
+	// Admin is managing the site, for instance moderating mentions or creating
+	// app tokens, with the /-/admin endpoints.
+	Admin Operation = "admin"
+
+	QueryCategory      Operation = "q=category"
+	QueryConfig        Operation = "q=config"
+	QueryContact       Operation = "q=contact"
+	QueryMediaEndpoint Operation = "q=media-endpoint"
+	QuerySource        Operation = "q=source"
+	QuerySyndicateTo   Operation = "q=syndicate-to"
+)
+
+// policy lists the scopes that allow each operation, a token needs only one of
+// them. Operations with no scopes are allowed for any token.
+var policy = map[Operation][]string{
+	Create:   {"create"},
+	Update:   {"update"},
+	Delete:   {"delete"},
+	Undelete: {"undelete"},
+	Upload:   {"media", "create"},
+
+	// app tokens can never be given this scope, see AppScopes
+	Admin: {"admin"},
+
+	QueryCategory:      nil,
+	QueryConfig:        nil,
+	QueryContact:       nil,
+	QueryMediaEndpoint: nil,
+	// the source of an entry can include properties that are not shown
+	// publicly, so only clients that could edit it can read it
+	QuerySource:      {"update"},
+	QuerySyndicateTo: nil,
+}
+
+// Scopes returns the scopes that allow op, any one of which is sufficient. If
+// none are returned op is allowed for any token.
+func Scopes(op Operation) []string {
+	return policy[op]
+}
+
+// AllScopes returns every scope that is used by the policy.
+func AllScopes() []string {
+	var scopes []string
+	for _, allowed := range policy {
+		for _, scope := range allowed {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	slices.Sort(scopes)
+	return scopes
+}
+
+// Allow checks that a request, authenticated with Only, was made with a token
+// that permits op. If it was not an insufficient_scope error is written.
+func Allow(w http.ResponseWriter, r *http.Request, op Operation) bool {
+	return HasScope(w, r, Scopes(op)...)
+}
+
+// Require delegates handling the request to next only if it was made with a
+// token that permits op, it must be wrapped by Only.
+func Require(op Operation, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Allow(w, r, op) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// HasScope checks that a request, authenticated with Only, contains one of the
+// listed valid scopes. If it does not an insufficient_scope error is written,
+// with the scopes that would be accepted. When no scopes are listed any
+// authenticated request is allowed.
+func HasScope(w http.ResponseWriter, r *http.Request, valid ...string) bool {
+	if len(valid) == 0 {
+		return true
+	}
+
+	scopes, _ := r.Context().Value(scopesKey).([]string)
+
+	if !intersects(valid, scopes) {
+		apierror.Write(w, apierror.InsufficientScope(valid...))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	testCases := []struct {
+		op      Operation
+		scopes  []string
+		allowed bool
+	}{
+		{Create, []string{"create"}, true},
+		{Create, []string{"update", "delete"}, false},
+		{Update, []string{"create", "update"}, true},
+		{Update, []string{"create"}, false},
+		{Delete, []string{"delete"}, true},
+		{Delete, []string{"undelete"}, false},
+		{Undelete, []string{"undelete"}, true},
+		{Undelete, []string{"delete"}, false},
+		{Upload, []string{"media"}, true},
+		{Upload, []string{"create"}, true},
+		{Upload, []string{"update"}, false},
+		{Admin, []string{"admin"}, true},
+		{Admin, []string{"create", "update", "delete", "undelete", "media"}, false},
+		{QuerySource, []string{"update"}, true},
+		{QuerySource, []string{"create"}, false},
+		{QueryCategory, nil, true},
+		{QueryConfig, nil, true},
+		{QueryContact, nil, true},
+		{QueryMediaEndpoint, nil, true},
+		{QuerySyndicateTo, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.op), func(t *testing.T) {
+			assert := assert.New(t)
+
+			var allowed bool
+			handler := withScopes(tc.scopes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				allowed = Allow(w, r, tc.op)
+			}))
+
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Authorization", "Bearer abcde")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(tc.allowed, allowed)
+			if tc.allowed {
+				assert.Equal(http.StatusOK, w.Code)
+				return
+			}
+
+			assert.Equal(http.StatusUnauthorized, w.Code)
+			assert.Equal("application/json", w.Header().Get("Content-Type"))
+			assert.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+
+			var v struct {
+				Error string `json:"error"`
+				Scope string `json:"scope"`
+			}
+			assert.Nil(json.NewDecoder(w.Body).Decode(&v))
+			assert.Equal("insufficient_scope", v.Error)
+			assert.Equal(Scopes(tc.op), strings.Fields(v.Scope))
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	assert := assert.New(t)
+
+	var called int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer abcde")
+
+	w := httptest.NewRecorder()
+	withScopes([]string{"create", "update"}, Require(Admin, next)).ServeHTTP(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal(0, called)
+
+	w = httptest.NewRecorder()
+	withScopes([]string{"admin"}, Require(Admin, next)).ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(1, called)
+}
+
+func TestBypassAuthAllowsEverything(t *testing.T) {
+	assert := assert.New(t)
+
+	for op := range policy {
+		var allowed bool
+		handler := BypassAuth(testMe, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed = Allow(w, r, op)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		assert.True(allowed, op)
+	}
+}
+
+func withScopes(scopes []string, next http.Handler) http.Handler {
+	return OnlyWith(testMe, &fakeVerifier{token: Token{Me: testMe, Scopes: scopes}}, next)
+}
@@ -0,0 +1,572 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"hawx.me/code/tally-ho/internal/apierror"
+	"hawx.me/code/tally-ho/internal/page"
+)
+
+const (
+	// codeLifetime is how long an authorization code can be redeemed for.
+	codeLifetime = 10 * time.Minute
+
+	// maxFailedLogins is the number of incorrect logins allowed from a client
+	// before it is refused, until lockoutDuration after the last incorrect login.
+	maxFailedLogins = 5
+	lockoutDuration = time.Minute
+)
+
+var (
+	// ErrNotFound is returned by a Store when a code or token does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidToken is returned when verifying a token that was not issued,
+	// has expired or has been revoked.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Grant is what the owner has allowed a client, it is recorded against an
+// authorization code and then the token the code is exchanged for.
+type Grant struct {
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	CreatedAt     time.Time
+	// ExpiresAt is when the grant can no longer be used, if zero it does not
+	// expire.
+	ExpiresAt time.Time
+}
+
+func (g Grant) expired(now time.Time) bool {
+	return !g.ExpiresAt.IsZero() && !now.Before(g.ExpiresAt)
+}
+
+// Store persists the authorization codes and tokens issued by a Server. They
+// are only ever given a hash of the code or token.
+type Store interface {
+	CreateCode(hash string, grant Grant) error
+
+	// RedeemCode deletes the code, returning the grant it was created with, so
+	// that a code can only be used once.
+	RedeemCode(hash string) (Grant, error)
+
+	CreateToken(hash string, grant Grant) error
+	Token(hash string) (Grant, error)
+	RevokeToken(hash string) error
+}
+
+// ServerOptions configures a Server. At least one of PasswordHash or TOTPSecret
+// must be given for the owner to be able to log in.
+type ServerOptions struct {
+	// Me is the URL of the owner, the only user that can log in.
+	Me string
+	// Issuer is the URL identifying the server, returned to clients as iss.
+	Issuer string
+	// PasswordHash is a bcrypt hash of the password used to log in.
+	PasswordHash string
+	// TOTPSecret is the base32 secret for time-based one-time codes used to log
+	// in.
+	TOTPSecret string
+}
+
+// Server is an IndieAuth authorization and token endpoint for the single owner
+// of the blog, see https://indieauth.spec.indieweb.org/.
+type Server struct {
+	me           string
+	issuer       string
+	store        Store
+	passwordHash []byte
+	totpSecret   []byte
+	now          func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]failedLogins
+	// lastTOTPStep is the step of the last code used to log in, so that it, or
+	// an earlier code, can not be used again.
+	lastTOTPStep int64
+}
+
+// failedLogins counts the incorrect logins from a client, forgetting them at
+// expires.
+type failedLogins struct {
+	count   int
+	expires time.Time
+}
+
+// NewServer creates a Server that records codes and tokens in store.
+func NewServer(store Store, options ServerOptions) (*Server, error) {
+	if options.PasswordHash == "" && options.TOTPSecret == "" {
+		return nil, errors.New("auth: a password hash or TOTP secret is required")
+	}
+
+	s := &Server{
+		me:       options.Me,
+		issuer:   options.Issuer,
+		store:    store,
+		now:      time.Now,
+		failures: map[string]failedLogins{},
+	}
+
+	if options.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(options.PasswordHash)); err != nil {
+			return nil, errors.New("auth: password hash is not a bcrypt hash")
+		}
+		s.passwordHash = []byte(options.PasswordHash)
+	}
+
+	if options.TOTPSecret != "" {
+		secret, err := decodeTOTPSecret(options.TOTPSecret)
+		if err != nil {
+			return nil, errors.New("auth: TOTP secret is not base32")
+		}
+		s.totpSecret = secret
+	}
+
+	return s, nil
+}
+
+// Verify checks that token was issued by the server and has not expired or
+// been revoked.
+func (s *Server) Verify(token string) (Token, error) {
+	grant, err := s.store.Token(hashSecret(token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Token{}, ErrInvalidToken
+		}
+		return Token{}, err
+	}
+
+	if grant.expired(s.now()) {
+		return Token{}, ErrInvalidToken
+	}
+
+	return Token{
+		Me:       s.me,
+		ClientID: grant.ClientID,
+		Scopes:   strings.Fields(grant.Scope),
+	}, nil
+}
+
+// Authorization returns the handler for the authorization endpoint.
+//
+// A GET request shows the owner a page to log in and choose which of the
+// requested scopes to grant, submitting this will redirect back to the client
+// with a code. A POST request with grant_type=authorization_code redeems a code
+// for the profile URL of the owner.
+func (s *Server) Authorization() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.showConsent(w, r)
+		case http.MethodPost:
+			if r.FormValue("grant_type") != "" {
+				s.redeemProfile(w, r)
+			} else {
+				s.consent(w, r)
+			}
+		default:
+			w.Header().Set("Accept", "GET,POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Token returns the handler for the token endpoint.
+//
+// A POST request with grant_type=authorization_code exchanges a code for an
+// access token, and one with action=revoke revokes the given token. A GET
+// request with a token in the Authorization header returns the details of
+// the token.
+func (s *Server) Token() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.verifyToken(w, r)
+		case http.MethodPost:
+			if r.FormValue("action") == "revoke" {
+				s.revoke(w, r)
+			} else {
+				s.exchange(w, r)
+			}
+		default:
+			w.Header().Set("Accept", "GET,POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+type authorizationRequest struct {
+	ClientID      string
+	RedirectURI   string
+	State         string
+	Scope         string
+	CodeChallenge string
+}
+
+func (req authorizationRequest) params() map[string]string {
+	return map[string]string{
+		"response_type":         "code",
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"state":                 req.State,
+		"requested_scope":       req.Scope,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": "S256",
+	}
+}
+
+func parseAuthorizationRequest(r *http.Request) (authorizationRequest, error) {
+	req := authorizationRequest{
+		ClientID:      r.FormValue("client_id"),
+		RedirectURI:   r.FormValue("redirect_uri"),
+		State:         r.FormValue("state"),
+		Scope:         r.FormValue("scope"),
+		CodeChallenge: r.FormValue("code_challenge"),
+	}
+	// when submitting the consent page scope is the list of granted scopes, so
+	// the original request is passed separately
+	if r.Method == http.MethodPost {
+		req.Scope = r.FormValue("requested_scope")
+	}
+
+	if responseType := r.FormValue("response_type"); responseType != "code" {
+		return req, errors.New("response_type must be code")
+	}
+
+	clientURL, err := url.Parse(req.ClientID)
+	if err != nil || (clientURL.Scheme != "http" && clientURL.Scheme != "https") || clientURL.Host == "" {
+		return req, errors.New("client_id must be a url")
+	}
+
+	redirectURL, err := url.Parse(req.RedirectURI)
+	if err != nil || (redirectURL.Scheme != "http" && redirectURL.Scheme != "https") {
+		return req, errors.New("redirect_uri must be a url")
+	}
+
+	// a client could publish other redirect URIs, but to allow those would
+	// require fetching its metadata
+	if redirectURL.Scheme != clientURL.Scheme || redirectURL.Host != clientURL.Host {
+		return req, errors.New("redirect_uri must be on the same host as client_id")
+	}
+
+	if req.CodeChallenge == "" || r.FormValue("code_challenge_method") != "S256" {
+		return req, errors.New("code_challenge with a code_challenge_method of S256 is required")
+	}
+
+	return req, nil
+}
+
+func (s *Server) showConsent(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAuthorizationRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.renderConsent(w, req, http.StatusOK, "")
+}
+
+func (s *Server) renderConsent(w http.ResponseWriter, req authorizationRequest, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	if _, err := page.Consent(page.ConsentData{
+		ClientID:    req.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scopes:      strings.Fields(req.Scope),
+		Params:      req.params(),
+		Password:    s.passwordHash != nil,
+		TOTP:        s.totpSecret != nil,
+		Error:       message,
+	}).WriteTo(w); err != nil {
+		slog.Error("render consent", slog.Any("err", err))
+	}
+}
+
+func (s *Server) consent(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAuthorizationRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redirectURL, _ := url.Parse(req.RedirectURI)
+	query := redirectURL.Query()
+	query.Set("state", req.State)
+	query.Set("iss", s.issuer)
+
+	if r.FormValue("action") != "approve" {
+		query.Set("error", "access_denied")
+		redirectURL.RawQuery = query.Encode()
+		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+		return
+	}
+
+	if err := s.login(clientAddr(r), r.FormValue("password"), r.FormValue("totp")); err != nil {
+		slog.Warn("failed login", slog.String("client_id", req.ClientID), slog.Any("err", err))
+		s.renderConsent(w, req, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	requested := strings.Fields(req.Scope)
+	var granted []string
+	for _, scope := range r.Form["scope"] {
+		if slices.Contains(requested, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	code, err := randomSecret()
+	if err != nil {
+		slog.Error("generate code", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	now := s.now()
+	if err := s.store.CreateCode(hashSecret(code), Grant{
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(granted, " "),
+		CodeChallenge: req.CodeChallenge,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(codeLifetime),
+	}); err != nil {
+		slog.Error("create code", slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	query.Set("code", code)
+	redirectURL.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+var (
+	errLockedOut          = errors.New("too many failed attempts, try again later")
+	errInvalidCredentials = errors.New("incorrect password or code")
+)
+
+// login checks the credentials given by the client at addr, all configured
+// methods must succeed.
+func (s *Server) login(addr, password, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for client, failed := range s.failures {
+		if !now.Before(failed.expires) {
+			delete(s.failures, client)
+		}
+	}
+
+	failed := s.failures[addr]
+	if failed.count >= maxFailedLogins {
+		return errLockedOut
+	}
+
+	ok := true
+	if s.passwordHash != nil && bcrypt.CompareHashAndPassword(s.passwordHash, []byte(password)) != nil {
+		ok = false
+	}
+
+	var step int64
+	if s.totpSecret != nil {
+		var matched bool
+		step, matched = matchTOTP(s.totpSecret, code, now)
+		if !matched || step <= s.lastTOTPStep {
+			ok = false
+		}
+	}
+
+	if !ok {
+		s.failures[addr] = failedLogins{count: failed.count + 1, expires: now.Add(lockoutDuration)}
+		return errInvalidCredentials
+	}
+
+	delete(s.failures, addr)
+	if s.totpSecret != nil {
+		s.lastTOTPStep = step
+	}
+	return nil
+}
+
+// clientAddr returns the address of the client making r, for limiting failed
+// logins.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// redeem checks the code in the request, returning the grant it was created
+// for.
+func (s *Server) redeem(r *http.Request) (Grant, error) {
+	if grantType := r.FormValue("grant_type"); grantType != "authorization_code" {
+		return Grant{}, errUnsupportedGrantType
+	}
+
+	grant, err := s.store.RedeemCode(hashSecret(r.FormValue("code")))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return grant, errInvalidGrant
+		}
+		return grant, err
+	}
+
+	if grant.expired(s.now()) ||
+		grant.ClientID != r.FormValue("client_id") ||
+		grant.RedirectURI != r.FormValue("redirect_uri") ||
+		!validVerifier(r.FormValue("code_verifier"), grant.CodeChallenge) {
+		return grant, errInvalidGrant
+	}
+
+	return grant, nil
+}
+
+var (
+	errUnsupportedGrantType = errors.New("unsupported_grant_type")
+	errInvalidGrant         = errors.New("invalid_grant")
+)
+
+func (s *Server) redeemProfile(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.redeem(r); err != nil {
+		writeRedeemError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"me": s.me})
+}
+
+func (s *Server) exchange(w http.ResponseWriter, r *http.Request) {
+	grant, err := s.redeem(r)
+	if err != nil {
+		writeRedeemError(w, err)
+		return
+	}
+
+	if grant.Scope == "" {
+		apierror.Write(w, apierror.New(http.StatusBadRequest, "invalid_grant", "an access token can not be issued without scope"))
+		return
+	}
+
+	token, err := randomSecret()
+	if err != nil {
+		slog.Error("generate token", slog.Any("err", err))
+		apierror.Write(w, apierror.ServerError())
+		return
+	}
+
+	if err := s.store.CreateToken(hashSecret(token), Grant{
+		ClientID:  grant.ClientID,
+		Scope:     grant.Scope,
+		CreatedAt: s.now(),
+	}); err != nil {
+		slog.Error("create token", slog.Any("err", err))
+		apierror.Write(w, apierror.ServerError())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"scope":        grant.Scope,
+		"me":           s.me,
+	})
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.RevokeToken(hashSecret(r.FormValue("token"))); err != nil && !errors.Is(err, ErrNotFound) {
+		slog.Error("revoke token", slog.Any("err", err))
+		apierror.Write(w, apierror.ServerError())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) verifyToken(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	info, err := s.Verify(strings.TrimSpace(token))
+	if err != nil {
+		if !errors.Is(err, ErrInvalidToken) {
+			slog.Error("verify token", slog.Any("err", err))
+		}
+		apierror.Write(w, apierror.Unauthorized(""))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"me":        info.Me,
+		"client_id": info.ClientID,
+		"scope":     strings.Join(info.Scopes, " "),
+	})
+}
+
+func writeRedeemError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedGrantType):
+		apierror.Write(w, apierror.New(http.StatusBadRequest, "unsupported_grant_type", ""))
+	case errors.Is(err, errInvalidGrant):
+		apierror.Write(w, apierror.New(http.StatusBadRequest, "invalid_grant", ""))
+	default:
+		slog.Error("redeem code", slog.Any("err", err))
+		apierror.Write(w, apierror.ServerError())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validVerifier checks the PKCE code_verifier against the S256 challenge, see
+// https://www.rfc-editor.org/rfc/rfc7636#section-4.6.
+func validVerifier(verifier, challenge string) bool {
+	if verifier == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,489 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeStore struct {
+	mu     sync.Mutex
+	codes  map[string]Grant
+	tokens map[string]Grant
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{codes: map[string]Grant{}, tokens: map[string]Grant{}}
+}
+
+func (s *fakeStore) CreateCode(hash string, grant Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[hash] = grant
+	return nil
+}
+
+func (s *fakeStore) RedeemCode(hash string) (Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.codes[hash]
+	if !ok {
+		return grant, ErrNotFound
+	}
+	delete(s.codes, hash)
+	return grant, nil
+}
+
+func (s *fakeStore) CreateToken(hash string, grant Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[hash] = grant
+	return nil
+}
+
+func (s *fakeStore) Token(hash string) (Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.tokens[hash]
+	if !ok {
+		return grant, ErrNotFound
+	}
+	return grant, nil
+}
+
+func (s *fakeStore) RevokeToken(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, hash)
+	return nil
+}
+
+const (
+	testVerifier  = "a-code-verifier-that-is-long-enough-to-be-used"
+	testClientID  = "https://client.example.com/"
+	testRedirect  = "https://client.example.com/callback"
+	testMe        = "https://me.example.com/"
+	testPassword  = "hunter2"
+	testTOTPCode  = "081804"
+	testTOTPEpoch = 1111111109
+)
+
+func testChallenge() string {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newTestServer(t *testing.T, totp bool) (*Server, *httptest.Server) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := ServerOptions{
+		Me:           testMe,
+		Issuer:       testMe,
+		PasswordHash: string(hash),
+	}
+	if totp {
+		options.TOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	}
+
+	server, err := NewServer(newFakeStore(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.now = func() time.Time { return time.Unix(testTOTPEpoch, 0) }
+
+	mux := http.NewServeMux()
+	mux.Handle("/-/auth", server.Authorization())
+	mux.Handle("/-/token", server.Token())
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return server, s
+}
+
+var noRedirects = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func authorizationForm() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirect},
+		"state":                 {"some-state"},
+		"scope":                 {"create update"},
+		"code_challenge":        {testChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func consentForm(password string, scopes ...string) url.Values {
+	form := authorizationForm()
+	form.Set("requested_scope", form.Get("scope"))
+	form["scope"] = scopes
+	form.Set("action", "approve")
+	form.Set("password", password)
+	return form
+}
+
+// approve logs in and returns the code the client is redirected with.
+func approve(t *testing.T, s *httptest.Server, form url.Values) string {
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", resp.StatusCode)
+	}
+
+	location, _ := resp.Location()
+	return location.Query().Get("code")
+}
+
+func TestServerConsentPage(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	resp, err := http.Get(s.URL + "/-/auth?" + authorizationForm().Encode())
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(string(body), testClientID)
+	assert.Contains(string(body), `value="create"`)
+	assert.Contains(string(body), `value="update"`)
+	assert.Contains(string(body), `name="password"`)
+	assert.NotContains(string(body), `name="totp"`)
+}
+
+func TestServerConsentPageInvalidRequest(t *testing.T) {
+	testCases := map[string]func(url.Values){
+		"response_type":  func(v url.Values) { v.Set("response_type", "token") },
+		"client_id":      func(v url.Values) { v.Set("client_id", "not a url") },
+		"redirect_uri":   func(v url.Values) { v.Set("redirect_uri", "https://evil.example.com/callback") },
+		"code_challenge": func(v url.Values) { v.Del("code_challenge") },
+		"method":         func(v url.Values) { v.Set("code_challenge_method", "plain") },
+	}
+
+	for name, change := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, s := newTestServer(t, false)
+
+			form := authorizationForm()
+			change(form)
+
+			resp, err := http.Get(s.URL + "/-/auth?" + form.Encode())
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func TestServerFlow(t *testing.T) {
+	assert := assert.New(t)
+	server, s := newTestServer(t, false)
+
+	// the password must be correct
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", consentForm("wrong", "create"))
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = noRedirects.PostForm(s.URL+"/-/auth", consentForm(testPassword, "create", "delete"))
+	assert.Nil(err)
+	assert.Equal(http.StatusFound, resp.StatusCode)
+
+	location, _ := resp.Location()
+	assert.Equal("client.example.com", location.Host)
+	assert.Equal("/callback", location.Path)
+	assert.Equal("some-state", location.Query().Get("state"))
+	assert.Equal(testMe, location.Query().Get("iss"))
+	code := location.Query().Get("code")
+	assert.NotEmpty(code)
+
+	resp, err = http.PostForm(s.URL+"/-/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {testVerifier},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Scope       string `json:"scope"`
+		Me          string `json:"me"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.NotEmpty(v.AccessToken)
+	assert.Equal("Bearer", v.TokenType)
+	// delete was not requested so can not be granted
+	assert.Equal("create", v.Scope)
+	assert.Equal(testMe, v.Me)
+
+	token, err := server.Verify(v.AccessToken)
+	assert.Nil(err)
+	assert.Equal(Token{Me: testMe, ClientID: testClientID, Scopes: []string{"create"}}, token)
+
+	req, _ := http.NewRequest("GET", s.URL+"/-/token", nil)
+	req.Header.Set("Authorization", "Bearer "+v.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var info struct {
+		Me       string `json:"me"`
+		ClientID string `json:"client_id"`
+		Scope    string `json:"scope"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(testMe, info.Me)
+	assert.Equal(testClientID, info.ClientID)
+	assert.Equal("create", info.Scope)
+
+	resp, err = http.PostForm(s.URL+"/-/token", url.Values{
+		"action": {"revoke"},
+		"token":  {v.AccessToken},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	_, err = server.Verify(v.AccessToken)
+	assert.Equal(ErrInvalidToken, err)
+}
+
+func TestServerCodeCanOnlyBeUsedOnce(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	code := approve(t, s, consentForm(testPassword, "create"))
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {testVerifier},
+	}
+
+	resp, err := http.PostForm(s.URL+"/-/token", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, err = http.PostForm(s.URL+"/-/token", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServerExchangeWithWrongVerifier(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	code := approve(t, s, consentForm(testPassword, "create"))
+
+	resp, err := http.PostForm(s.URL+"/-/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {"not-the-verifier"},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	var v struct {
+		Error string `json:"error"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal("invalid_grant", v.Error)
+}
+
+func TestServerProfileRedemption(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	code := approve(t, s, consentForm(testPassword))
+
+	// a code without scope can not be exchanged for a token
+	resp, err := http.PostForm(s.URL+"/-/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {approve(t, s, consentForm(testPassword))},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {testVerifier},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.PostForm(s.URL+"/-/auth", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {testVerifier},
+	})
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var v struct {
+		Me string `json:"me"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal(testMe, v.Me)
+}
+
+func TestServerDeny(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	form := consentForm("", "create")
+	form.Set("action", "deny")
+
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusFound, resp.StatusCode)
+
+	location, _ := resp.Location()
+	assert.Equal("access_denied", location.Query().Get("error"))
+	assert.Equal("some-state", location.Query().Get("state"))
+	assert.False(location.Query().Has("code"))
+}
+
+func TestServerTOTP(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, true)
+
+	// both the password and code are needed when configured
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", consentForm(testPassword, "create"))
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	form := consentForm(testPassword, "create")
+	form.Set("totp", testTOTPCode)
+
+	resp, err = noRedirects.PostForm(s.URL+"/-/auth", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusFound, resp.StatusCode)
+}
+
+func TestServerTOTPCanNotBeReused(t *testing.T) {
+	assert := assert.New(t)
+	server, s := newTestServer(t, true)
+
+	form := consentForm(testPassword, "create")
+	form.Set("totp", testTOTPCode)
+
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusFound, resp.StatusCode)
+
+	resp, err = noRedirects.PostForm(s.URL+"/-/auth", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	// nor can the code for the step before, even though it is still in the
+	// window, but the next code can be used
+	now := time.Unix(testTOTPEpoch, 0)
+	assert.Equal(errInvalidCredentials, server.login("192.0.2.1", testPassword, totp(server.totpSecret, now.Add(-totpStep))))
+	assert.Nil(server.login("192.0.2.1", testPassword, totp(server.totpSecret, now.Add(totpStep))))
+}
+
+func TestServerLocksOutAfterFailedLogins(t *testing.T) {
+	assert := assert.New(t)
+	_, s := newTestServer(t, false)
+
+	for range maxFailedLogins {
+		resp, err := noRedirects.PostForm(s.URL+"/-/auth", consentForm("wrong", "create"))
+		assert.Nil(err)
+		assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp, err := noRedirects.PostForm(s.URL+"/-/auth", consentForm(testPassword, "create"))
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.True(strings.Contains(string(body), errLockedOut.Error()))
+}
+
+func TestServerLocksOutOnlyFailingClient(t *testing.T) {
+	assert := assert.New(t)
+	server, _ := newTestServer(t, false)
+
+	now := time.Unix(testTOTPEpoch, 0)
+	server.now = func() time.Time { return now }
+
+	for range maxFailedLogins {
+		assert.Equal(errInvalidCredentials, server.login("192.0.2.1", "wrong", ""))
+	}
+	assert.Equal(errLockedOut, server.login("192.0.2.1", testPassword, ""))
+
+	// the owner logging in from elsewhere is unaffected
+	assert.Nil(server.login("198.51.100.1", testPassword, ""))
+
+	now = now.Add(lockoutDuration)
+	assert.Nil(server.login("192.0.2.1", testPassword, ""))
+	assert.Empty(server.failures)
+}
+
+func TestOnlyWithServer(t *testing.T) {
+	assert := assert.New(t)
+	server, s := newTestServer(t, false)
+
+	code := approve(t, s, consentForm(testPassword, "create"))
+	resp, err := http.PostForm(s.URL+"/-/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {testVerifier},
+	})
+	assert.Nil(err)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+
+	for name, req := range testCases("?access_token="+v.AccessToken, v.AccessToken) {
+		t.Run(name, func(t *testing.T) {
+			client := &clientHandler{}
+
+			w := httptest.NewRecorder()
+			OnlyWith(testMe, server, client).ServeHTTP(w, req)
+
+			assert.Equal(http.StatusOK, w.Code)
+			assert.Equal(testClientID, client.client)
+		})
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+
+	w := httptest.NewRecorder()
+	OnlyWith(testMe, server, &goodHandler{}).ServeHTTP(w, req)
+	assert.Equal(http.StatusForbidden, w.Code)
+}
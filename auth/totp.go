@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+	// totpSkew is the number of steps either side of now that a code is also
+	// accepted for, to allow for clocks that differ.
+	totpSkew = 1
+)
+
+// decodeTOTPSecret decodes a base32 secret, as shown by authenticator apps.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// totpCounter returns the step containing t.
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpStep.Seconds())
+}
+
+// totp returns the code for secret at the step containing t, see
+// https://www.rfc-editor.org/rfc/rfc6238.
+func totp(secret []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(totpCounter(t)))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// matchTOTP checks code against those for the steps around now, returning the
+// step it is for so that it can be refused if given again.
+func matchTOTP(secret []byte, code string, now time.Time) (step int64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	for i := -totpSkew; i <= totpSkew; i++ {
+		t := now.Add(time.Duration(i) * totpStep)
+		if subtle.ConstantTimeCompare([]byte(totp(secret, t)), []byte(code)) == 1 {
+			step, ok = totpCounter(t), true
+		}
+	}
+
+	return step, ok
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP(t *testing.T) {
+	assert := assert.New(t)
+
+	// test vectors from https://www.rfc-editor.org/rfc/rfc6238#appendix-B,
+	// truncated to 6 digits
+	secret := []byte("12345678901234567890")
+
+	assert.Equal("287082", totp(secret, time.Unix(59, 0)))
+	assert.Equal("081804", totp(secret, time.Unix(1111111109, 0)))
+	assert.Equal("050471", totp(secret, time.Unix(1111111111, 0)))
+	assert.Equal("005924", totp(secret, time.Unix(1234567890, 0)))
+	assert.Equal("279037", totp(secret, time.Unix(2000000000, 0)))
+}
+
+func TestValidTOTP(t *testing.T) {
+	assert := assert.New(t)
+
+	secret, err := decodeTOTPSecret("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.Nil(err)
+	assert.Equal([]byte("12345678901234567890"), secret)
+
+	now := time.Unix(1111111109, 0)
+
+	step, ok := matchTOTP(secret, "081804", now)
+	assert.True(ok)
+	assert.Equal(totpCounter(now), step)
+
+	step, ok = matchTOTP(secret, "081804", now.Add(30*time.Second))
+	assert.True(ok)
+	assert.Equal(totpCounter(now), step)
+
+	_, ok = matchTOTP(secret, "081804", now.Add(90*time.Second))
+	assert.False(ok)
+	_, ok = matchTOTP(secret, "000000", now)
+	assert.False(ok)
+	_, ok = matchTOTP(secret, "", now)
+	assert.False(ok)
+}
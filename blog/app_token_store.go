@@ -0,0 +1,118 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"hawx.me/code/tally-ho/auth"
+)
+
+type AppTokenStore struct {
+	db *sql.DB
+}
+
+func NewAppTokenStore(db *sql.DB) (*AppTokenStore, error) {
+	s := &AppTokenStore{db: db}
+	return s, s.init()
+}
+
+func (s *AppTokenStore) init() error {
+	_, err := s.db.Exec(`
+    CREATE TABLE IF NOT EXISTS app_tokens (
+      ID        INTEGER PRIMARY KEY AUTOINCREMENT,
+      Hash      TEXT UNIQUE,
+      Label     TEXT,
+      Scope     TEXT,
+      CreatedAt DATETIME,
+      ExpiresAt DATETIME
+    );`)
+
+	return err
+}
+
+func (s *AppTokenStore) CreateAppToken(hash string, token auth.AppToken) (int64, error) {
+	expiresAt := sql.NullTime{}
+	if token.ExpiresAt != nil {
+		expiresAt = nullTime(*token.ExpiresAt)
+	}
+
+	result, err := s.db.Exec(`
+    INSERT INTO app_tokens(Hash, Label, Scope, CreatedAt, ExpiresAt)
+      VALUES (?, ?, ?, ?, ?);`,
+		hash,
+		token.Label,
+		strings.Join(token.Scopes, " "),
+		token.CreatedAt.UTC(),
+		expiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *AppTokenStore) AppToken(hash string) (auth.AppToken, error) {
+	token, err := scanAppToken(s.db.QueryRow(`
+    SELECT ID, Label, Scope, CreatedAt, ExpiresAt
+      FROM app_tokens
+      WHERE Hash = ?;`,
+		hash))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		err = auth.ErrNotFound
+	}
+	return token, err
+}
+
+func (s *AppTokenStore) AppTokens() ([]auth.AppToken, error) {
+	rows, err := s.db.Query(`
+    SELECT ID, Label, Scope, CreatedAt, ExpiresAt
+      FROM app_tokens
+      ORDER BY ID`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []auth.AppToken
+	for rows.Next() {
+		token, err := scanAppToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *AppTokenStore) RevokeAppToken(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM app_tokens WHERE ID = ?`,
+		id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return auth.ErrNotFound
+	}
+	return nil
+}
+
+func scanAppToken(row interface{ Scan(...any) error }) (token auth.AppToken, err error) {
+	var (
+		scope     string
+		expiresAt sql.NullTime
+	)
+
+	if err = row.Scan(&token.ID, &token.Label, &scope, &token.CreatedAt, &expiresAt); err != nil {
+		return
+	}
+
+	token.Scopes = strings.Fields(scope)
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package blog
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/auth"
+)
+
+func TestAppTokenStore(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	store, err := NewAppTokenStore(db)
+	assert.Nil(err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+
+	phoneID, err := store.CreateAppToken("phone-hash", auth.AppToken{
+		Label:     "phone",
+		Scopes:    []string{"create", "media"},
+		CreatedAt: now,
+	})
+	assert.Nil(err)
+
+	cronID, err := store.CreateAppToken("cron-hash", auth.AppToken{
+		Label:     "cron",
+		Scopes:    []string{"update"},
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	})
+	assert.Nil(err)
+
+	phone, err := store.AppToken("phone-hash")
+	assert.Nil(err)
+	assert.Equal(phoneID, phone.ID)
+	assert.Equal("phone", phone.Label)
+	assert.Equal([]string{"create", "media"}, phone.Scopes)
+	assert.True(now.Equal(phone.CreatedAt))
+	assert.Nil(phone.ExpiresAt)
+
+	cron, err := store.AppToken("cron-hash")
+	assert.Nil(err)
+	assert.Equal(cronID, cron.ID)
+	if assert.NotNil(cron.ExpiresAt) {
+		assert.True(expiresAt.Equal(*cron.ExpiresAt))
+	}
+
+	_, err = store.AppToken("missing")
+	assert.ErrorIs(err, auth.ErrNotFound)
+
+	tokens, err := store.AppTokens()
+	assert.Nil(err)
+	if assert.Len(tokens, 2) {
+		assert.Equal("phone", tokens[0].Label)
+		assert.Equal("cron", tokens[1].Label)
+	}
+
+	assert.Nil(store.RevokeAppToken(phoneID))
+	assert.ErrorIs(store.RevokeAppToken(phoneID), auth.ErrNotFound)
+
+	_, err = store.AppToken("phone-hash")
+	assert.ErrorIs(err, auth.ErrNotFound)
+}
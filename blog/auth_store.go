@@ -0,0 +1,130 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"hawx.me/code/tally-ho/auth"
+)
+
+type AuthStore struct {
+	db *sql.DB
+}
+
+func NewAuthStore(db *sql.DB) (*AuthStore, error) {
+	s := &AuthStore{db: db}
+	return s, s.init()
+}
+
+func (s *AuthStore) init() error {
+	_, err := s.db.Exec(`
+    CREATE TABLE IF NOT EXISTS auth_codes (
+      Hash          TEXT PRIMARY KEY,
+      ClientID      TEXT,
+      RedirectURI   TEXT,
+      Scope         TEXT,
+      CodeChallenge TEXT,
+      CreatedAt     DATETIME,
+      ExpiresAt     DATETIME
+    );
+
+    CREATE TABLE IF NOT EXISTS auth_tokens (
+      Hash      TEXT PRIMARY KEY,
+      ClientID  TEXT,
+      Scope     TEXT,
+      CreatedAt DATETIME,
+      ExpiresAt DATETIME
+    );`)
+
+	return err
+}
+
+func (s *AuthStore) CreateCode(hash string, grant auth.Grant) error {
+	// codes are short-lived, so remove any that were never redeemed
+	if _, err := s.db.Exec(`DELETE FROM auth_codes WHERE ExpiresAt < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(`
+    INSERT INTO auth_codes(Hash, ClientID, RedirectURI, Scope, CodeChallenge, CreatedAt, ExpiresAt)
+      VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		hash,
+		grant.ClientID,
+		grant.RedirectURI,
+		grant.Scope,
+		grant.CodeChallenge,
+		grant.CreatedAt.UTC(),
+		grant.ExpiresAt.UTC())
+
+	return err
+}
+
+func (s *AuthStore) RedeemCode(hash string) (grant auth.Grant, err error) {
+	err = s.db.QueryRow(`
+    DELETE FROM auth_codes
+      WHERE Hash = ?
+      RETURNING ClientID, RedirectURI, Scope, CodeChallenge, CreatedAt, ExpiresAt;`,
+		hash).Scan(
+		&grant.ClientID,
+		&grant.RedirectURI,
+		&grant.Scope,
+		&grant.CodeChallenge,
+		&grant.CreatedAt,
+		&grant.ExpiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		err = auth.ErrNotFound
+	}
+	return
+}
+
+func (s *AuthStore) CreateToken(hash string, grant auth.Grant) error {
+	_, err := s.db.Exec(`
+    INSERT INTO auth_tokens(Hash, ClientID, Scope, CreatedAt, ExpiresAt)
+      VALUES (?, ?, ?, ?, ?);`,
+		hash,
+		grant.ClientID,
+		grant.Scope,
+		grant.CreatedAt.UTC(),
+		nullTime(grant.ExpiresAt))
+
+	return err
+}
+
+func (s *AuthStore) Token(hash string) (grant auth.Grant, err error) {
+	var expiresAt sql.NullTime
+
+	err = s.db.QueryRow(`
+    SELECT ClientID, Scope, CreatedAt, ExpiresAt
+      FROM auth_tokens
+      WHERE Hash = ?;`,
+		hash).Scan(
+		&grant.ClientID,
+		&grant.Scope,
+		&grant.CreatedAt,
+		&expiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		err = auth.ErrNotFound
+	}
+	if expiresAt.Valid {
+		grant.ExpiresAt = expiresAt.Time
+	}
+	return
+}
+
+func (s *AuthStore) RevokeToken(hash string) error {
+	_, err := s.db.Exec(`DELETE FROM auth_tokens WHERE Hash = ?`,
+		hash)
+
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
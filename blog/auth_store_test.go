@@ -0,0 +1,72 @@
+package blog
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/auth"
+)
+
+func TestAuthStoreCode(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	store, err := NewAuthStore(db)
+	assert.Nil(err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	grant := auth.Grant{
+		ClientID:      "https://client.example.com/",
+		RedirectURI:   "https://client.example.com/callback",
+		Scope:         "create update",
+		CodeChallenge: "challenge",
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Minute),
+	}
+
+	assert.Nil(store.CreateCode("hash", grant))
+
+	redeemed, err := store.RedeemCode("hash")
+	assert.Nil(err)
+	assert.Equal(grant.ClientID, redeemed.ClientID)
+	assert.Equal(grant.RedirectURI, redeemed.RedirectURI)
+	assert.Equal(grant.Scope, redeemed.Scope)
+	assert.Equal(grant.CodeChallenge, redeemed.CodeChallenge)
+	assert.True(grant.ExpiresAt.Equal(redeemed.ExpiresAt))
+
+	_, err = store.RedeemCode("hash")
+	assert.Equal(auth.ErrNotFound, err)
+}
+
+func TestAuthStoreToken(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	store, err := NewAuthStore(db)
+	assert.Nil(err)
+
+	assert.Nil(store.CreateToken("hash", auth.Grant{
+		ClientID:  "https://client.example.com/",
+		Scope:     "create",
+		CreatedAt: time.Now(),
+	}))
+
+	grant, err := store.Token("hash")
+	assert.Nil(err)
+	assert.Equal("https://client.example.com/", grant.ClientID)
+	assert.Equal("create", grant.Scope)
+	assert.True(grant.ExpiresAt.IsZero())
+
+	assert.Nil(store.RevokeToken("hash"))
+
+	_, err = store.Token("hash")
+	assert.Equal(auth.ErrNotFound, err)
+}
@@ -2,17 +2,20 @@ package blog
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gorilla/feeds"
 	"hawx.me/code/numbersix"
 	"hawx.me/code/route"
 	"hawx.me/code/tally-ho/internal/page"
+	"hawx.me/code/tally-ho/micropub"
 )
 
 type Config struct {
@@ -26,7 +29,12 @@ type Config struct {
 	TokenURL    *url.URL
 	DbPath      string
 	MediaDir    string
-	HubURL      string
+	// HubURLs are the WebSub hubs advertised for, and notified of changes to,
+	// each page.
+	HubURLs []string
+	// IsOwner checks whether a request was made by the owner, who can view
+	// drafts. If it is nil drafts can only be viewed with a preview link.
+	IsOwner func(*http.Request) bool
 }
 
 type Blog struct {
@@ -34,19 +42,29 @@ type Blog struct {
 	local         bool
 	config        Config
 	closer        io.Closer
+	client        *http.Client
 	entries       *numbersix.DB
 	mentions      *numbersix.DB
+	moderation    *moderation
+	contacts      *contacts
 	syndicators   map[string]Syndicator
 	citeResolvers []CiteResolver
 	cardResolvers []CardResolver
 	hubPublisher  HubPublisher
+	outbox        Outbox
+
+	// saving is held while an entry is given a permalink and saved, so that two
+	// entries can't be given the same one. See save.
+	saving sync.Mutex
 }
 
 func New(
 	logger *slog.Logger,
 	config Config,
 	db *sql.DB,
+	client *http.Client,
 	hubPublisher HubPublisher,
+	outbox Outbox,
 	silos []any,
 ) (*Blog, error) {
 	entries, err := numbersix.For(db, "entries")
@@ -59,6 +77,16 @@ func New(
 		return nil, err
 	}
 
+	moderation, err := newModeration(db)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := newContacts(db)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		cardResolvers []CardResolver
 		citeResolvers []CiteResolver
@@ -82,18 +110,32 @@ func New(
 		logger.Info("running in local mode")
 	}
 
-	return &Blog{
+	b := &Blog{
 		logger:        logger,
 		local:         local,
 		config:        config,
 		closer:        db,
+		client:        client,
 		entries:       entries,
 		mentions:      mentions,
+		moderation:    moderation,
+		contacts:      contacts,
 		syndicators:   syndicators,
 		citeResolvers: citeResolvers,
 		cardResolvers: cardResolvers,
 		hubPublisher:  hubPublisher,
-	}, nil
+		outbox:        outbox,
+	}
+
+	if err := b.backfillPermalinks(); err != nil {
+		return nil, fmt.Errorf("backfill permalinks: %w", err)
+	}
+
+	if err := b.backfillReplies(); err != nil {
+		return nil, fmt.Errorf("backfill replies: %w", err)
+	}
+
+	return b, nil
 }
 
 func (b *Blog) Close() error {
@@ -145,14 +187,13 @@ func (b *Blog) Handler() http.Handler {
 		}
 
 		olderThan := ""
-		if len(posts) == 25 {
+		if len(posts) == pageSize {
 			olderThan = posts[len(posts)-1].Properties["published"][0].(string)
 		} else if len(posts) == 0 {
 			olderThan = "NOMORE"
 		}
 
-		w.Header().Add("Link", `<`+indexURL+`>; rel="self"`)
-		w.Header().Add("Link", `<`+b.config.HubURL+`>; rel="hub"`)
+		b.linkHub(w, indexURL)
 
 		if _, err := page.List(blogConfig, page.ListData{
 			Title:        b.config.Title,
@@ -183,12 +224,14 @@ func (b *Blog) Handler() http.Handler {
 		}
 
 		olderThan := ""
-		if len(posts) == 25 {
+		if len(posts) == pageSize {
 			olderThan = posts[len(posts)-1].Properties["published"][0].(string)
 		} else if len(posts) == 0 {
 			olderThan = "NOMORE"
 		}
 
+		b.linkHub(w, b.kindURL(vars["kind"]))
+
 		if _, err := page.List(blogConfig, page.ListData{
 			Title:        b.config.Title,
 			GroupedPosts: groupLikes(posts),
@@ -219,12 +262,14 @@ func (b *Blog) Handler() http.Handler {
 		}
 
 		olderThan := ""
-		if len(posts) == 25 {
+		if len(posts) == pageSize {
 			olderThan = posts[len(posts)-1].Properties["published"][0].(string)
 		} else if len(posts) == 0 {
 			olderThan = "NOMORE"
 		}
 
+		b.linkHub(w, b.categoryURL(vars["category"]))
+
 		if _, err := page.List(blogConfig, page.ListData{
 			Title:        b.config.Title,
 			GroupedPosts: groupLikes(posts),
@@ -239,12 +284,14 @@ func (b *Blog) Handler() http.Handler {
 		return nil
 	})
 
-	mux.HandleFunc("/entry/:id", func(w http.ResponseWriter, r *http.Request) error {
-		vars := route.Vars(r)
-
-		entry, err := b.EntryByUID(vars["id"])
-		if err != nil {
-			return fmt.Errorf("entry by uid: %w", err)
+	servePost := func(w http.ResponseWriter, r *http.Request, entry map[string][]interface{}) error {
+		if !b.canView(r, entry) {
+			http.NotFound(w, r)
+			return nil
+		}
+		if isDraft(entry) {
+			w.Header().Set("Cache-Control", "private, no-store")
+			w.Header().Set("X-Robots-Tag", "noindex")
 		}
 
 		if deleted, ok := entry["hx-deleted"]; ok && len(deleted) > 0 {
@@ -252,11 +299,15 @@ func (b *Blog) Handler() http.Handler {
 			return nil
 		}
 
-		mentions, err := b.MentionsForEntry(baseURL.ResolveReference(r.URL).String())
+		location, _ := first(entry, "url")
+
+		mentions, err := b.entryMentions(entry)
 		if err != nil {
 			return fmt.Errorf("mentions for entry: %w", err)
 		}
 
+		b.linkHub(w, location)
+
 		if _, err := page.Post(blogConfig, page.PostData{
 			Entry: entry,
 			Posts: GroupedPosts{
@@ -269,6 +320,47 @@ func (b *Blog) Handler() http.Handler {
 		}
 
 		return nil
+	}
+
+	mux.HandleFunc("/entry/:id", func(w http.ResponseWriter, r *http.Request) error {
+		vars := route.Vars(r)
+
+		entry, err := b.EntryByUID(vars["id"])
+		if err != nil {
+			return fmt.Errorf("entry by uid: %w", err)
+		}
+
+		// don't reveal the permalink of a draft that can't be viewed
+		if !b.canView(r, entry) {
+			http.NotFound(w, r)
+			return nil
+		}
+
+		// entries are found at their permalink, unless one could not be given
+		if location, ok := first(entry, "url"); ok && location != b.entryURL(vars["id"]) {
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return nil
+		}
+
+		return servePost(w, r, entry)
+	})
+
+	mux.HandleFunc("/:year/:month/:day/:slug", func(w http.ResponseWriter, r *http.Request) error {
+		vars := route.Vars(r)
+
+		entry, err := b.EntryBySlug(vars["year"], vars["month"], vars["day"], vars["slug"])
+		if err != nil {
+			if errors.Is(err, micropub.ErrNotFound) {
+				http.NotFound(w, r)
+				return nil
+			}
+			return fmt.Errorf("entry by slug: %w", err)
+		}
+
+		return servePost(w, r, entry)
 	})
 
 	mux.HandleFunc("/likes/:ymd", func(w http.ResponseWriter, r *http.Request) error {
@@ -279,6 +371,8 @@ func (b *Blog) Handler() http.Handler {
 			return err
 		}
 
+		b.linkHub(w, b.likesURL(ymd))
+
 		if _, err := page.Day(blogConfig, page.DayData{
 			Ymd:   ymd,
 			Items: likes,
@@ -333,8 +427,7 @@ func (b *Blog) Handler() http.Handler {
 			return fmt.Errorf("to rss: %w", err)
 		}
 
-		w.Header().Add("Link", `<`+feedRssURL+`>; rel="self"`)
-		w.Header().Add("Link", `<`+b.config.HubURL+`>; rel="hub"`)
+		b.linkHub(w, feedRssURL)
 		w.Header().Set("Content-Type", "application/rss+xml")
 		io.WriteString(w, rss)
 		return nil
@@ -351,8 +444,7 @@ func (b *Blog) Handler() http.Handler {
 			return fmt.Errorf("to atom: %w", err)
 		}
 
-		w.Header().Add("Link", `<`+feedAtomURL+`>; rel="self"`)
-		w.Header().Add("Link", `<`+b.config.HubURL+`>; rel="hub"`)
+		b.linkHub(w, feedAtomURL)
 		w.Header().Set("Content-Type", "application/atom+xml")
 		io.WriteString(w, atom)
 		return nil
@@ -369,15 +461,12 @@ func (b *Blog) Handler() http.Handler {
 			return fmt.Errorf("to json: %w", err)
 		}
 
-		w.Header().Add("Link", `<`+feedJsonfeedURL+`>; rel="self"`)
-		w.Header().Add("Link", `<`+b.config.HubURL+`>; rel="hub"`)
-		w.Header().Set("Content-Type", "application/json")
+		b.linkHub(w, feedJsonfeedURL)
+		w.Header().Set("Content-Type", "application/feed+json")
 		io.WriteString(w, json)
 		return nil
 	})
 
-	// route.Handle("/:year/:month/:date/:slug")
-
 	return mux
 }
 
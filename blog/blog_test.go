@@ -0,0 +1,75 @@
+package blog
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"hawx.me/code/numbersix"
+)
+
+type testBlogOption func(*Blog)
+
+// withSyndicator adds syndicator to the Blog created by newTestBlog.
+func withSyndicator(syndicator Syndicator) testBlogOption {
+	return func(b *Blog) {
+		b.syndicators[syndicator.UID()] = syndicator
+	}
+}
+
+// withHubPublisher makes the Blog created by newTestBlog publish changes to
+// topics with publisher.
+func withHubPublisher(publisher HubPublisher) testBlogOption {
+	return func(b *Blog) {
+		b.config.HubURLs = []string{"http://hub.example.com/"}
+		b.hubPublisher = publisher
+	}
+}
+
+// newTestBlog creates a Blog for http://example.com/ backed by an in-memory
+// database. Webmentions are queued to a fakeOutbox.
+func newTestBlog(t *testing.T, opts ...testBlogOption) *Blog {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	entries, err := numbersix.For(db, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mentions, err := numbersix.For(db, "mentions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderation, err := newModeration(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contacts, err := newContacts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseURL, _ := url.Parse("http://example.com/")
+
+	b := &Blog{
+		logger:      slog.Default(),
+		config:      Config{BaseURL: baseURL},
+		entries:     entries,
+		mentions:    mentions,
+		moderation:  moderation,
+		contacts:    contacts,
+		outbox:      &fakeOutbox{},
+		syndicators: map[string]Syndicator{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
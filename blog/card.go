@@ -28,10 +28,10 @@ func (b *Blog) resolveCard(u string) (map[string]any, error) {
 		return person, err
 	}
 
-	return resolveCard(u)
+	return resolveCard(b.client, u)
 }
 
-func resolveCard(u string) (card map[string]any, err error) {
+func resolveCard(client *http.Client, u string) (card map[string]any, err error) {
 	card = map[string]any{
 		"type": []any{"h-card"},
 		"properties": map[string][]any{
@@ -39,7 +39,7 @@ func resolveCard(u string) (card map[string]any, err error) {
 		},
 	}
 
-	resp, err := http.Get(u)
+	resp, err := client.Get(u)
 	if err != nil {
 		return
 	}
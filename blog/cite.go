@@ -34,10 +34,10 @@ func (b *Blog) resolveCite(u string) (map[string]any, error) {
 		return cite, err
 	}
 
-	return resolveCite(u)
+	return resolveCite(b.client, u)
 }
 
-func resolveCite(u string) (cite map[string]any, err error) {
+func resolveCite(client *http.Client, u string) (cite map[string]any, err error) {
 	cite = map[string]any{
 		"type": []any{"h-cite"},
 		"properties": map[string][]any{
@@ -45,7 +45,7 @@ func resolveCite(u string) (cite map[string]any, err error) {
 		},
 	}
 
-	resp, err := http.Get(u)
+	resp, err := client.Get(u)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,263 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	"hawx.me/code/numbersix"
+	"hawx.me/code/tally-ho/internal/mfutil"
+	"hawx.me/code/tally-ho/micropub"
+)
+
+// citeProperties are the properties of an entry that can contain an h-cite with
+// an author.
+var citeProperties = []string{"in-reply-to", "repost-of", "like-of", "bookmark-of"}
+
+// contacts stores people added by the owner, they are listed alongside the
+// people found in entries and replace any with the same URL.
+type contacts struct {
+	db *sql.DB
+}
+
+func newContacts(db *sql.DB) (*contacts, error) {
+	c := &contacts{db}
+	return c, c.init()
+}
+
+func (c *contacts) init() error {
+	_, err := c.db.Exec(`CREATE TABLE IF NOT EXISTS contacts (
+    URL      TEXT PRIMARY KEY,
+    Name     TEXT,
+    Nickname TEXT,
+    Photo    TEXT,
+    Silos    TEXT
+  );`)
+
+	return err
+}
+
+func (c *contacts) set(contact micropub.Contact) error {
+	silos, err := json.Marshal(contact.Silos)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO contacts(URL, Name, Nickname, Photo, Silos) VALUES (?, ?, ?, ?, ?)`,
+		contact.URL,
+		contact.Name,
+		contact.Nickname,
+		contact.Photo,
+		string(silos))
+
+	return err
+}
+
+func (c *contacts) has(url string) (ok bool, err error) {
+	err = c.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM contacts WHERE URL = ?)`,
+		url).Scan(&ok)
+
+	return
+}
+
+func (c *contacts) remove(url string) error {
+	_, err := c.db.Exec(`DELETE FROM contacts WHERE URL = ?`,
+		url)
+
+	return err
+}
+
+func (c *contacts) list() (list []micropub.Contact, err error) {
+	rows, err := c.db.Query(`SELECT URL, Name, Nickname, Photo, Silos FROM contacts ORDER BY URL`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			contact micropub.Contact
+			silos   string
+		)
+		if err = rows.Scan(&contact.URL, &contact.Name, &contact.Nickname, &contact.Photo, &silos); err != nil {
+			return
+		}
+		if err = json.Unmarshal([]byte(silos), &contact.Silos); err != nil {
+			return
+		}
+		list = append(list, contact)
+	}
+
+	return list, rows.Err()
+}
+
+// SetContact adds contact to the list returned by Contacts, replacing any
+// existing contact with the same URL.
+func (b *Blog) SetContact(contact micropub.Contact) error {
+	if contact.URL == "" {
+		return errors.New("contact must have a url")
+	}
+
+	return b.contacts.set(contact)
+}
+
+// RemoveContact removes the contact that was added with url. People found in
+// entries will continue to be listed.
+func (b *Blog) RemoveContact(url string) error {
+	return b.contacts.remove(url)
+}
+
+// Contacts lists the people that have been mentioned in, or are the author of
+// something cited by, an entry, along with those added with SetContact. If
+// filter is given only contacts with a name, nickname or URL starting with it
+// are returned.
+func (b *Blog) Contacts(filter string) ([]micropub.Contact, error) {
+	found := map[string]micropub.Contact{}
+
+	triples, err := b.entries.List(numbersix.Has("hx-people").Without("hx-deleted"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range numbersix.Grouped(triples) {
+		for _, people := range group.Properties["hx-people"] {
+			people, _ := people.(map[string]any)
+
+			for u, me := range people {
+				contact := found[u]
+				contact.URL = u
+				me, _ := me.([]any)
+				for _, m := range me {
+					if m, ok := m.(string); ok {
+						addSilo(&contact, m)
+					}
+				}
+				found[u] = contact
+			}
+		}
+	}
+
+	for _, property := range citeProperties {
+		triples, err := b.entries.List(numbersix.Has(property).Without("hx-deleted"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range numbersix.Grouped(triples) {
+			for _, cite := range group.Properties[property] {
+				author := mfutil.Get(cite, "properties.author")
+
+				u, _ := mfutil.Get(author, "properties.url").(string)
+				if u == "" {
+					continue
+				}
+
+				contact := found[u]
+				contact.URL = u
+				if name, ok := mfutil.Get(author, "properties.name").(string); ok {
+					contact.Name = name
+				}
+				if photo, ok := mfutil.Get(author, "properties.photo").(string); ok {
+					contact.Photo = photo
+				}
+				found[u] = contact
+			}
+		}
+	}
+
+	curated, err := b.contacts.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, contact := range curated {
+		found[contact.URL] = contact
+	}
+
+	list := []micropub.Contact{}
+	for _, contact := range found {
+		if matchesContact(contact, filter) {
+			list = append(list, contact)
+		}
+	}
+
+	slices.SortFunc(list, func(a, b micropub.Contact) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	return list, nil
+}
+
+// addSilo records a rel-me link to a profile on a silo, such as
+// "https://github.com/john", as the username "john" for "github".
+func addSilo(contact *micropub.Contact, me string) {
+	u, err := url.Parse(me)
+	if err != nil {
+		return
+	}
+
+	username := strings.Trim(u.Path, "/")
+	if username == "" || strings.Contains(username, "/") {
+		return
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	silo, _, ok := strings.Cut(host, ".")
+	if !ok {
+		return
+	}
+
+	if contact.Silos == nil {
+		contact.Silos = map[string]string{}
+	}
+	contact.Silos[silo] = strings.TrimPrefix(username, "@")
+}
+
+func matchesContact(contact micropub.Contact, filter string) bool {
+	if filter == "" {
+		return true
+	}
+
+	filter = strings.ToLower(filter)
+	host := contact.URL
+	if u, err := url.Parse(contact.URL); err == nil && u.Host != "" {
+		host = strings.TrimPrefix(u.Host, "www.")
+	}
+
+	for _, v := range []string{contact.Name, contact.Nickname, contact.URL, host} {
+		if strings.HasPrefix(strings.ToLower(v), filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Categories lists the distinct categories used by entries. If filter is given
+// only categories starting with it are returned.
+func (b *Blog) Categories(filter string) ([]string, error) {
+	triples, err := b.entries.List(numbersix.Has("category").Without("hx-deleted"))
+	if err != nil {
+		return nil, err
+	}
+
+	filter = strings.ToLower(filter)
+	categories := []string{}
+
+	for _, group := range numbersix.Grouped(triples) {
+		for _, category := range group.Properties["category"] {
+			category, ok := category.(string)
+			if !ok || !strings.HasPrefix(strings.ToLower(category), filter) {
+				continue
+			}
+			if !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+
+	slices.Sort(categories)
+	return categories, nil
+}
@@ -0,0 +1,115 @@
+package blog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/micropub"
+)
+
+func TestContacts(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.entries.SetProperties("1", map[string][]interface{}{
+		"hx-people": {map[string][]string{
+			"https://jane.example.com/": {"https://github.com/jane", "https://jane.example.com/"},
+		}},
+	}))
+	assert.Nil(blog.entries.SetProperties("2", map[string][]interface{}{
+		"like-of": {map[string]any{
+			"type": []any{"h-cite"},
+			"properties": map[string][]any{
+				"url": {"https://john.example.com/1"},
+				"author": {map[string]any{
+					"type": []any{"h-card"},
+					"properties": map[string][]any{
+						"name":  {"John"},
+						"url":   {"https://john.example.com/"},
+						"photo": {"https://john.example.com/photo.jpg"},
+					},
+				}},
+			},
+		}},
+	}))
+	assert.Nil(blog.entries.SetProperties("3", map[string][]interface{}{
+		"hx-deleted": {true},
+		"hx-people": {map[string][]string{
+			"https://deleted.example.com/": {},
+		}},
+	}))
+
+	contacts, err := blog.Contacts("")
+	assert.Nil(err)
+	assert.Equal([]micropub.Contact{
+		{URL: "https://jane.example.com/", Silos: map[string]string{"github": "jane"}},
+		{Name: "John", URL: "https://john.example.com/", Photo: "https://john.example.com/photo.jpg"},
+	}, contacts)
+
+	assert.Nil(blog.SetContact(micropub.Contact{
+		Name:     "Jane Doe",
+		Nickname: "jd",
+		URL:      "https://jane.example.com/",
+	}))
+	assert.Nil(blog.SetContact(micropub.Contact{
+		Name: "Someone",
+		URL:  "https://someone.example.com/",
+	}))
+
+	contacts, err = blog.Contacts("")
+	assert.Nil(err)
+	assert.Equal([]micropub.Contact{
+		{Name: "Jane Doe", Nickname: "jd", URL: "https://jane.example.com/"},
+		{Name: "John", URL: "https://john.example.com/", Photo: "https://john.example.com/photo.jpg"},
+		{Name: "Someone", URL: "https://someone.example.com/"},
+	}, contacts)
+
+	for filter, expected := range map[string][]string{
+		"j":        {"https://jane.example.com/", "https://john.example.com/"},
+		"jd":       {"https://jane.example.com/"},
+		"someone.": {"https://someone.example.com/"},
+		"https://": {"https://jane.example.com/", "https://john.example.com/", "https://someone.example.com/"},
+	} {
+		contacts, err = blog.Contacts(filter)
+		assert.Nil(err)
+
+		var urls []string
+		for _, contact := range contacts {
+			urls = append(urls, contact.URL)
+		}
+		assert.Equal(expected, urls, filter)
+	}
+
+	assert.Nil(blog.RemoveContact("https://someone.example.com/"))
+	contacts, err = blog.Contacts("some")
+	assert.Nil(err)
+	assert.Empty(contacts)
+}
+
+func TestCategories(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.entries.SetProperties("1", map[string][]interface{}{
+		"category": {"go", "cats"},
+	}))
+	assert.Nil(blog.entries.SetProperties("2", map[string][]interface{}{
+		"category": {"Code", "go"},
+	}))
+	assert.Nil(blog.entries.SetProperties("3", map[string][]interface{}{
+		"category":   {"hidden"},
+		"hx-deleted": {true},
+	}))
+
+	categories, err := blog.Categories("")
+	assert.Nil(err)
+	assert.Equal([]string{"Code", "cats", "go"}, categories)
+
+	categories, err = blog.Categories("c")
+	assert.Nil(err)
+	assert.Equal([]string{"Code", "cats"}, categories)
+
+	categories, err = blog.Categories("x")
+	assert.Nil(err)
+	assert.Empty(categories)
+}
@@ -1,6 +1,8 @@
 package blog
 
 import (
+	"log/slog"
+
 	"hawx.me/code/tally-ho/internal/mfutil"
 )
 
@@ -8,15 +10,37 @@ func (b *Blog) Create(data map[string][]interface{}) (string, error) {
 	b.massage(data)
 
 	uid := mfutil.Get(data, "uid").(string)
+	if err := b.save(uid, data); err != nil {
+		return "", err
+	}
+
 	location := mfutil.Get(data, "url").(string)
 
-	if err := b.entries.SetProperties(uid, data); err != nil {
-		return location, err
+	if isDraft(data) {
+		return location, nil
+	}
+
+	if err := b.noteReplies(data); err != nil {
+		b.logger.Warn("note replies", slog.Any("err", err))
 	}
 
 	go b.syndicate(location, data)
 	go b.sendWebmentions(location, data)
-	go b.hubPublish()
+	go b.hubPublish(data)
 
 	return location, nil
 }
+
+// save replaces the entry id with data, giving it a permalink if it does not
+// have a url.
+func (b *Blog) save(id string, data map[string][]interface{}) error {
+	b.saving.Lock()
+	defer b.saving.Unlock()
+
+	b.setURL(data)
+
+	if err := b.entries.DeleteSubject(id); err != nil {
+		return err
+	}
+	return b.entries.SetProperties(id, data)
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/internal/fetch"
 )
 
 func TestGetCite(t *testing.T) {
@@ -16,7 +17,7 @@ func TestGetCite(t *testing.T) {
 	}))
 	defer s.Close()
 
-	cite, err := resolveCite(s.URL)
+	cite, err := resolveCite(fetch.New(true), s.URL)
 	assert.NotNil(err)
 	assert.Equal(map[string]interface{}{
 		"type": []interface{}{"h-cite"},
@@ -39,7 +40,7 @@ func TestGetCiteHEntry(t *testing.T) {
 	}))
 	defer s.Close()
 
-	cite, err := resolveCite(s.URL)
+	cite, err := resolveCite(fetch.New(true), s.URL)
 	if !assert.Nil(err) {
 		return
 	}
@@ -128,7 +129,7 @@ func TestGetCiteOG(t *testing.T) {
 			}))
 			defer s.Close()
 
-			cite, err := resolveCite(s.URL)
+			cite, err := resolveCite(fetch.New(true), s.URL)
 			if !assert.Equal(tc.err, err) {
 				return
 			}
@@ -150,7 +151,7 @@ func TestGetCiteTitle(t *testing.T) {
 	}))
 	defer s.Close()
 
-	cite, err := resolveCite(s.URL)
+	cite, err := resolveCite(fetch.New(true), s.URL)
 	if !assert.Nil(err) {
 		return
 	}
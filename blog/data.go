@@ -1,17 +1,24 @@
 package blog
 
 import (
-	"errors"
+	"fmt"
 	"log/slog"
 	"maps"
 	"slices"
+	"strings"
 	"time"
 
 	"hawx.me/code/numbersix"
+	"hawx.me/code/tally-ho/internal/mfutil"
+	"hawx.me/code/tally-ho/micropub"
+	"hawx.me/code/tally-ho/webmention"
 )
 
 var empty = map[string][]interface{}{}
 
+// pageSize is the number of entries shown on each page of a list.
+const pageSize = 25
+
 func (b *Blog) Entry(url string) (data map[string][]interface{}, err error) {
 	triples, err := b.entries.List(numbersix.Where("url", url))
 	if err != nil {
@@ -19,7 +26,15 @@ func (b *Blog) Entry(url string) (data map[string][]interface{}, err error) {
 	}
 	groups := numbersix.Grouped(triples)
 	if len(groups) == 0 {
-		return data, errors.New("no data for url: " + url)
+		// the entry could have been given a permalink since url was published
+		triples, err = b.entries.List(numbersix.Where(legacyURL, url))
+		if err != nil {
+			return
+		}
+		groups = numbersix.Grouped(triples)
+	}
+	if len(groups) == 0 {
+		return data, fmt.Errorf("%w: no data for url: %s", micropub.ErrNotFound, url)
 	}
 
 	return b.withAuthor(groups[0].Properties), nil
@@ -32,7 +47,7 @@ func (b *Blog) EntryByUID(uid string) (data map[string][]interface{}, err error)
 	}
 	groups := numbersix.Grouped(triples)
 	if len(groups) == 0 {
-		return data, errors.New("no data for uid: " + uid)
+		return data, fmt.Errorf("%w: no data for uid: %s", micropub.ErrNotFound, uid)
 	}
 
 	return b.withAuthor(groups[0].Properties), nil
@@ -46,11 +61,13 @@ func (b *Blog) Delete(url string) error {
 
 	id, ok := data["uid"][0].(string)
 	if !ok {
-		return errors.New("post to delete not found")
+		return fmt.Errorf("%w: post to delete not found", micropub.ErrNotFound)
 	}
 
-	go b.sendWebmentions(url, data)
-	go b.hubPublish()
+	if !isDraft(data) {
+		go b.sendWebmentions(url, data)
+		go b.hubPublish(data)
+	}
 
 	return b.entries.Set(id, "hx-deleted", true)
 }
@@ -63,37 +80,63 @@ func (b *Blog) Undelete(url string) error {
 
 	id, ok := data["uid"][0].(string)
 	if !ok {
-		return errors.New("post to undelete not found")
+		return fmt.Errorf("%w: post to undelete not found", micropub.ErrNotFound)
 	}
 
-	go b.sendWebmentions(url, data)
-	go b.hubPublish()
+	if !isDraft(data) {
+		go b.sendWebmentions(url, data)
+		go b.hubPublish(data)
+	}
 
 	return b.entries.DeletePredicate(id, "hx-deleted")
 }
 
 func (b *Blog) Mention(source string, data map[string][]interface{}) error {
-	// TODO: add ability to block by host or url
-	if err := b.mentions.DeleteSubject(source); err != nil {
+	if blocked, err := b.moderation.matches(ruleBlock, source); err != nil {
 		return err
+	} else if blocked {
+		return webmention.ErrBlocked
 	}
 
-	keys := slices.Collect(maps.Keys(data))
-	if !(slices.Contains(keys, "in-reply-to") || slices.Contains(keys, "like-of") || slices.Contains(keys, "repost-of")) {
-		slog.Info("Received odd webmention")
-		return nil
-	}
-
-	if _, ok := data["hx-gone"]; ok {
-		return nil
+	target, _ := mfutil.Get(data, "hx-target").(string)
+
+	var pending bool
+	err := b.changeMentions(target, func() error {
+		if err := b.mentions.DeleteSubject(source); err != nil {
+			return err
+		}
+
+		keys := slices.Collect(maps.Keys(data))
+		if !(slices.Contains(keys, "in-reply-to") || slices.Contains(keys, "like-of") || slices.Contains(keys, "repost-of")) {
+			slog.Info("Received odd webmention")
+			return nil
+		}
+
+		if _, ok := data["hx-gone"]; ok {
+			return nil
+		}
+
+		approved, err := b.approved(source, data)
+		if err != nil {
+			return err
+		}
+		if !approved {
+			data["hx-pending"] = []interface{}{true}
+			pending = true
+		}
+
+		return b.mentions.SetProperties(source, data)
+	})
+	if err == nil && pending {
+		return webmention.ErrPending
 	}
 
-	return b.mentions.SetProperties(source, data)
+	return err
 }
 
 func (b *Blog) MentionsForEntry(url string) (list []numbersix.Group, err error) {
 	slog.Info("looking for mentions for url: " + url)
-	triples, err := b.mentions.List(numbersix.Where("hx-target", url))
+	triples, err := b.mentions.List(numbersix.Where("hx-target", url).Without("hx-pending"))
 	if err != nil {
 		return
 	}
@@ -104,9 +147,31 @@ func (b *Blog) MentionsForEntry(url string) (list []numbersix.Group, err error)
 	return
 }
 
+// entryMentions returns the mentions of entry, including those sent to its
+// legacyURL before it was given a permalink.
+func (b *Blog) entryMentions(entry map[string][]interface{}) ([]numbersix.Group, error) {
+	location, _ := first(entry, "url")
+
+	mentions, err := b.MentionsForEntry(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy, ok := first(entry, legacyURL); ok {
+		legacyMentions, err := b.MentionsForEntry(legacy)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, legacyMentions...)
+	}
+
+	return mentions, nil
+}
+
 func (b *Blog) MentionsBefore(published time.Time, limit int) (list []numbersix.Group, err error) {
 	triples, err := b.mentions.List(numbersix.
 		Before("published", published.Format(time.RFC3339)).
+		Without("hx-pending").
 		Limit(limit))
 	if err != nil {
 		return
@@ -117,27 +182,90 @@ func (b *Blog) MentionsBefore(published time.Time, limit int) (list []numbersix.
 }
 
 func (b *Blog) Before(published time.Time) (groups []numbersix.Group, err error) {
-	triples, err := b.entries.List(
-		numbersix.
-			Before("published", published.Format(time.RFC3339)).
-			Without("hx-deleted").
-			Limit(25),
-	)
+	return b.entriesBefore(published, "", "", pageSize, false)
+}
+
+func (b *Blog) KindBefore(kind string, published time.Time) (groups []numbersix.Group, err error) {
+	return b.entriesBefore(published, kind, "", pageSize, false)
+}
+
+func (b *Blog) CategoryBefore(category string, published time.Time) (groups []numbersix.Group, err error) {
+	return b.entriesBefore(published, "", category, pageSize, false)
+}
+
+// Source lists the entries matching query, most recently published first, for
+// the Micropub q=source query. Unlike the other lists it includes drafts.
+// Entries published at the same time are ordered by uid, greatest first.
+func (b *Blog) Source(query micropub.SourceQuery) ([]map[string][]interface{}, error) {
+	var groups []numbersix.Group
+
+	if query.BeforeUID != "" {
+		ties, err := b.entriesAt(query.Before, query.PostType, query.Category)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range ties {
+			if group.Subject < query.BeforeUID {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	older, err := b.entriesBefore(query.Before, query.PostType, query.Category, query.Limit, true)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	return b.groupedWithAuthors(numbersix.Grouped(triples)), nil
+	// the limit may have split those published at the same time as the last
+	// entry, so get all of them to pick the first by uid
+	if len(older) > 0 && len(older) == query.Limit {
+		last, _ := older[len(older)-1].Properties["published"][0].(string)
+		published, err := time.Parse(time.RFC3339, last)
+		if err != nil {
+			return nil, err
+		}
+		ties, err := b.entriesAt(published, query.PostType, query.Category)
+		if err != nil {
+			return nil, err
+		}
+
+		older = slices.DeleteFunc(older, func(group numbersix.Group) bool {
+			return group.Properties["published"][0] == last
+		})
+		older = append(older, ties...)
+	}
+
+	groups = append(groups, older...)
+	slices.SortStableFunc(groups, func(x, y numbersix.Group) int {
+		xp, _ := x.Properties["published"][0].(string)
+		yp, _ := y.Properties["published"][0].(string)
+		if xp != yp {
+			return strings.Compare(yp, xp)
+		}
+		return strings.Compare(y.Subject, x.Subject)
+	})
+	if len(groups) > query.Limit {
+		groups = groups[:query.Limit]
+	}
+
+	entries := make([]map[string][]interface{}, len(groups))
+	for i, group := range groups {
+		entries[i] = group.Properties
+	}
+
+	return entries, nil
 }
 
-func (b *Blog) KindBefore(kind string, published time.Time) (groups []numbersix.Group, err error) {
-	triples, err := b.entries.List(
-		numbersix.
-			Before("published", published.Format(time.RFC3339)).
-			Where("hx-kind", kind).
-			Without("hx-deleted").
-			Limit(25),
-	)
+// entriesBefore lists up to limit entries published before the given time,
+// optionally only those of kind or in category. Drafts are only listed if
+// drafts is true.
+func (b *Blog) entriesBefore(published time.Time, kind, category string, limit int, drafts bool) (groups []numbersix.Group, err error) {
+	query := filterEntries(numbersix.Before("published", published.Format(time.RFC3339)), kind, category)
+	if !drafts {
+		query = query.Without("hx-draft")
+	}
+
+	triples, err := b.entries.List(query.Limit(limit))
 	if err != nil {
 		return
 	}
@@ -145,14 +273,10 @@ func (b *Blog) KindBefore(kind string, published time.Time) (groups []numbersix.
 	return b.groupedWithAuthors(numbersix.Grouped(triples)), nil
 }
 
-func (b *Blog) CategoryBefore(category string, published time.Time) (groups []numbersix.Group, err error) {
-	triples, err := b.entries.List(
-		numbersix.
-			Before("published", published.Format(time.RFC3339)).
-			Where("category", category).
-			Without("hx-deleted").
-			Limit(25),
-	)
+// entriesAt lists the entries, including drafts, published at exactly the
+// given time, optionally only those of kind or in category.
+func (b *Blog) entriesAt(published time.Time, kind, category string) (groups []numbersix.Group, err error) {
+	triples, err := b.entries.List(filterEntries(numbersix.Where("published", published.Format(time.RFC3339)), kind, category))
 	if err != nil {
 		return
 	}
@@ -160,12 +284,26 @@ func (b *Blog) CategoryBefore(category string, published time.Time) (groups []nu
 	return b.groupedWithAuthors(numbersix.Grouped(triples)), nil
 }
 
+// filterEntries restricts query to entries that are not deleted, and if given
+// are of kind or in category.
+func filterEntries(query *numbersix.Query, kind, category string) *numbersix.Query {
+	if kind != "" {
+		query = query.Where("hx-kind", kind)
+	}
+	if category != "" {
+		query = query.Where("category", category)
+	}
+
+	return query.Without("hx-deleted")
+}
+
 func (b *Blog) LikesOn(ymd string) (groups []numbersix.Group, err error) {
 	// TODO: this should be sorted
 	triples, err := b.entries.List(
 		numbersix.
 			Begins("published", ymd).
 			Without("hx-deleted").
+			Without("hx-draft").
 			Has("like-of"),
 	)
 	if err != nil {
@@ -0,0 +1,50 @@
+package blog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/micropub"
+)
+
+func TestSourcePagesThroughEntriesPublishedTogether(t *testing.T) {
+	assert := assert.New(t)
+	b := newTestBlog(t)
+
+	// c, d and e are published at the same time, so have to be ordered by uid
+	for uid, published := range map[string]string{
+		"a": "2024-03-01T12:00:00Z",
+		"b": "2024-03-02T12:00:00Z",
+		"c": "2024-03-03T12:00:00Z",
+		"d": "2024-03-03T12:00:00Z",
+		"e": "2024-03-03T12:00:00Z",
+		"f": "2024-03-04T12:00:00Z",
+	} {
+		assert.Nil(b.save(uid, map[string][]interface{}{
+			"uid":       {uid},
+			"published": {published},
+			"content":   {"Entry " + uid},
+		}))
+	}
+
+	var uids []any
+	query := micropub.SourceQuery{Before: time.Now(), Limit: 2}
+	for range 4 {
+		entries, err := b.Source(query)
+		assert.Nil(err)
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			uids = append(uids, entry["uid"][0])
+		}
+
+		last := entries[len(entries)-1]
+		query.Before, _ = time.Parse(time.RFC3339, last["published"][0].(string))
+		query.BeforeUID = last["uid"][0].(string)
+	}
+
+	assert.Equal([]any{"f", "e", "d", "c", "b", "a"}, uids)
+}
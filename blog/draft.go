@@ -0,0 +1,51 @@
+package blog
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// markDraft records whether an entry is a draft, which is the case when its
+// post-status is "draft". Drafts are given a random hx-preview token that
+// allows them to be viewed by anyone with a link including "?preview=TOKEN".
+func markDraft(data map[string][]any) {
+	if status, _ := first(data, "post-status"); status != "draft" {
+		delete(data, "hx-draft")
+		delete(data, "hx-preview")
+		return
+	}
+
+	data["hx-draft"] = []any{true}
+	if len(data["hx-preview"]) == 0 {
+		data["hx-preview"] = []any{previewToken()}
+	}
+}
+
+func isDraft(data map[string][]any) bool {
+	return len(data["hx-draft"]) > 0
+}
+
+func previewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// canView checks whether the request can see entry. Drafts can only be seen
+// by the owner, or with the preview token.
+func (b *Blog) canView(r *http.Request, entry map[string][]any) bool {
+	if !isDraft(entry) {
+		return true
+	}
+
+	if preview, ok := first(entry, "hx-preview"); ok {
+		if subtle.ConstantTimeCompare([]byte(preview), []byte(r.FormValue("preview"))) == 1 {
+			return true
+		}
+	}
+
+	return b.config.IsOwner != nil && b.config.IsOwner(r)
+}
@@ -0,0 +1,185 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/micropub"
+)
+
+type fakeSyndicator struct {
+	mu      sync.Mutex
+	created []string
+}
+
+func (s *fakeSyndicator) Create(data map[string][]any) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.created = append(s.created, data["url"][0].(string))
+	return "https://silo.example.com/1", nil
+}
+
+func (s *fakeSyndicator) Created() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.created
+}
+
+func (s *fakeSyndicator) UID() string  { return "https://silo.example.com/" }
+func (s *fakeSyndicator) Name() string { return "silo" }
+
+type fakeHubPublisher struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (p *fakeHubPublisher) Publish(topic string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func (p *fakeHubPublisher) Published(topic string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return count(p.topics, topic)
+}
+
+func count(list []string, s string) int {
+	n := 0
+	for _, item := range list {
+		if item == s {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDraftIsPublished(t *testing.T) {
+	assert := assert.New(t)
+	syndicator := &fakeSyndicator{}
+	blog := newTestBlog(t, withSyndicator(syndicator))
+	outbox := blog.outbox.(*fakeOutbox)
+
+	// published has a precision of seconds, so look a little ahead
+	later := time.Now().Add(time.Second)
+
+	location, err := blog.Create(map[string][]any{
+		"h":               {"entry"},
+		"content":         {"Read https://example.org/post"},
+		"post-status":     {"draft"},
+		"mp-syndicate-to": {"https://silo.example.com/"},
+	})
+	assert.Nil(err)
+
+	entry, err := blog.Entry(location)
+	assert.Nil(err)
+	assert.Equal([]any{true}, entry["hx-draft"])
+	assert.Len(entry["hx-preview"], 1)
+
+	posts, err := blog.Before(later)
+	assert.Nil(err)
+	assert.Len(posts, 0)
+
+	source, err := blog.Source(micropub.SourceQuery{Before: later, Limit: 10})
+	assert.Nil(err)
+	assert.Len(source, 1)
+
+	// nothing is announced for a draft, the syndicator is checked again below
+	// as it could still be running
+	assert.Empty(outbox.Sent())
+	assert.Empty(syndicator.Created())
+
+	assert.Nil(blog.Update(location, map[string][]any{
+		"post-status": {"published"},
+	}, empty, empty, nil))
+
+	assert.Eventually(func() bool {
+		return len(outbox.Sent()) > 0 && len(syndicator.Created()) > 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(outbox.Sent(), location+" https://example.org/post")
+	assert.Equal([]string{location}, syndicator.Created())
+
+	entry, err = blog.Entry(location)
+	assert.Nil(err)
+	assert.NotContains(entry, "hx-draft")
+	assert.NotContains(entry, "hx-preview")
+
+	posts, err = blog.Before(later)
+	assert.Nil(err)
+	assert.Len(posts, 1)
+}
+
+func TestDraftCanView(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	draft := map[string][]any{
+		"post-status": {"draft"},
+	}
+	markDraft(draft)
+	preview := draft["hx-preview"][0].(string)
+
+	published := map[string][]any{}
+	markDraft(published)
+
+	request := func(query string) *http.Request {
+		return httptest.NewRequest("GET", "http://example.com/2020/10/01/hello"+query, nil)
+	}
+
+	assert.True(blog.canView(request(""), published))
+	assert.False(blog.canView(request(""), draft))
+	assert.False(blog.canView(request("?preview=wrong"), draft))
+	assert.True(blog.canView(request("?preview="+preview), draft))
+
+	blog.config.IsOwner = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer owner"
+	}
+
+	owner := request("")
+	owner.Header.Set("Authorization", "Bearer owner")
+	assert.True(blog.canView(owner, draft))
+	assert.False(blog.canView(request(""), draft))
+}
+
+func TestPublishedIsMadeDraft(t *testing.T) {
+	assert := assert.New(t)
+
+	publisher := &fakeHubPublisher{}
+	blog := newTestBlog(t, withHubPublisher(publisher))
+	outbox := blog.outbox.(*fakeOutbox)
+
+	location, err := blog.Create(map[string][]any{
+		"h":        {"entry"},
+		"content":  {"Read https://example.org/post"},
+		"category": {"go"},
+	})
+	assert.Nil(err)
+
+	mention := location + " https://example.org/post"
+	announced := func(times int) func() bool {
+		return func() bool {
+			return count(outbox.Sent(), mention) == times &&
+				publisher.Published("http://example.com/category/go") == times
+		}
+	}
+	assert.Eventually(announced(1), 3*time.Second, 10*time.Millisecond)
+
+	assert.Nil(blog.Update(location, map[string][]any{
+		"post-status": {"draft"},
+	}, empty, empty, nil))
+
+	// the mentioned page and the pages the entry was listed on are told, so
+	// they can see it has gone
+	assert.Eventually(announced(2), 3*time.Second, 10*time.Millisecond)
+	assert.Equal(2, publisher.Published(location))
+}
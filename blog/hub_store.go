@@ -2,8 +2,10 @@ package blog
 
 import (
 	"database/sql"
-	"hawx.me/code/tally-ho/websub"
+	"fmt"
 	"time"
+
+	"hawx.me/code/tally-ho/websub"
 )
 
 type HubStore struct {
@@ -23,31 +25,58 @@ func (h *HubStore) init() error {
     Secret    TEXT,
     PRIMARY KEY (Callback, Topic)
   );`)
+	if err != nil {
+		return err
+	}
+
+	// delivery statistics were added after the table was first created
+	for _, column := range [][2]string{
+		{"Deliveries", "INTEGER NOT NULL DEFAULT 0"},
+		{"Failures", "INTEGER NOT NULL DEFAULT 0"},
+		{"LastDeliveryAt", "DATETIME"},
+		{"LastStatus", "TEXT NOT NULL DEFAULT ''"},
+		{"LastError", "TEXT NOT NULL DEFAULT ''"},
+		{"Algorithm", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := addColumn(h.db, "subscriptions", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumn adds a column to table if it does not already exist.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	var exists bool
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`,
+		table,
+		column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
 
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
 	return err
 }
 
-func (h *HubStore) Subscribe(callback, topic string, expiresAt time.Time, secret string) error {
+func (h *HubStore) Subscribe(callback, topic string, expiresAt time.Time, secret, algorithm string) error {
 	_, err := h.db.Exec(`
-    DELETE FROM subscriptions
-      WHERE (Callback = ? AND Topic = ?)
-      OR ExpiresAt < ?;
-
-    INSERT INTO subscriptions(Callback, Topic, ExpiresAt, Secret)
-      VALUES (?, ?, ?, ?);`,
-		callback,
-		topic,
-		time.Now(),
+    INSERT INTO subscriptions(Callback, Topic, ExpiresAt, Secret, Algorithm)
+      VALUES (?, ?, ?, ?, ?)
+      ON CONFLICT (Callback, Topic) DO UPDATE
+      SET ExpiresAt = excluded.ExpiresAt, Secret = excluded.Secret, Algorithm = excluded.Algorithm;`,
 		callback,
 		topic,
 		expiresAt,
-		secret)
+		secret,
+		algorithm)
 
 	return err
 }
 
 func (h *HubStore) Subscribers(topic string) (websub.SubscribersIter, error) {
-	rows, err := h.db.Query(`SELECT Callback, Secret FROM subscriptions WHERE Topic = ? AND ExpiresAt > ?`,
+	rows, err := h.db.Query(`SELECT Callback, Secret, Algorithm FROM subscriptions WHERE Topic = ? AND ExpiresAt > ?`,
 		topic,
 		time.Now())
 	if err != nil {
@@ -65,6 +94,93 @@ func (h *HubStore) Unsubscribe(callback, topic string) error {
 	return err
 }
 
+// RemoveCallback deletes the subscriptions to all topics for callback.
+func (h *HubStore) RemoveCallback(callback string) error {
+	_, err := h.db.Exec(`DELETE FROM subscriptions WHERE Callback = ?`,
+		callback)
+
+	return err
+}
+
+func (h *HubStore) Delivered(callback, topic string, deliveryErr error) error {
+	status, lastError, failed := websub.StatusDelivered, "", 0
+	if deliveryErr != nil {
+		status, lastError, failed = websub.StatusFailed, deliveryErr.Error(), 1
+	}
+
+	_, err := h.db.Exec(`
+    UPDATE subscriptions
+      SET Deliveries = Deliveries + 1,
+          Failures = Failures + ?,
+          LastDeliveryAt = ?,
+          LastStatus = ?,
+          LastError = ?
+      WHERE Callback = ? AND Topic = ?;`,
+		failed,
+		time.Now().UTC(),
+		status,
+		lastError,
+		callback,
+		topic)
+
+	return err
+}
+
+func (h *HubStore) Expire(now time.Time) (int64, error) {
+	result, err := h.db.Exec(`DELETE FROM subscriptions WHERE ExpiresAt <= ?`,
+		now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Subscriptions lists the subscriptions to topic, or all subscriptions if topic
+// is empty.
+func (h *HubStore) Subscriptions(topic string) (subscriptions []websub.Subscription, err error) {
+	rows, err := h.db.Query(`
+    SELECT Callback, Topic, ExpiresAt, Deliveries, Failures, LastDeliveryAt, LastStatus, LastError, Algorithm
+      FROM subscriptions
+      WHERE ? = '' OR Topic = ?
+      ORDER BY Topic, Callback;`,
+		topic,
+		topic)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			subscription   websub.Subscription
+			lastDeliveryAt sql.NullTime
+		)
+
+		if err := rows.Scan(
+			&subscription.Callback,
+			&subscription.Topic,
+			&subscription.ExpiresAt,
+			&subscription.Deliveries,
+			&subscription.Failures,
+			&lastDeliveryAt,
+			&subscription.LastStatus,
+			&subscription.LastError,
+			&subscription.Algorithm,
+		); err != nil {
+			return nil, err
+		}
+
+		if lastDeliveryAt.Valid {
+			subscription.LastDeliveryAt = &lastDeliveryAt.Time
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
 type SubscribersIter struct {
 	rows *sql.Rows
 }
@@ -73,8 +189,8 @@ func (s *SubscribersIter) Close() error {
 	return s.rows.Close()
 }
 
-func (s *SubscribersIter) Data() (callback, secret string, err error) {
-	err = s.rows.Scan(&callback, &secret)
+func (s *SubscribersIter) Data() (callback, secret, algorithm string, err error) {
+	err = s.rows.Scan(&callback, &secret, &algorithm)
 	return
 }
 
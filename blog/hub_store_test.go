@@ -2,10 +2,12 @@ package blog
 
 import (
 	"database/sql"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/websub"
 )
 
 func TestHubStoreSubscribe(t *testing.T) {
@@ -17,16 +19,17 @@ func TestHubStoreSubscribe(t *testing.T) {
 	store, err := NewHubStore(db)
 	assert.Nil(err)
 
-	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret")
+	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret", "")
 	assert.Nil(err)
 
 	subscribers, err := store.Subscribers("topic")
 	assert.Nil(err)
 	assert.True(subscribers.Next())
 
-	callback, secret, err := subscribers.Data()
+	callback, secret, algorithm, err := subscribers.Data()
 	assert.Equal("callback", callback)
 	assert.Equal("secret", secret)
+	assert.Equal("", algorithm)
 	assert.Nil(err)
 
 	assert.False(subscribers.Next())
@@ -43,7 +46,7 @@ func TestHubStoreSubscribeWhenExpired(t *testing.T) {
 	store, err := NewHubStore(db)
 	assert.Nil(err)
 
-	err = store.Subscribe("callback", "topic", time.Now().Add(-5*time.Second), "secret")
+	err = store.Subscribe("callback", "topic", time.Now().Add(-5*time.Second), "secret", "")
 	assert.Nil(err)
 
 	subscribers, err := store.Subscribers("topic")
@@ -63,18 +66,19 @@ func TestHubStoreSubscribeTwice(t *testing.T) {
 	store, err := NewHubStore(db)
 	assert.Nil(err)
 
-	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret")
+	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret", "")
 	assert.Nil(err)
-	err = store.Subscribe("callback", "topic", time.Now().Add(10*time.Second), "newsecret")
+	err = store.Subscribe("callback", "topic", time.Now().Add(10*time.Second), "newsecret", "")
 	assert.Nil(err)
 
 	subscribers, err := store.Subscribers("topic")
 	assert.Nil(err)
 	assert.True(subscribers.Next())
 
-	callback, secret, err := subscribers.Data()
+	callback, secret, algorithm, err := subscribers.Data()
 	assert.Equal("callback", callback)
 	assert.Equal("newsecret", secret)
+	assert.Equal("", algorithm)
 	assert.Nil(err)
 
 	assert.False(subscribers.Next())
@@ -91,7 +95,7 @@ func TestHubStoreUnsubscribe(t *testing.T) {
 	store, err := NewHubStore(db)
 	assert.Nil(err)
 
-	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret")
+	err = store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret", "")
 	assert.Nil(err)
 	err = store.Unsubscribe("callback", "topic")
 	assert.Nil(err)
@@ -103,3 +107,112 @@ func TestHubStoreUnsubscribe(t *testing.T) {
 	assert.Nil(subscribers.Err())
 	assert.Nil(subscribers.Close())
 }
+
+func TestHubStoreSubscribeKeepsStatistics(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+
+	store, err := NewHubStore(db)
+	assert.Nil(err)
+
+	assert.Nil(store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "secret", ""))
+	assert.Nil(store.Delivered("callback", "topic", errors.New("subscriber returned 500")))
+	assert.Nil(store.Delivered("callback", "topic", nil))
+	assert.Nil(store.Subscribe("callback", "topic", time.Now().Add(10*time.Second), "secret", "sha256"))
+
+	subscriptions, err := store.Subscriptions("topic")
+	assert.Nil(err)
+	if assert.Len(subscriptions, 1) {
+		subscription := subscriptions[0]
+		assert.Equal("callback", subscription.Callback)
+		assert.Equal("topic", subscription.Topic)
+		assert.WithinDuration(time.Now().Add(10*time.Second), subscription.ExpiresAt, time.Second)
+		assert.Equal(2, subscription.Deliveries)
+		assert.Equal(1, subscription.Failures)
+		assert.Equal(websub.StatusDelivered, subscription.LastStatus)
+		assert.Equal("", subscription.LastError)
+		assert.Equal("sha256", subscription.Algorithm)
+		if assert.NotNil(subscription.LastDeliveryAt) {
+			assert.WithinDuration(time.Now(), *subscription.LastDeliveryAt, time.Second)
+		}
+	}
+}
+
+func TestHubStoreExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+
+	store, err := NewHubStore(db)
+	assert.Nil(err)
+
+	assert.Nil(store.Subscribe("old", "topic", time.Now().Add(-5*time.Second), "", ""))
+	assert.Nil(store.Subscribe("new", "topic", time.Now().Add(5*time.Second), "", ""))
+
+	expired, err := store.Expire(time.Now())
+	assert.Nil(err)
+	assert.Equal(int64(1), expired)
+
+	subscriptions, err := store.Subscriptions("")
+	assert.Nil(err)
+	if assert.Len(subscriptions, 1) {
+		assert.Equal("new", subscriptions[0].Callback)
+	}
+}
+
+func TestHubStoreRemoveCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+
+	store, err := NewHubStore(db)
+	assert.Nil(err)
+
+	assert.Nil(store.Subscribe("callback", "topic", time.Now().Add(5*time.Second), "", ""))
+	assert.Nil(store.Subscribe("callback", "other", time.Now().Add(5*time.Second), "", ""))
+	assert.Nil(store.Subscribe("keep", "topic", time.Now().Add(5*time.Second), "", ""))
+
+	assert.Nil(store.RemoveCallback("callback"))
+
+	subscriptions, err := store.Subscriptions("")
+	assert.Nil(err)
+	if assert.Len(subscriptions, 1) {
+		assert.Equal("keep", subscriptions[0].Callback)
+	}
+}
+
+func TestHubStoreMigratesExistingTable(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE subscriptions (
+    Callback  TEXT,
+    Topic     TEXT,
+    ExpiresAt DATETIME,
+    Secret    TEXT,
+    PRIMARY KEY (Callback, Topic)
+  );
+  INSERT INTO subscriptions VALUES ('callback', 'topic', ?, 'secret');`, time.Now().Add(5*time.Second))
+	assert.Nil(err)
+
+	store, err := NewHubStore(db)
+	assert.Nil(err)
+
+	// creating again should not try to add the columns twice
+	_, err = NewHubStore(db)
+	assert.Nil(err)
+
+	subscriptions, err := store.Subscriptions("topic")
+	assert.Nil(err)
+	if assert.Len(subscriptions, 1) {
+		assert.Equal(0, subscriptions[0].Deliveries)
+		assert.Nil(subscriptions[0].LastDeliveryAt)
+	}
+}
@@ -2,7 +2,6 @@ package blog
 
 import (
 	"log/slog"
-	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -23,16 +22,8 @@ var citeable = map[string]string{
 // safe to call this when updating a post, so it should NOT overwrite any
 // existing data.
 func (b *Blog) massage(data map[string][]any) {
-	uid := uuid.New().String()
-
-	relativeURL, _ := url.Parse("/entry/" + uid)
-	location := b.config.BaseURL.ResolveReference(relativeURL).String()
-
 	if len(data["uid"]) == 0 {
-		data["uid"] = []any{uid}
-	}
-	if len(data["url"]) == 0 {
-		data["url"] = []any{location}
+		data["uid"] = []any{uuid.New().String()}
 	}
 
 	if len(data["published"]) == 0 {
@@ -41,6 +32,8 @@ func (b *Blog) massage(data map[string][]any) {
 		data["published"] = []any{parseDate(data["published"][0].(string)).UTC().Format(time.RFC3339)}
 	}
 
+	markDraft(data)
+
 	kind := postTypeDiscovery(data)
 
 	for k, v := range citeable {
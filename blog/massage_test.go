@@ -1,7 +1,11 @@
 package blog
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,13 +13,7 @@ import (
 )
 
 func TestMassage(t *testing.T) {
-	baseURL, _ := url.Parse("http://example.com/")
-
-	b := &Blog{
-		config: Config{
-			BaseURL: baseURL,
-		},
-	}
+	b := newTestBlog(t)
 
 	testCases := map[string]struct {
 		in map[string][]interface{}
@@ -25,7 +23,7 @@ func TestMassage(t *testing.T) {
 			in: map[string][]interface{}{},
 			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
 				assert.NotEmpty(data["uid"][0].(string))
-				assert.Equal((data["url"][0].(string)), "http://example.com/entry/"+data["uid"][0].(string))
+				assert.Equal(data["url"][0].(string), "http://example.com/"+time.Now().UTC().Format("2006/01/02")+"/"+data["uid"][0].(string))
 
 				published, _ := time.Parse(time.RFC3339, data["published"][0].(string))
 				assert.WithinDuration(published, time.Now(), time.Second)
@@ -42,12 +40,219 @@ func TestMassage(t *testing.T) {
 				assert.Equal(published, time.Date(2020, time.October, 1, 12, 03, 1, 0, time.UTC))
 			},
 		},
+		"mp-slug": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T12:03:01Z"},
+				"name":      {"A title"},
+				"mp-slug":   {"My Slug"},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/2020/10/01/my-slug", data["url"][0])
+				assert.NotContains(data, "mp-slug")
+			},
+		},
+		"name": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T23:03:01-0500"},
+				"name":      {"What's new?"},
+				"content":   {"Some content"},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/2020/10/02/whats-new", data["url"][0])
+			},
+		},
+		"content": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T12:03:01Z"},
+				"content":   {"This is a much longer note that goes on for far more than fifty characters"},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/2020/10/01/this-is-a-much-longer-note-that-goes-on-for-far", data["url"][0])
+			},
+		},
+		"json content": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T12:03:01Z"},
+				"content":   {map[string]any{"html": "<p>Hi</p>", "text": "Hi there"}},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/2020/10/01/hi-there", data["url"][0])
+			},
+		},
+		"unicode name": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T12:03:01Z"},
+				"name":      {"Café in München, 東京"},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/2020/10/01/caf%C3%A9-in-m%C3%BCnchen-%E6%9D%B1%E4%BA%AC", data["url"][0])
+			},
+		},
+		"long unicode content": {
+			in: map[string][]interface{}{
+				"published": {"2020-10-01T12:03:01Z"},
+				"content":   {strings.Repeat("привет ", 10)},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				slug := strings.TrimSuffix(strings.Repeat("привет-", 7), "-")
+				assert.Equal("http://example.com/2020/10/01/"+url.PathEscape(slug), data["url"][0])
+			},
+		},
+		"existing url": {
+			in: map[string][]interface{}{
+				"url":     {"http://example.com/entry/1234"},
+				"mp-slug": {"ignored"},
+			},
+			fn: func(assert *assert.Assertions, data map[string][]interface{}) {
+				assert.Equal("http://example.com/entry/1234", data["url"][0])
+			},
+		},
 	}
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
 			b.massage(tc.in)
+			b.setURL(tc.in)
 			tc.fn(assert.New(t), tc.in)
 		})
 	}
 }
+
+func TestMassageSlugCollision(t *testing.T) {
+	assert := assert.New(t)
+	b := newTestBlog(t)
+
+	for i, expected := range []string{
+		"http://example.com/2020/10/01/hello",
+		"http://example.com/2020/10/01/hello-2",
+		"http://example.com/2020/10/01/hello-3",
+	} {
+		data := map[string][]interface{}{
+			"published": {"2020-10-01T12:03:01Z"},
+			"content":   {"Hello"},
+		}
+		b.massage(data)
+		assert.Nil(b.save(data["uid"][0].(string), data))
+		assert.Equal(expected, data["url"][0], i)
+	}
+
+	data := map[string][]interface{}{
+		"published": {"2020-10-02T12:03:01Z"},
+		"content":   {"Hello"},
+	}
+	b.massage(data)
+	b.setURL(data)
+	assert.Equal("http://example.com/2020/10/02/hello", data["url"][0])
+
+	entry, err := b.EntryBySlug("2020", "10", "01", "hello-2")
+	assert.Nil(err)
+	assert.Equal("http://example.com/2020/10/01/hello-2", entry["url"][0])
+}
+
+func TestEntryBySlugUnicode(t *testing.T) {
+	assert := assert.New(t)
+	b := newTestBlog(t)
+
+	data := map[string][]interface{}{
+		"published": {"2020-10-01T12:03:01Z"},
+		"name":      {"東京"},
+	}
+	b.massage(data)
+	assert.Nil(b.save(data["uid"][0].(string), data))
+
+	entry, err := b.EntryBySlug("2020", "10", "01", "東京")
+	assert.Nil(err)
+	assert.Equal(data["url"][0], entry["url"][0])
+}
+
+func TestCreateConcurrentSlugs(t *testing.T) {
+	assert := assert.New(t)
+	b := newTestBlog(t)
+
+	const n = 50
+
+	var wg sync.WaitGroup
+	locations := make(chan string, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			location, err := b.Create(map[string][]interface{}{
+				"published":   {"2020-10-01T12:03:01Z"},
+				"name":        {"Hello"},
+				"post-status": {"draft"},
+			})
+			assert.Nil(err)
+			locations <- location
+		}()
+	}
+	wg.Wait()
+	close(locations)
+
+	seen := map[string]bool{}
+	for location := range locations {
+		assert.False(seen[location], location)
+		seen[location] = true
+	}
+	assert.Len(seen, n)
+}
+
+func TestBackfillPermalinks(t *testing.T) {
+	assert := assert.New(t)
+	b := newTestBlog(t)
+
+	assert.Nil(b.entries.SetProperties("1234", map[string][]interface{}{
+		"uid":       {"1234"},
+		"url":       {"http://example.com/entry/1234"},
+		"published": {"2020-10-01T12:03:01Z"},
+		"name":      {"Old post"},
+	}))
+	assert.Nil(b.entries.SetProperties("5678", map[string][]interface{}{
+		"uid":       {"5678"},
+		"url":       {"http://example.com/2020/10/01/new-post"},
+		"published": {"2020-10-01T12:03:01Z"},
+		"name":      {"New post"},
+	}))
+	assert.Nil(b.mentions.SetProperties("https://example.org/reply", map[string][]interface{}{
+		"hx-target":   {"http://example.com/entry/1234"},
+		"in-reply-to": {"http://example.com/entry/1234"},
+	}))
+
+	assert.Nil(b.backfillPermalinks())
+
+	entry, err := b.EntryByUID("1234")
+	assert.Nil(err)
+	assert.Equal([]interface{}{"http://example.com/2020/10/01/old-post"}, entry["url"])
+	assert.Equal([]interface{}{"http://example.com/entry/1234"}, entry[legacyURL])
+
+	entry, err = b.EntryByUID("5678")
+	assert.Nil(err)
+	assert.Equal([]interface{}{"http://example.com/2020/10/01/new-post"}, entry["url"])
+	assert.NotContains(entry, legacyURL)
+
+	// the old URL still finds the entry, so webmentions can be sent to it
+	entry, err = b.Entry("http://example.com/entry/1234")
+	assert.Nil(err)
+	assert.Equal("1234", entry["uid"][0])
+
+	// backfilling again changes nothing
+	assert.Nil(b.backfillPermalinks())
+	entry, _ = b.EntryByUID("1234")
+	assert.Equal([]interface{}{"http://example.com/2020/10/01/old-post"}, entry["url"])
+
+	mux := b.Handler()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/entry/1234", nil))
+	assert.Equal(http.StatusMovedPermanently, w.Code)
+	assert.Equal("http://example.com/2020/10/01/old-post", w.Header().Get("Location"))
+
+	// mentions sent to the old URL are still shown
+	entry, _ = b.EntryByUID("1234")
+	mentions, err := b.entryMentions(entry)
+	assert.Nil(err)
+	if assert.Len(mentions, 1) {
+		assert.Equal("https://example.org/reply", mentions[0].Subject)
+	}
+}
@@ -0,0 +1,143 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"hawx.me/code/tally-ho/webmention"
+)
+
+type MentionQueue struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+func NewMentionQueue(db *sql.DB) (*MentionQueue, error) {
+	q := &MentionQueue{db: db}
+	return q, q.init()
+}
+
+func (q *MentionQueue) init() error {
+	_, err := q.db.Exec(`CREATE TABLE IF NOT EXISTS mention_queue (
+    ID          TEXT PRIMARY KEY,
+    Source      TEXT,
+    Target      TEXT,
+    Status      TEXT,
+    Reason      TEXT,
+    Attempts    INTEGER,
+    NextAttempt DATETIME,
+    CreatedAt   DATETIME,
+    UpdatedAt   DATETIME
+  );`)
+
+	return err
+}
+
+func (q *MentionQueue) Enqueue(source, target string) (string, error) {
+	id := uuid.New().String()
+	now := time.Now().UTC()
+
+	_, err := q.db.Exec(`
+    INSERT INTO mention_queue(ID, Source, Target, Status, Reason, Attempts, NextAttempt, CreatedAt, UpdatedAt)
+      VALUES (?, ?, ?, ?, '', 0, ?, ?, ?);`,
+		id,
+		source,
+		target,
+		webmention.StatusQueued,
+		now,
+		now,
+		now)
+
+	return id, err
+}
+
+func (q *MentionQueue) Claim(now time.Time) (job webmention.Job, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	row := q.db.QueryRow(`
+    SELECT ID, Source, Target, Status, Reason, Attempts
+      FROM mention_queue
+      WHERE Status = ? AND NextAttempt <= ?
+      ORDER BY NextAttempt
+      LIMIT 1;`,
+		webmention.StatusQueued,
+		now.UTC())
+
+	if err = row.Scan(&job.ID, &job.Source, &job.Target, &job.Status, &job.Reason, &job.Attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return job, false, nil
+		}
+		return
+	}
+
+	job.Status = webmention.StatusProcessing
+	job.Attempts++
+
+	_, err = q.db.Exec(`
+    UPDATE mention_queue
+      SET Status = ?, Attempts = ?, UpdatedAt = ?
+      WHERE ID = ?;`,
+		job.Status,
+		job.Attempts,
+		time.Now().UTC(),
+		job.ID)
+
+	return job, err == nil, err
+}
+
+func (q *MentionQueue) Finish(id, status, reason string) error {
+	_, err := q.db.Exec(`
+    UPDATE mention_queue
+      SET Status = ?, Reason = ?, UpdatedAt = ?
+      WHERE ID = ?;`,
+		status,
+		reason,
+		time.Now().UTC(),
+		id)
+
+	return err
+}
+
+func (q *MentionQueue) Retry(id string, at time.Time, reason string) error {
+	_, err := q.db.Exec(`
+    UPDATE mention_queue
+      SET Status = ?, Reason = ?, NextAttempt = ?, UpdatedAt = ?
+      WHERE ID = ?;`,
+		webmention.StatusQueued,
+		reason,
+		at.UTC(),
+		time.Now().UTC(),
+		id)
+
+	return err
+}
+
+func (q *MentionQueue) Job(id string) (job webmention.Job, err error) {
+	row := q.db.QueryRow(`
+    SELECT ID, Source, Target, Status, Reason, Attempts
+      FROM mention_queue
+      WHERE ID = ?;`,
+		id)
+
+	err = row.Scan(&job.ID, &job.Source, &job.Target, &job.Status, &job.Reason, &job.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = webmention.ErrJobNotFound
+	}
+
+	return
+}
+
+func (q *MentionQueue) Requeue() error {
+	_, err := q.db.Exec(`
+    UPDATE mention_queue
+      SET Status = ?
+      WHERE Status = ?;`,
+		webmention.StatusQueued,
+		webmention.StatusProcessing)
+
+	return err
+}
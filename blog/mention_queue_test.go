@@ -0,0 +1,127 @@
+package blog
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/webmention"
+)
+
+func TestMentionQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	queue, err := NewMentionQueue(db)
+	assert.Nil(err)
+
+	id, err := queue.Enqueue("http://source.example.com/", "http://example.com/weblog/post-id")
+	assert.Nil(err)
+
+	job, ok, err := queue.Claim(time.Now())
+	assert.Nil(err)
+	if assert.True(ok) {
+		assert.Equal(id, job.ID)
+		assert.Equal("http://source.example.com/", job.Source)
+		assert.Equal("http://example.com/weblog/post-id", job.Target)
+		assert.Equal(webmention.StatusProcessing, job.Status)
+		assert.Equal(1, job.Attempts)
+	}
+
+	_, ok, err = queue.Claim(time.Now())
+	assert.Nil(err)
+	assert.False(ok)
+}
+
+func TestMentionQueueRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	queue, err := NewMentionQueue(db)
+	assert.Nil(err)
+
+	id, err := queue.Enqueue("http://source.example.com/", "http://example.com/weblog/post-id")
+	assert.Nil(err)
+
+	_, ok, err := queue.Claim(time.Now())
+	assert.Nil(err)
+	assert.True(ok)
+
+	err = queue.Retry(id, time.Now().Add(time.Minute), "could not retrieve 'source'")
+	assert.Nil(err)
+
+	_, ok, err = queue.Claim(time.Now())
+	assert.Nil(err)
+	assert.False(ok)
+
+	job, ok, err := queue.Claim(time.Now().Add(2 * time.Minute))
+	assert.Nil(err)
+	if assert.True(ok) {
+		assert.Equal(id, job.ID)
+		assert.Equal("could not retrieve 'source'", job.Reason)
+		assert.Equal(2, job.Attempts)
+	}
+}
+
+func TestMentionQueueRequeue(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	queue, err := NewMentionQueue(db)
+	assert.Nil(err)
+
+	id, err := queue.Enqueue("http://source.example.com/", "http://example.com/weblog/post-id")
+	assert.Nil(err)
+
+	_, ok, err := queue.Claim(time.Now())
+	assert.Nil(err)
+	assert.True(ok)
+
+	assert.Nil(queue.Requeue())
+
+	job, ok, err := queue.Claim(time.Now())
+	assert.Nil(err)
+	if assert.True(ok) {
+		assert.Equal(id, job.ID)
+	}
+}
+
+func TestMentionQueueJob(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	queue, err := NewMentionQueue(db)
+	assert.Nil(err)
+
+	id, err := queue.Enqueue("http://source.example.com/", "http://example.com/weblog/post-id")
+	assert.Nil(err)
+
+	assert.Nil(queue.Finish(id, webmention.StatusFailed, "source does not link to target"))
+
+	job, err := queue.Job(id)
+	assert.Nil(err)
+	assert.Equal(webmention.Job{
+		ID:       id,
+		Source:   "http://source.example.com/",
+		Target:   "http://example.com/weblog/post-id",
+		Status:   webmention.StatusFailed,
+		Reason:   "source does not link to target",
+		Attempts: 0,
+	}, job)
+
+	_, err = queue.Job("missing")
+	assert.Equal(webmention.ErrJobNotFound, err)
+}
@@ -0,0 +1,316 @@
+package blog
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"hawx.me/code/numbersix"
+	"hawx.me/code/tally-ho/internal/mfutil"
+)
+
+const (
+	ruleBlock = "block"
+	ruleAllow = "allow"
+
+	// ruleReplied is kept for the exact URL of each entry that has been replied
+	// to, and of its author, it is not a rule that can be changed.
+	ruleReplied = "replied"
+)
+
+// moderation stores rules for which sources of mentions are blocked, or allowed
+// to be shown without being approved. A rule's value is either a domain, which
+// also matches any subdomains, or an exact URL.
+type moderation struct {
+	db *sql.DB
+}
+
+func newModeration(db *sql.DB) (*moderation, error) {
+	m := &moderation{db}
+	return m, m.init()
+}
+
+func (m *moderation) init() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS moderation (
+    Kind  TEXT,
+    Value TEXT,
+    PRIMARY KEY (Kind, Value)
+  );`)
+
+	return err
+}
+
+func (m *moderation) add(kind, value string) error {
+	_, err := m.db.Exec(`INSERT OR IGNORE INTO moderation(Kind, Value) VALUES (?, ?)`,
+		kind,
+		value)
+
+	return err
+}
+
+func (m *moderation) remove(value string) error {
+	_, err := m.db.Exec(`DELETE FROM moderation WHERE Kind IN (?, ?) AND Value = ?`,
+		ruleBlock,
+		ruleAllow,
+		value)
+
+	return err
+}
+
+// has checks for a rule with exactly value, without considering domains.
+func (m *moderation) has(kind, value string) (ok bool, err error) {
+	err = m.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM moderation WHERE Kind = ? AND Value = ?)`,
+		kind,
+		value).Scan(&ok)
+
+	return
+}
+
+func (m *moderation) empty(kind string) (empty bool, err error) {
+	err = m.db.QueryRow(`SELECT NOT EXISTS(SELECT 1 FROM moderation WHERE Kind = ?)`,
+		kind).Scan(&empty)
+
+	return
+}
+
+func (m *moderation) list(kind string) (values []string, err error) {
+	rows, err := m.db.Query(`SELECT Value FROM moderation WHERE Kind = ? ORDER BY Value`,
+		kind)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (m *moderation) matches(kind, source string) (bool, error) {
+	values, err := m.list(kind)
+	if err != nil {
+		return false, err
+	}
+
+	for _, value := range values {
+		if ruleMatches(value, source) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func ruleMatches(rule, source string) bool {
+	if rule == source {
+		return true
+	}
+
+	if strings.Contains(rule, "/") {
+		return false
+	}
+
+	return hostMatches(rule, source)
+}
+
+func hostMatches(domain, source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	domain = strings.ToLower(domain)
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// Block prevents any mentions being received from the source, which may be
+// either a domain or an exact URL. Any existing mentions from the source are
+// removed.
+func (b *Blog) Block(source string) error {
+	if err := b.moderation.add(ruleBlock, source); err != nil {
+		return err
+	}
+
+	triples, err := b.mentions.List(numbersix.Has("hx-target"))
+	if err != nil {
+		return err
+	}
+
+	for _, group := range numbersix.Grouped(triples) {
+		if ruleMatches(source, group.Subject) {
+			if err := b.RejectMention(group.Subject); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Allow approves any mentions received from the source, which may be either a
+// domain or an exact URL.
+func (b *Blog) Allow(source string) error {
+	return b.moderation.add(ruleAllow, source)
+}
+
+// Unblock removes any rule, blocking or allowing, for the source.
+func (b *Blog) Unblock(source string) error {
+	return b.moderation.remove(source)
+}
+
+// Blocked lists the domains and URLs that mentions are blocked from.
+func (b *Blog) Blocked() ([]string, error) {
+	return b.moderation.list(ruleBlock)
+}
+
+// Allowed lists the domains and URLs that mentions are automatically approved
+// from.
+func (b *Blog) Allowed() ([]string, error) {
+	return b.moderation.list(ruleAllow)
+}
+
+// PendingMentions lists the mentions that have not yet been approved.
+func (b *Blog) PendingMentions() (list []numbersix.Group, err error) {
+	triples, err := b.mentions.List(numbersix.Has("hx-pending"))
+	if err != nil {
+		return
+	}
+
+	list = numbersix.Grouped(triples)
+	return
+}
+
+// ApproveMention shows a pending mention, future updates to the mention will
+// also be approved.
+func (b *Blog) ApproveMention(source string) error {
+	if err := b.moderation.add(ruleAllow, source); err != nil {
+		return err
+	}
+
+	target, err := b.mentionTarget(source)
+	if err != nil {
+		return err
+	}
+
+	return b.changeMentions(target, func() error {
+		return b.mentions.DeletePredicate(source, "hx-pending")
+	})
+}
+
+// RejectMention removes a mention.
+func (b *Blog) RejectMention(source string) error {
+	target, err := b.mentionTarget(source)
+	if err != nil {
+		return err
+	}
+
+	return b.changeMentions(target, func() error {
+		return b.mentions.DeleteSubject(source)
+	})
+}
+
+func (b *Blog) mentionTarget(source string) (string, error) {
+	triples, err := b.mentions.List(numbersix.Has("hx-target"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, group := range numbersix.Grouped(triples) {
+		if group.Subject == source {
+			target, _ := mfutil.Get(group.Properties, "hx-target").(string)
+			return target, nil
+		}
+	}
+
+	return "", nil
+}
+
+// approved checks whether a mention from source can be shown without being
+// moderated. This is the case if it has been explicitly allowed, if it is from
+// an entry that has been replied to, or if its author has been replied to or is
+// a contact. Only exact URLs are compared, so that replying to someone on a silo
+// does not approve everyone else there, and the author must be on the same host
+// as source so that it can't be claimed by anyone.
+func (b *Blog) approved(source string, data map[string][]interface{}) (bool, error) {
+	if ok, err := b.moderation.matches(ruleAllow, source); ok || err != nil {
+		return ok, err
+	}
+
+	if ok, err := b.moderation.has(ruleReplied, source); ok || err != nil {
+		return ok, err
+	}
+
+	author, _ := mfutil.Get(data, "author.properties.url").(string)
+	if author == "" || !sameHost(author, source) {
+		return false, nil
+	}
+
+	if ok, err := b.moderation.has(ruleReplied, author); ok || err != nil {
+		return ok, err
+	}
+
+	return b.contacts.has(author)
+}
+
+func sameHost(a, b string) bool {
+	aURL, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	bURL, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return aURL.Hostname() != "" && strings.EqualFold(aURL.Hostname(), bURL.Hostname())
+}
+
+// noteReplies records the entries that data is a reply to, along with their
+// authors, so that mentions from them are approved.
+func (b *Blog) noteReplies(data map[string][]interface{}) error {
+	for _, reply := range data["in-reply-to"] {
+		urls := []any{
+			mfutil.Get(reply, "properties.url", ""),
+			mfutil.Get(reply, "properties.author.properties.url", "properties.author"),
+		}
+
+		for _, u := range urls {
+			if u, ok := u.(string); ok && u != "" {
+				if err := b.moderation.add(ruleReplied, u); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// backfillReplies records the entries replied to before they were noted when
+// replying, see noteReplies.
+func (b *Blog) backfillReplies() error {
+	if empty, err := b.moderation.empty(ruleReplied); !empty || err != nil {
+		return err
+	}
+
+	triples, err := b.entries.List(numbersix.Has("in-reply-to").Without("hx-deleted").Without("hx-draft"))
+	if err != nil {
+		return err
+	}
+
+	for _, group := range numbersix.Grouped(triples) {
+		if err := b.noteReplies(group.Properties); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
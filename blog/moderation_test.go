@@ -0,0 +1,202 @@
+package blog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/micropub"
+	"hawx.me/code/tally-ho/webmention"
+)
+
+func reply(target string) map[string][]interface{} {
+	return map[string][]interface{}{
+		"hx-target":   {target},
+		"in-reply-to": {target},
+		"published":   {"2019-01-02T15:04:05Z"},
+	}
+}
+
+func TestMentionIsPending(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Equal(webmention.ErrPending, blog.Mention("https://stranger.example.com/1", reply("http://example.com/post")))
+
+	mentions, err := blog.MentionsForEntry("http://example.com/post")
+	assert.Nil(err)
+	assert.Len(mentions, 0)
+
+	pending, err := blog.PendingMentions()
+	assert.Nil(err)
+	if assert.Len(pending, 1) {
+		assert.Equal("https://stranger.example.com/1", pending[0].Subject)
+	}
+
+	assert.Nil(blog.ApproveMention("https://stranger.example.com/1"))
+
+	mentions, err = blog.MentionsForEntry("http://example.com/post")
+	assert.Nil(err)
+	assert.Len(mentions, 1)
+
+	// updates to an approved mention stay approved
+	assert.Nil(blog.Mention("https://stranger.example.com/1", reply("http://example.com/post")))
+
+	mentions, err = blog.MentionsForEntry("http://example.com/post")
+	assert.Nil(err)
+	assert.Len(mentions, 1)
+}
+
+func TestMentionFromAllowedDomain(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.Allow("friend.example.com"))
+	assert.Nil(blog.Mention("https://www.friend.example.com/1", reply("http://example.com/post")))
+
+	mentions, err := blog.MentionsForEntry("http://example.com/post")
+	assert.Nil(err)
+	assert.Len(mentions, 1)
+}
+
+func replyBy(target, author string) map[string][]interface{} {
+	data := reply(target)
+	data["author"] = []interface{}{map[string]interface{}{
+		"properties": map[string]interface{}{
+			"url": []interface{}{author},
+		},
+	}}
+	return data
+}
+
+func TestMentionFromRepliedTo(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.noteReplies(map[string][]interface{}{
+		"in-reply-to": {map[string]interface{}{
+			"type": []interface{}{"h-cite"},
+			"properties": map[string][]interface{}{
+				"url": {"https://friend.example.com/a-post"},
+				"author": {map[string]interface{}{
+					"properties": map[string][]interface{}{
+						"url": {"https://friend.example.com/"},
+					},
+				}},
+			},
+		}},
+	}))
+
+	for source, data := range map[string]map[string][]interface{}{
+		"https://friend.example.com/a-post": reply("http://example.com/post"),
+		"https://friend.example.com/2":      replyBy("http://example.com/post", "https://friend.example.com/"),
+	} {
+		approved, err := blog.approved(source, data)
+		assert.Nil(err)
+		assert.True(approved, source)
+	}
+
+	for source, data := range map[string]map[string][]interface{}{
+		"https://friend.example.com/3": reply("http://example.com/post"),
+		"https://other.example.com/1":  replyBy("http://example.com/post", "https://friend.example.com/"),
+		"https://friend.example.com/4": replyBy("http://example.com/post", "https://friend.example.com/someone-else"),
+	} {
+		approved, err := blog.approved(source, data)
+		assert.Nil(err)
+		assert.False(approved, source)
+	}
+}
+
+func TestMentionFromSiloRepliedTo(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.noteReplies(map[string][]interface{}{
+		"in-reply-to": {map[string]interface{}{
+			"properties": map[string][]interface{}{
+				"url":    {"https://github.com/jane/repo/issues/1"},
+				"author": {"https://github.com/jane"},
+			},
+		}},
+	}))
+
+	// replying to one person on a silo does not approve anyone else there
+	assert.Equal(webmention.ErrPending, blog.Mention("https://github.com/bob/repo/issues/2", replyBy("http://example.com/post", "https://github.com/bob")))
+
+	pending, err := blog.PendingMentions()
+	assert.Nil(err)
+	assert.Len(pending, 1)
+
+	approved, err := blog.approved("https://github.com/jane/repo/issues/3", replyBy("http://example.com/post", "https://github.com/jane"))
+	assert.Nil(err)
+	assert.True(approved)
+}
+
+func TestMentionFromContact(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.SetContact(micropub.Contact{URL: "https://jane.example.com/"}))
+	assert.Nil(blog.Mention("https://jane.example.com/1", replyBy("http://example.com/post", "https://jane.example.com/")))
+
+	mentions, err := blog.MentionsForEntry("http://example.com/post")
+	assert.Nil(err)
+	assert.Len(mentions, 1)
+}
+
+func TestBackfillReplies(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Nil(blog.entries.SetProperties("1", map[string][]interface{}{
+		"url":         {"http://example.com/reply"},
+		"in-reply-to": {"https://friend.example.com/a-post"},
+	}))
+	assert.Nil(blog.entries.SetProperties("2", map[string][]interface{}{
+		"url":         {"http://example.com/deleted-reply"},
+		"in-reply-to": {"https://enemy.example.com/a-post"},
+		"hx-deleted":  {true},
+	}))
+
+	assert.Nil(blog.backfillReplies())
+
+	ok, err := blog.moderation.has(ruleReplied, "https://friend.example.com/a-post")
+	assert.Nil(err)
+	assert.True(ok)
+
+	ok, err = blog.moderation.has(ruleReplied, "https://enemy.example.com/a-post")
+	assert.Nil(err)
+	assert.False(ok)
+
+	// replies are not listed as rules, or removed with them
+	allowed, err := blog.Allowed()
+	assert.Nil(err)
+	assert.Empty(allowed)
+
+	assert.Nil(blog.Unblock("https://friend.example.com/a-post"))
+	ok, _ = blog.moderation.has(ruleReplied, "https://friend.example.com/a-post")
+	assert.True(ok)
+}
+
+func TestMentionFromBlockedSource(t *testing.T) {
+	assert := assert.New(t)
+	blog := newTestBlog(t)
+
+	assert.Equal(webmention.ErrPending, blog.Mention("https://spam.example.com/1", reply("http://example.com/post")))
+	assert.Nil(blog.Block("spam.example.com"))
+	assert.Nil(blog.Block("https://other.example.com/bad"))
+
+	pending, err := blog.PendingMentions()
+	assert.Nil(err)
+	assert.Len(pending, 0)
+
+	assert.Equal(webmention.ErrBlocked, blog.Mention("https://spam.example.com/2", reply("http://example.com/post")))
+	assert.Equal(webmention.ErrBlocked, blog.Mention("https://other.example.com/bad", reply("http://example.com/post")))
+	assert.Equal(webmention.ErrPending, blog.Mention("https://other.example.com/good", reply("http://example.com/post")))
+
+	blocked, err := blog.Blocked()
+	assert.Nil(err)
+	assert.Equal([]string{"https://other.example.com/bad", "spam.example.com"}, blocked)
+
+	assert.Nil(blog.Unblock("spam.example.com"))
+	assert.Equal(webmention.ErrPending, blog.Mention("https://spam.example.com/2", reply("http://example.com/post")))
+}
@@ -0,0 +1,177 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"hawx.me/code/tally-ho/webmention"
+)
+
+type OutboxStore struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+func NewOutboxStore(db *sql.DB) (*OutboxStore, error) {
+	s := &OutboxStore{db: db}
+	return s, s.init()
+}
+
+func (s *OutboxStore) init() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+    Source      TEXT,
+    Target      TEXT,
+    Endpoint    TEXT,
+    Status      TEXT,
+    StatusCode  INTEGER,
+    Location    TEXT,
+    Attempts    INTEGER,
+    LastError   TEXT,
+    NextAttempt DATETIME,
+    UpdatedAt   DATETIME,
+    PRIMARY KEY (Source, Target)
+  );`)
+
+	return err
+}
+
+func (s *OutboxStore) Add(source, target string, at time.Time) error {
+	_, err := s.db.Exec(`
+    INSERT OR REPLACE INTO outbox(Source, Target, Endpoint, Status, StatusCode, Location, Attempts, LastError, NextAttempt, UpdatedAt)
+      VALUES (?, ?, '', ?, 0, '', 0, '', ?, ?);`,
+		source,
+		target,
+		webmention.StatusQueued,
+		at.UTC(),
+		time.Now().UTC())
+
+	return err
+}
+
+func (s *OutboxStore) Claim(now time.Time) (delivery webmention.Delivery, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.db.QueryRow(`
+    SELECT Source, Target, Endpoint, Status, StatusCode, Location, Attempts, LastError, UpdatedAt
+      FROM outbox
+      WHERE Status = ? AND NextAttempt <= ?
+      ORDER BY NextAttempt
+      LIMIT 1;`,
+		webmention.StatusQueued,
+		now.UTC())
+
+	if delivery, err = scanDelivery(row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return delivery, false, nil
+		}
+		return
+	}
+
+	delivery.Status = webmention.StatusProcessing
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now().UTC()
+
+	_, err = s.db.Exec(`
+    UPDATE outbox
+      SET Status = ?, Attempts = ?, UpdatedAt = ?
+      WHERE Source = ? AND Target = ?;`,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.UpdatedAt,
+		delivery.Source,
+		delivery.Target)
+
+	return delivery, err == nil, err
+}
+
+func (s *OutboxStore) Finish(delivery webmention.Delivery) error {
+	_, err := s.db.Exec(`
+    UPDATE outbox
+      SET Endpoint = ?, Status = ?, StatusCode = ?, Location = ?, LastError = ?, UpdatedAt = ?
+      WHERE Source = ? AND Target = ?;`,
+		delivery.Endpoint,
+		delivery.Status,
+		delivery.StatusCode,
+		delivery.Location,
+		delivery.LastError,
+		time.Now().UTC(),
+		delivery.Source,
+		delivery.Target)
+
+	return err
+}
+
+func (s *OutboxStore) Retry(delivery webmention.Delivery, at time.Time) error {
+	_, err := s.db.Exec(`
+    UPDATE outbox
+      SET Endpoint = ?, Status = ?, StatusCode = ?, Location = ?, LastError = ?, NextAttempt = ?, UpdatedAt = ?
+      WHERE Source = ? AND Target = ?;`,
+		delivery.Endpoint,
+		webmention.StatusQueued,
+		delivery.StatusCode,
+		delivery.Location,
+		delivery.LastError,
+		at.UTC(),
+		time.Now().UTC(),
+		delivery.Source,
+		delivery.Target)
+
+	return err
+}
+
+func (s *OutboxStore) Deliveries(source string) (deliveries []webmention.Delivery, err error) {
+	rows, err := s.db.Query(`
+    SELECT Source, Target, Endpoint, Status, StatusCode, Location, Attempts, LastError, UpdatedAt
+      FROM outbox
+      WHERE ? = '' OR Source = ?
+      ORDER BY Source, Target;`,
+		source,
+		source)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *OutboxStore) Requeue() error {
+	_, err := s.db.Exec(`
+    UPDATE outbox
+      SET Status = ?
+      WHERE Status = ?;`,
+		webmention.StatusQueued,
+		webmention.StatusProcessing)
+
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(row scanner) (delivery webmention.Delivery, err error) {
+	err = row.Scan(
+		&delivery.Source,
+		&delivery.Target,
+		&delivery.Endpoint,
+		&delivery.Status,
+		&delivery.StatusCode,
+		&delivery.Location,
+		&delivery.Attempts,
+		&delivery.LastError,
+		&delivery.UpdatedAt)
+
+	return
+}
@@ -0,0 +1,87 @@
+package blog
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/webmention"
+)
+
+func TestOutboxStore(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(err)
+	db.SetMaxOpenConns(1)
+
+	store, err := NewOutboxStore(db)
+	assert.Nil(err)
+
+	assert.Nil(store.Add("http://example.com/post", "http://other.example.com/", time.Now().Add(time.Minute)))
+
+	_, ok, err := store.Claim(time.Now())
+	assert.Nil(err)
+	assert.False(ok)
+
+	delivery, ok, err := store.Claim(time.Now().Add(2 * time.Minute))
+	assert.Nil(err)
+	if !assert.True(ok) {
+		return
+	}
+	assert.Equal("http://example.com/post", delivery.Source)
+	assert.Equal("http://other.example.com/", delivery.Target)
+	assert.Equal(webmention.StatusProcessing, delivery.Status)
+	assert.Equal(1, delivery.Attempts)
+
+	delivery.Endpoint = "http://other.example.com/webmention"
+	delivery.StatusCode = 503
+	delivery.LastError = "endpoint returned 503"
+	assert.Nil(store.Retry(delivery, time.Now()))
+
+	delivery, ok, err = store.Claim(time.Now().Add(time.Second))
+	assert.Nil(err)
+	if !assert.True(ok) {
+		return
+	}
+	assert.Equal(2, delivery.Attempts)
+	assert.Equal("endpoint returned 503", delivery.LastError)
+
+	delivery.Status = webmention.StatusSent
+	delivery.StatusCode = 201
+	delivery.Location = "http://other.example.com/webmention/1"
+	delivery.LastError = ""
+	assert.Nil(store.Finish(delivery))
+
+	deliveries, err := store.Deliveries("http://example.com/post")
+	assert.Nil(err)
+	if assert.Len(deliveries, 1) {
+		assert.Equal(webmention.StatusSent, deliveries[0].Status)
+		assert.Equal(201, deliveries[0].StatusCode)
+		assert.Equal("http://other.example.com/webmention", deliveries[0].Endpoint)
+		assert.Equal("http://other.example.com/webmention/1", deliveries[0].Location)
+		assert.Equal(2, deliveries[0].Attempts)
+	}
+
+	deliveries, err = store.Deliveries("http://example.com/other")
+	assert.Nil(err)
+	assert.Len(deliveries, 0)
+
+	// adding again resets the delivery so it is sent again
+	assert.Nil(store.Add("http://example.com/post", "http://other.example.com/", time.Now()))
+
+	delivery, ok, err = store.Claim(time.Now().Add(time.Second))
+	assert.Nil(err)
+	if assert.True(ok) {
+		assert.Equal(1, delivery.Attempts)
+	}
+
+	assert.Nil(store.Requeue())
+
+	deliveries, err = store.Deliveries("")
+	assert.Nil(err)
+	if assert.Len(deliveries, 1) {
+		assert.Equal(webmention.StatusQueued, deliveries[0].Status)
+	}
+}
@@ -1,11 +1,30 @@
 package blog
 
 import (
+	"errors"
+	"log/slog"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"hawx.me/code/numbersix"
+	"hawx.me/code/tally-ho/micropub"
 )
 
-var nonWord = regexp.MustCompile("\\W+")
+// nonWord matches anything that is not a letter or number, in any script, so
+// that titles that are not in English still give a slug.
+var nonWord = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// legacyURL is the property that keeps the /entry/:uid URL of an entry created
+// before permalinks used slugs, once it has been given one. Requests for it are
+// redirected to the permalink, and mentions sent to it are still shown.
+const legacyURL = "hx-legacy-url"
+
+// maxSlugLength is the most characters a slug taken from the name or content of
+// an entry can have, a slug given with mp-slug is not shortened.
+const maxSlugLength = 50
 
 func slugify(s string) string {
 	s = strings.ReplaceAll(s, "'", "")
@@ -16,3 +35,124 @@ func slugify(s string) string {
 
 	return s
 }
+
+// entrySlug picks the slug for an entry. This is either mp-slug, or taken from
+// the start of the name or content. If none of these contain any words the uid
+// is used.
+func entrySlug(data map[string][]any) string {
+	if s, ok := first(data, "mp-slug"); ok {
+		if slug := slugify(s); slug != "" {
+			return slug
+		}
+	}
+
+	text, _ := first(data, "name")
+	if text == "" && len(data["content"]) > 0 {
+		switch content := data["content"][0].(type) {
+		case string:
+			text = content
+		case map[string]any:
+			text, _ = content["text"].(string)
+		}
+	}
+
+	if slug := slugify(text); slug != "" {
+		if runes := []rune(slug); len(runes) > maxSlugLength {
+			slug = string(runes[:maxSlugLength])
+			if i := strings.LastIndex(slug, "-"); i > 0 {
+				slug = slug[:i]
+			}
+		}
+
+		return slug
+	}
+
+	uid, _ := first(data, "uid")
+	return uid
+}
+
+// setURL gives data a permalink, unless it already has a url. It must be called
+// with b.saving held until data is saved, so that two entries can't be given
+// the same permalink.
+func (b *Blog) setURL(data map[string][]any) {
+	if len(data["url"]) == 0 {
+		uid, _ := first(data, "uid")
+		published, _ := first(data, "published")
+		publishedTime, _ := time.Parse(time.RFC3339, published)
+
+		location, err := b.permalink(publishedTime, entrySlug(data))
+		if err != nil {
+			b.logger.Warn("permalink", slog.Any("err", err))
+			location = b.entryURL(uid)
+		}
+
+		data["url"] = []any{location}
+	}
+	delete(data, "mp-slug")
+}
+
+// permalink returns the URL for an entry, in the form "/2006/01/02/slug". If
+// another entry already has the URL a number is added to the end of the slug.
+func (b *Blog) permalink(published time.Time, slug string) (string, error) {
+	path := published.UTC().Format("/2006/01/02/") + url.PathEscape(slug)
+
+	for i := 1; ; i++ {
+		location := b.absoluteURL(path)
+		if i > 1 {
+			location += "-" + strconv.Itoa(i)
+		}
+
+		_, err := b.Entry(location)
+		if errors.Is(err, micropub.ErrNotFound) {
+			return location, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// EntryBySlug returns the entry with the permalink for the date and slug.
+func (b *Blog) EntryBySlug(year, month, day, slug string) (map[string][]any, error) {
+	return b.Entry(b.absoluteURL("/" + year + "/" + month + "/" + day + "/" + url.PathEscape(slug)))
+}
+
+// backfillPermalinks gives a permalink to each entry that was created before
+// permalinks used slugs, keeping the URL it had as legacyURL.
+func (b *Blog) backfillPermalinks() error {
+	triples, err := b.entries.List(numbersix.Has("url").Without(legacyURL))
+	if err != nil {
+		return err
+	}
+
+	b.saving.Lock()
+	defer b.saving.Unlock()
+
+	for _, group := range numbersix.Grouped(triples) {
+		data := group.Properties
+
+		uid, _ := first(data, "uid")
+		location, _ := first(data, "url")
+		if uid == "" || location != b.entryURL(uid) {
+			continue
+		}
+
+		delete(data, "url")
+		b.setURL(data)
+		if data["url"][0] == location {
+			continue
+		}
+		data[legacyURL] = []any{location}
+
+		if err := b.entries.DeleteSubject(group.Subject); err != nil {
+			return err
+		}
+		if err := b.entries.SetProperties(group.Subject, data); err != nil {
+			return err
+		}
+
+		b.logger.Info("gave entry a permalink", slog.String("from", location), slog.Any("to", data["url"][0]))
+	}
+
+	return nil
+}
@@ -1,8 +1,11 @@
 package blog
 
 import (
-	"errors"
+	"fmt"
+	"log/slog"
 	"time"
+
+	"hawx.me/code/tally-ho/micropub"
 )
 
 func (b *Blog) Update(
@@ -19,7 +22,7 @@ func (b *Blog) Update(
 
 	id, ok := oldData["uid"][0].(string)
 	if !ok {
-		return errors.New("post to update not found")
+		return fmt.Errorf("%w: post to update not found", micropub.ErrNotFound)
 	}
 
 	for predicate, values := range replace {
@@ -48,15 +51,29 @@ func (b *Blog) Update(
 
 	b.massage(newData)
 
-	if err := b.entries.DeleteSubject(id); err != nil {
+	if err := b.save(id, newData); err != nil {
 		return err
 	}
-	if err := b.entries.SetProperties(id, newData); err != nil {
-		return err
+
+	if !isDraft(newData) {
+		if err := b.noteReplies(newData); err != nil {
+			b.logger.Warn("note replies", slog.Any("err", err))
+		}
 	}
 
-	go b.sendUpdateWebmentions(url, oldData, newData)
-	go b.hubPublish()
+	switch {
+	case isDraft(oldData) && isDraft(newData):
+		// nothing is announced until the entry is published
+	case isDraft(oldData):
+		go b.syndicate(url, newData)
+		go b.sendWebmentions(url, newData)
+		go b.hubPublish(newData)
+	default:
+		// this includes moving a published entry back to draft, so that the
+		// pages it was listed on, or mentioned, can see that it has gone
+		go b.sendUpdateWebmentions(url, oldData, newData)
+		go b.hubPublish(oldData, newData)
+	}
 
 	return nil
 }
@@ -3,31 +3,24 @@ package blog
 import (
 	"log/slog"
 	"net/url"
+	"reflect"
 	"slices"
 	"strings"
-	"time"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"hawx.me/code/numbersix"
 	"hawx.me/code/tally-ho/internal/htmlutil"
 	"hawx.me/code/tally-ho/internal/mfutil"
-	"hawx.me/code/tally-ho/webmention"
 )
 
-func (b *Blog) sendWebmentions(location string, data map[string][]interface{}) {
-	// ensure that the entry exists
-	time.Sleep(time.Second)
-
-	links := findMentionedLinks(data)
-	slog.Info("sending webmentions", slog.Any("links", links))
+// Outbox sends webmentions.
+type Outbox interface {
+	Queue(source, target string) error
+}
 
-	if !b.local {
-		for _, link := range links {
-			if err := webmention.Send(location, link); err != nil {
-				slog.Error("send webmention", slog.String("source", location), slog.String("target", link), slog.Any("err", err))
-			}
-		}
-	}
+func (b *Blog) sendWebmentions(location string, data map[string][]interface{}) {
+	b.queueWebmentions(location, findMentionedLinks(data))
 }
 
 func (b *Blog) sendUpdateWebmentions(location string, oldData, newData map[string][]interface{}) {
@@ -39,17 +32,84 @@ func (b *Blog) sendUpdateWebmentions(location string, oldData, newData map[strin
 		}
 	}
 
+	b.queueWebmentions(location, links)
+}
+
+func (b *Blog) queueWebmentions(location string, links []string) {
 	slog.Info("sending webmentions", slog.Any("links", links))
 
 	if !b.local {
 		for _, link := range links {
-			if err := webmention.Send(location, link); err != nil {
-				slog.Error("send webmention", slog.String("source", location), slog.String("target", link), slog.Any("err", err))
+			if err := b.outbox.Queue(location, link); err != nil {
+				slog.Error("queue webmention", slog.String("source", location), slog.String("target", link), slog.Any("err", err))
 			}
 		}
 	}
 }
 
+// changeMentions runs change, which should modify the mentions of the entry at
+// target. If the mentions shown for the entry are different afterwards then
+// webmentions are resent to anything the entry replies to, so that they can
+// see the updated conversation. See https://indieweb.org/Salmention.
+func (b *Blog) changeMentions(target string, change func() error) error {
+	before, err := b.MentionsForEntry(target)
+	if err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	after, err := b.MentionsForEntry(target)
+	if err != nil {
+		return err
+	}
+
+	if mentionsChanged(before, after) {
+		go b.sendSalmentions(target)
+	}
+
+	return nil
+}
+
+func (b *Blog) sendSalmentions(location string) {
+	entry, err := b.Entry(location)
+	if err != nil || mfutil.Has(entry, "hx-deleted") {
+		return
+	}
+
+	var links []string
+	for _, reply := range entry["in-reply-to"] {
+		if v, ok := mfutil.Get(reply, "properties.url", "").(string); ok {
+			if u, err := url.Parse(v); err == nil && u.IsAbs() {
+				links = append(links, v)
+			}
+		}
+	}
+
+	b.queueWebmentions(location, links)
+}
+
+func mentionsChanged(before, after []numbersix.Group) bool {
+	if len(before) != len(after) {
+		return true
+	}
+
+	properties := make(map[string]map[string][]interface{}, len(before))
+	for _, group := range before {
+		properties[group.Subject] = group.Properties
+	}
+
+	for _, group := range after {
+		if old, ok := properties[group.Subject]; !ok || !reflect.DeepEqual(old, group.Properties) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func findMentionedLinks(data map[string][]interface{}) []string {
 	linkSet := map[string]struct{}{}
 
@@ -0,0 +1,59 @@
+package blog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOutbox struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (o *fakeOutbox) Queue(source, target string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sent = append(o.sent, source+" "+target)
+	return nil
+}
+
+func (o *fakeOutbox) Sent() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.sent
+}
+
+func TestSalmention(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := newTestBlog(t)
+	outbox := blog.outbox.(*fakeOutbox)
+
+	assert.Nil(blog.entries.SetProperties("1", map[string][]interface{}{
+		"url":         {"http://example.com/reply"},
+		"in-reply-to": {"https://example.org/post"},
+	}))
+	assert.Nil(blog.Allow("friend.example.com"))
+
+	mention := func() map[string][]interface{} {
+		return map[string][]interface{}{
+			"hx-target":   {"http://example.com/reply"},
+			"in-reply-to": {"http://example.com/reply"},
+			"name":        {"nested"},
+		}
+	}
+
+	assert.Nil(blog.Mention("https://friend.example.com/1", mention()))
+	assert.Eventually(func() bool { return len(outbox.Sent()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal([]string{"http://example.com/reply https://example.org/post"}, outbox.Sent())
+
+	// receiving the same mention again does not change the thread
+	assert.Nil(blog.Mention("https://friend.example.com/1", mention()))
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(outbox.Sent(), 1)
+}
@@ -1,8 +1,11 @@
 package blog
 
 import (
+	"errors"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"sort"
 	"time"
 )
 
@@ -10,28 +13,112 @@ type HubPublisher interface {
 	Publish(topic string) error
 }
 
-func (b *Blog) hubPublish() {
-	// for now just publish the main things that will always change
-	changed := []string{
-		"/",
-		"/feed/atom",
-		"/feed/jsonfeed",
-		"/feed/rss",
+// HubPublishers notifies each of the publishers of a change to a topic.
+type HubPublishers []HubPublisher
+
+func (ps HubPublishers) Publish(topic string) error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.Publish(topic))
+	}
+
+	return errors.Join(errs...)
+}
+
+// hubPublish notifies the hub of changes to every topic that the given versions
+// of an entry appear in.
+func (b *Blog) hubPublish(versions ...map[string][]interface{}) {
+	if len(b.config.HubURLs) == 0 {
+		return
 	}
 
 	// ensure that the entry exists
 	time.Sleep(time.Second)
 
-	for _, c := range changed {
-		u, err := url.Parse(c)
-		if err != nil {
-			b.logger.Warn("hub publish parse url", slog.String("url", c), slog.Any("err", err))
-			continue
+	for _, topic := range b.topics(versions...) {
+		if err := b.hubPublisher.Publish(topic); err != nil {
+			b.logger.Warn("hub publish", slog.String("url", topic), slog.Any("err", err))
+		}
+	}
+}
+
+// topics returns the URLs of the pages that list any of the versions of an
+// entry. Passing the entry before and after an update will include pages it
+// has been removed from.
+func (b *Blog) topics(versions ...map[string][]interface{}) []string {
+	set := map[string]struct{}{
+		b.absoluteURL("/"):              {},
+		b.absoluteURL("/feed/atom"):     {},
+		b.absoluteURL("/feed/jsonfeed"): {},
+		b.absoluteURL("/feed/rss"):      {},
+	}
+
+	for _, data := range versions {
+		if location, ok := first(data, "url"); ok {
+			set[location] = struct{}{}
+		} else if uid, ok := first(data, "uid"); ok {
+			set[b.entryURL(uid)] = struct{}{}
 		}
 
-		err = b.hubPublisher.Publish(b.config.BaseURL.ResolveReference(u).String())
-		if err != nil {
-			b.logger.Warn("hub publish", slog.String("url", c), slog.Any("err", err))
+		if kind, ok := first(data, "hx-kind"); ok {
+			set[b.kindURL(kind)] = struct{}{}
 		}
+
+		for _, v := range data["category"] {
+			if category, ok := v.(string); ok {
+				set[b.categoryURL(category)] = struct{}{}
+			}
+		}
+
+		if len(data["like-of"]) > 0 {
+			if published, ok := first(data, "published"); ok && len(published) >= 10 {
+				set[b.likesURL(published[:10])] = struct{}{}
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(set))
+	for topic := range set {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	return topics
+}
+
+func first(data map[string][]interface{}, key string) (string, bool) {
+	if len(data[key]) == 0 {
+		return "", false
+	}
+
+	s, ok := data[key][0].(string)
+	return s, ok && s != ""
+}
+
+func (b *Blog) entryURL(uid string) string {
+	return b.absoluteURL("/entry/" + url.PathEscape(uid))
+}
+
+func (b *Blog) kindURL(kind string) string {
+	return b.absoluteURL("/kind/" + url.PathEscape(kind))
+}
+
+func (b *Blog) categoryURL(category string) string {
+	return b.absoluteURL("/category/" + url.PathEscape(category))
+}
+
+func (b *Blog) likesURL(ymd string) string {
+	return b.absoluteURL("/likes/" + url.PathEscape(ymd))
+}
+
+// linkHub advertises the hubs that topic can be subscribed to with.
+func (b *Blog) linkHub(w http.ResponseWriter, topic string) {
+	if len(b.config.HubURLs) == 0 {
+		return
+	}
+
+	w.Header().Add("Link", `<`+topic+`>; rel="self"`)
+	for _, hubURL := range b.config.HubURLs {
+		w.Header().Add("Link", `<`+hubURL+`>; rel="hub"`)
 	}
 }
@@ -0,0 +1,42 @@
+package blog
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopics(t *testing.T) {
+	assert := assert.New(t)
+
+	baseURL, _ := url.Parse("http://example.com/")
+	blog := &Blog{config: Config{BaseURL: baseURL}}
+
+	before := map[string][]interface{}{
+		"uid":       {"1234"},
+		"hx-kind":   {"note"},
+		"category":  {"go", "web dev"},
+		"published": {"2019-01-02T15:04:05Z"},
+	}
+	after := map[string][]interface{}{
+		"uid":       {"1234"},
+		"hx-kind":   {"like"},
+		"like-of":   {"http://example.org/post"},
+		"category":  {"go"},
+		"published": {"2019-01-02T15:04:05Z"},
+	}
+
+	assert.Equal([]string{
+		"http://example.com/",
+		"http://example.com/category/go",
+		"http://example.com/category/web%20dev",
+		"http://example.com/entry/1234",
+		"http://example.com/feed/atom",
+		"http://example.com/feed/jsonfeed",
+		"http://example.com/feed/rss",
+		"http://example.com/kind/like",
+		"http://example.com/kind/note",
+		"http://example.com/likes/2019-01-02",
+	}, blog.topics(before, after))
+}
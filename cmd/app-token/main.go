@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"hawx.me/code/tally-ho/auth"
+	"hawx.me/code/tally-ho/blog"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: app-token --db PATH COMMAND
+
+  Manages tokens for clients that post to tally-ho without using IndieAuth.
+
+Commands:
+  create --label LABEL --scope SCOPES [--expires DURATION]
+      Creates a token, printing it. SCOPES is a space or comma separated list
+      of: %s.
+
+  list
+      Lists the tokens.
+
+  revoke ID
+      Revokes the token with ID.
+`, strings.Join(auth.AppScopes, ", "))
+}
+
+func main() {
+	dbPath := flag.String("db", "", "the database used by tally-ho")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *dbPath == "" || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		log.Println("ERR open-db;", err)
+		return
+	}
+	defer db.Close()
+
+	store, err := blog.NewAppTokenStore(db)
+	if err != nil {
+		log.Println("ERR init-store;", err)
+		return
+	}
+	tokens := auth.NewAppTokens(store, "")
+
+	switch flag.Arg(0) {
+	case "create":
+		create(tokens, flag.Args()[1:])
+	case "list":
+		list(tokens)
+	case "revoke":
+		revoke(tokens, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func create(tokens *auth.AppTokens, args []string) {
+	var (
+		set     = flag.NewFlagSet("create", flag.ExitOnError)
+		label   = set.String("label", "", "the name of the client, recorded as its client ID")
+		scope   = set.String("scope", "create media", "the scopes to grant")
+		expires = set.Duration("expires", 0, "how long until the token expires, by default it does not")
+	)
+	set.Parse(args)
+
+	var expiresAt time.Time
+	if *expires > 0 {
+		expiresAt = time.Now().Add(*expires)
+	}
+
+	scopes := strings.FieldsFunc(*scope, func(r rune) bool { return r == ' ' || r == ',' })
+
+	secret, token, err := tokens.Create(*label, scopes, expiresAt)
+	if err != nil {
+		log.Println("ERR create;", err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "created token %d for %s with scopes %s\n", token.ID, token.Label, strings.Join(token.Scopes, " "))
+	fmt.Println(secret)
+}
+
+func list(tokens *auth.AppTokens) {
+	list, err := tokens.List()
+	if err != nil {
+		log.Println("ERR list;", err)
+		return
+	}
+
+	for _, token := range list {
+		expires := "never"
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Format(time.RFC3339)
+		}
+
+		fmt.Printf("%d\t%s\t%s\texpires %s\n", token.ID, token.Label, strings.Join(token.Scopes, " "), expires)
+	}
+}
+
+func revoke(tokens *auth.AppTokens, args []string) {
+	if len(args) != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Println("ERR invalid id;", err)
+		return
+	}
+
+	if err := tokens.Revoke(id); err != nil {
+		log.Println("ERR revoke;", err)
+		return
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func main() {
+	var (
+		me     = flag.String("me", "", "the URL of the site, used to label the TOTP secret")
+		noTOTP = flag.Bool("no-totp", false, "do not generate a TOTP secret")
+	)
+	flag.Parse()
+
+	fmt.Fprint(os.Stderr, "password (leave empty to only use TOTP): ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Println("ERR read-password;", err)
+		return
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	if password == "" && *noTOTP {
+		log.Println("ERR a password or TOTP secret is required")
+		return
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Println("ERR hash-password;", err)
+			return
+		}
+
+		fmt.Printf("AUTH_PASSWORD_HASH='%s'\n", hash)
+	}
+
+	if !*noTOTP {
+		secret := make([]byte, 20)
+		if _, err := rand.Read(secret); err != nil {
+			log.Println("ERR generate-secret;", err)
+			return
+		}
+		encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+
+		fmt.Printf("AUTH_TOTP_SECRET=%s\n", encoded)
+		fmt.Fprintf(os.Stderr, "\nadd to an authenticator app with: otpauth://totp/%s?secret=%s&issuer=tally-ho\n",
+			url.PathEscape("tally-ho:"+*me),
+			encoded)
+	}
+}
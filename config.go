@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"log/slog"
 	"os"
+	"time"
 )
 
 const (
@@ -23,6 +25,15 @@ const (
 	AuthUrl          = "AUTH_ENDPOINT"
 	TokenUrl         = "TOKEN_ENDPOINT"
 	BypassValidation = "BYPASS_VALIDATION"
+	AllowPrivate     = "ALLOW_PRIVATE_FETCH"
+	HubUrl           = "HUB_URL"
+	EmbeddedHub      = "EMBEDDED_HUB"
+	HubSignature     = "HUB_SIGNATURE_ALGORITHM"
+	AuthPasswordHash = "AUTH_PASSWORD_HASH"
+	AuthTOTPSecret   = "AUTH_TOTP_SECRET"
+	AuthDiscoveryTTL = "AUTH_DISCOVERY_TTL"
+	AuthTokenTTL     = "AUTH_TOKEN_CACHE_TTL"
+	AuthIntrospect   = "AUTH_INTROSPECTION_TOKEN"
 )
 
 func parseConfig() config {
@@ -100,6 +111,50 @@ func parseConfig() config {
 			conf.BypassValidation = true
 		}
 	}
+	if p := os.Getenv(AllowPrivate); p != "" {
+		if p == "true" {
+			conf.AllowPrivate = true
+		}
+	}
+	if p := os.Getenv(HubUrl); p != "" {
+		conf.HubURL = p
+	}
+	if p := os.Getenv(AuthPasswordHash); p != "" {
+		conf.AuthPasswordHash = p
+	}
+	if p := os.Getenv(AuthTOTPSecret); p != "" {
+		conf.AuthTOTPSecret = p
+	}
+	if p := os.Getenv(AuthDiscoveryTTL); p != "" {
+		conf.AuthDiscoveryTTL = parseDuration(AuthDiscoveryTTL, p)
+	}
+	if p := os.Getenv(AuthTokenTTL); p != "" {
+		conf.AuthTokenTTL = parseDuration(AuthTokenTTL, p)
+	}
+	if p := os.Getenv(AuthIntrospect); p != "" {
+		conf.AuthIntrospectionToken = p
+	}
+	if p := os.Getenv(HubSignature); p != "" {
+		conf.HubSignature = p
+	}
+	conf.EmbeddedHub = true
+	if p := os.Getenv(EmbeddedHub); p != "" {
+		if p == "false" {
+			conf.EmbeddedHub = false
+		}
+	}
 
 	return conf
 }
+
+// parseDuration reads a duration such as "90s" or "1h", if it is invalid it is
+// ignored so that the default is used.
+func parseDuration(name, value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid duration", slog.String("name", name), slog.String("value", value), slog.Any("err", err))
+		return 0
+	}
+
+	return d
+}
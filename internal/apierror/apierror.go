@@ -0,0 +1,105 @@
+// Package apierror provides errors that are written as the JSON bodies used by
+// Micropub and OAuth 2.0.
+//
+// See https://www.w3.org/TR/micropub/#error-response and
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.2.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Error is an error that can be returned to a client.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code is the value of the "error" property, for instance
+	// "invalid_request".
+	Code string
+	// Description is a message to help the developer of the client, it is not
+	// meant to be shown to users.
+	Description string
+	// Scope is the scope that would have allowed the request, only used for
+	// "insufficient_scope".
+	Scope string
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// New returns an Error with the given status, code and description.
+func New(status int, code, description string) *Error {
+	return &Error{Status: status, Code: code, Description: description}
+}
+
+// InvalidRequest is returned when a request is missing a parameter, or one of
+// its values is not acceptable.
+func InvalidRequest(description string) *Error {
+	return New(http.StatusBadRequest, "invalid_request", description)
+}
+
+// Unauthorized is returned when a request does not include a token.
+func Unauthorized(description string) *Error {
+	return New(http.StatusUnauthorized, "unauthorized", description)
+}
+
+// Forbidden is returned when a token is invalid, or is not for the user.
+func Forbidden(description string) *Error {
+	return New(http.StatusForbidden, "forbidden", description)
+}
+
+// InsufficientScope is returned when a token does not have any of the listed
+// scopes.
+func InsufficientScope(scopes ...string) *Error {
+	scope := strings.Join(scopes, " ")
+
+	return &Error{
+		Status:      http.StatusUnauthorized,
+		Code:        "insufficient_scope",
+		Description: "the token must have one of the scopes: " + scope,
+		Scope:       scope,
+	}
+}
+
+// ServerError is returned when the request could not be completed due to a
+// problem on the server.
+func ServerError() *Error {
+	return New(http.StatusInternalServerError, "server_error", "")
+}
+
+// Write responds with err. If err is not, and does not wrap, an *Error it is
+// logged and a server_error is written so that internal details are not
+// exposed.
+func Write(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		slog.Error("request failed", slog.Any("err", err))
+		apiErr = ServerError()
+	}
+
+	if apiErr.Code == "insufficient_scope" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+apiErr.Scope+`"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+		Scope       string `json:"scope,omitempty"`
+	}{
+		Error:       apiErr.Code,
+		Description: apiErr.Description,
+		Scope:       apiErr.Scope,
+	})
+}
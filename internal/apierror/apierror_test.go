@@ -0,0 +1,69 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	testCases := map[string]struct {
+		err         error
+		status      int
+		code        string
+		description string
+		scope       string
+	}{
+		"invalid request": {
+			err:         InvalidRequest("missing url"),
+			status:      http.StatusBadRequest,
+			code:        "invalid_request",
+			description: "missing url",
+		},
+		"wrapped": {
+			err:         fmt.Errorf("update: %w", Forbidden("not yours")),
+			status:      http.StatusForbidden,
+			code:        "forbidden",
+			description: "not yours",
+		},
+		"insufficient scope": {
+			err:         InsufficientScope("media", "create"),
+			status:      http.StatusUnauthorized,
+			code:        "insufficient_scope",
+			description: "the token must have one of the scopes: media create",
+			scope:       "media create",
+		},
+		"other error": {
+			err:    errors.New("database is on fire"),
+			status: http.StatusInternalServerError,
+			code:   "server_error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			w := httptest.NewRecorder()
+			Write(w, tc.err)
+
+			assert.Equal(tc.status, w.Code)
+			assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+			var v map[string]string
+			assert.Nil(json.NewDecoder(w.Body).Decode(&v))
+			assert.Equal(tc.code, v["error"])
+			assert.Equal(tc.description, v["error_description"])
+			assert.Equal(tc.scope, v["scope"])
+
+			if tc.scope != "" {
+				assert.Equal(`Bearer error="insufficient_scope", scope="`+tc.scope+`"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
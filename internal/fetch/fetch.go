@@ -0,0 +1,155 @@
+// Package fetch provides a http.Client that is safe to use for requesting
+// URLs given by other sites.
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	// Timeout limits how long a request, including reading its body, can take.
+	Timeout = 10 * time.Second
+
+	// MaxBodySize limits how much of a response body can be read.
+	MaxBodySize = 5 << 20
+
+	// UserAgent is sent with requests that do not set their own.
+	UserAgent = "tally-ho (+https://hawx.me/code/tally-ho)"
+)
+
+var (
+	// ErrPrivateAddress is returned when a request is made to a loopback,
+	// private or otherwise internal address.
+	ErrPrivateAddress = errors.New("fetch: address is not public")
+
+	// ErrBodyTooLarge is returned when reading more than MaxBodySize of a
+	// response body.
+	ErrBodyTooLarge = errors.New("fetch: body too large")
+)
+
+// blockedPrefixes lists the ranges, not already covered by the methods of
+// netip.Addr, that should not be requested.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// New returns a client with a timeout, that limits the size of response
+// bodies and sets a User-Agent. Unless allowPrivate is true, requests to
+// addresses that are not public, for instance 127.0.0.1 or 169.254.169.254, are
+// refused. This check is made on the address connected to, so also applies to
+// redirects and hostnames that resolve to such addresses.
+func New(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   Timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if !allowPrivate {
+		// a proxy would be connected to instead of the real address
+		transport.Proxy = nil
+	}
+
+	return &http.Client{
+		Timeout:   Timeout,
+		Transport: &roundTripper{next: transport},
+	}
+}
+
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+	}
+
+	if !IsPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+	}
+
+	return nil
+}
+
+// IsPublic returns false if addr is a loopback, private, link-local, or other
+// special purpose address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsValid() ||
+		addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Body = &limitedBody{
+		r:      io.LimitReader(resp.Body, MaxBodySize+1),
+		closer: resp.Body,
+		left:   MaxBodySize,
+	}
+
+	return resp, nil
+}
+
+// limitedBody returns ErrBodyTooLarge if more than MaxBodySize is read.
+type limitedBody struct {
+	r      io.Reader
+	closer io.Closer
+	left   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		return n + int(b.left), ErrBodyTooLarge
+	}
+
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.closer.Close()
+}
@@ -0,0 +1,95 @@
+package fetch
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublic(t *testing.T) {
+	testCases := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::6810:85e5": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.0.0.1":             false,
+		"172.16.5.4":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+	}
+
+	for addr, expected := range testCases {
+		t.Run(addr, func(t *testing.T) {
+			assert.Equal(t, expected, IsPublic(netip.MustParseAddr(addr)))
+		})
+	}
+}
+
+func TestNewRefusesPrivate(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	_, err := New(false).Get(s.URL)
+	assert.True(errors.Is(err, ErrPrivateAddress))
+
+	resp, err := New(true).Get(s.URL)
+	if assert.Nil(err) {
+		resp.Body.Close()
+	}
+}
+
+func TestNewSetsUserAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	agents := make(chan string, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+	}))
+	defer s.Close()
+
+	client := New(true)
+
+	resp, err := client.Get(s.URL)
+	assert.Nil(err)
+	resp.Body.Close()
+	assert.Equal(UserAgent, <-agents)
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("User-Agent", "custom")
+	resp, err = client.Do(req)
+	assert.Nil(err)
+	resp.Body.Close()
+	assert.Equal("custom", <-agents)
+}
+
+func TestNewLimitsBody(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("a", MaxBodySize+10))
+	}))
+	defer s.Close()
+
+	resp, err := New(true).Get(s.URL)
+	if !assert.Nil(err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Equal(ErrBodyTooLarge, err)
+	assert.Len(body, MaxBodySize)
+}
@@ -0,0 +1,150 @@
+package htmlutil
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedElements lists the elements kept by Sanitize, along with the
+// attributes that are kept on them.
+var allowedElements = map[atom.Atom][]string{
+	atom.A:          {"href"},
+	atom.Abbr:       {"title"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Code:       nil,
+	atom.Del:        nil,
+	atom.Em:         nil,
+	atom.I:          nil,
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Q:          nil,
+	atom.S:          nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Ul:         nil,
+}
+
+// droppedElements lists the elements that are removed along with everything
+// they contain.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Template: true,
+	atom.Noscript: true,
+	atom.Form:     true,
+	atom.Head:     true,
+	atom.Title:    true,
+}
+
+// Sanitize returns s with only a small set of formatting elements kept, any
+// other elements are removed but their text is kept. Links are only allowed to
+// http(s) URLs and are marked as user generated. The text is cut, with an
+// ellipsis, once it is longer than maxLength characters.
+func Sanitize(s string, maxLength int) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return ""
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	remaining := maxLength
+	for _, node := range nodes {
+		sanitizeNode(root, node, &remaining)
+	}
+
+	var b strings.Builder
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		html.Render(&b, child)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func sanitizeNode(parent, node *html.Node, remaining *int) {
+	if *remaining <= 0 {
+		return
+	}
+
+	switch node.Type {
+	case html.TextNode:
+		text := node.Data
+		if n := utf8.RuneCountInString(text); n > *remaining {
+			text = string([]rune(text)[:*remaining]) + "…"
+			*remaining = 0
+		} else {
+			*remaining -= n
+		}
+
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+
+	case html.ElementNode:
+		if droppedElements[node.DataAtom] {
+			return
+		}
+
+		attrs, ok := allowedElements[node.DataAtom]
+		if !ok {
+			sanitizeChildren(parent, node, remaining)
+			return
+		}
+
+		clean := &html.Node{
+			Type:     html.ElementNode,
+			Data:     node.Data,
+			DataAtom: node.DataAtom,
+		}
+
+		for _, attr := range node.Attr {
+			if attr.Namespace == "" && slices.Contains(attrs, attr.Key) {
+				if attr.Key == "href" && !IsWebURL(attr.Val) {
+					continue
+				}
+				clean.Attr = append(clean.Attr, html.Attribute{Key: attr.Key, Val: attr.Val})
+			}
+		}
+
+		if node.DataAtom == atom.A {
+			clean.Attr = append(clean.Attr, html.Attribute{Key: "rel", Val: "nofollow ugc"})
+		}
+
+		parent.AppendChild(clean)
+		sanitizeChildren(clean, node, remaining)
+
+	case html.DocumentNode:
+		sanitizeChildren(parent, node, remaining)
+	}
+}
+
+func sanitizeChildren(parent, node *html.Node, remaining *int) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		sanitizeNode(parent, child, remaining)
+	}
+}
+
+// IsWebURL checks that s is an absolute http or https URL, so that it is safe
+// to use as a link or image source.
+func IsWebURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "http" || u.Scheme == "https"
+}
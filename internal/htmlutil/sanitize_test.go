@@ -0,0 +1,55 @@
+package htmlutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	testCases := map[string]struct {
+		in, out string
+		max     int
+	}{
+		"text": {
+			in:  "hello &amp; welcome",
+			out: "hello &amp; welcome",
+		},
+		"allowed elements": {
+			in:  `<p>a <em>b</em> <strong class="x">c</strong></p>`,
+			out: `<p>a <em>b</em> <strong>c</strong></p>`,
+		},
+		"unknown elements": {
+			in:  `<div><span style="color: red">hi</span></div>`,
+			out: `hi`,
+		},
+		"dropped elements": {
+			in:  `hi<script>alert(1)</script><style>p{}</style>`,
+			out: `hi`,
+		},
+		"links": {
+			in:  `<a href="https://example.com/" target="_blank">ok</a> <a href="javascript:alert(1)">bad</a>`,
+			out: `<a href="https://example.com/" rel="nofollow ugc">ok</a> <a rel="nofollow ugc">bad</a>`,
+		},
+		"images": {
+			in:  `<img src="https://example.com/x.png" onerror="alert(1)">`,
+			out: ``,
+		},
+		"truncated": {
+			in:  `<p>0123456789</p><p>more</p>`,
+			out: `<p>01234…</p>`,
+			max: 5,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			max := tc.max
+			if max == 0 {
+				max = 100
+			}
+
+			assert.Equal(t, tc.out, Sanitize(tc.in, max))
+		})
+	}
+}
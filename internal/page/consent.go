@@ -0,0 +1,77 @@
+package page
+
+import (
+	"sort"
+
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+)
+
+type ConsentData struct {
+	ClientID    string
+	RedirectURI string
+	Scopes      []string
+	// Params are the parameters of the authorization request, which are
+	// submitted again with the form.
+	Params   map[string]string
+	Password bool
+	TOTP     bool
+	Error    string
+}
+
+// Consent is the page shown by the authorization endpoint, asking the owner to
+// log in and choose which of the requested scopes to grant to a client.
+func Consent(data ConsentData) lmth.Node {
+	var params []string
+	for key := range data.Params {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+
+	return Html(lmth.Attr{"lang": "en"},
+		Head(lmth.Attr{},
+			Meta(lmth.Attr{"charset": "utf-8"}),
+			Title(lmth.Attr{}, lmth.Text("sign in to "+data.ClientID)),
+			Meta(lmth.Attr{"content": "width=device-width, initial-scale=1", "name": "viewport"}),
+			Link(lmth.Attr{"rel": "stylesheet", "href": "/public/styles.css", "type": "text/css"}),
+		),
+		Body(lmth.Attr{"class": "no-hero"},
+			Main(lmth.Attr{"class": "consent"},
+				P(lmth.Attr{"class": "page"},
+					Strong(lmth.Attr{}, lmth.Text(data.ClientID)),
+					lmth.Text(" would like to sign in"),
+				),
+				lmth.Toggle(data.Error != "", P(lmth.Attr{"class": "error"}, lmth.Text(data.Error))),
+				Form(lmth.Attr{"method": "post", "action": "/-/auth"},
+					lmth.Map(func(key string) lmth.Node {
+						return Input(lmth.Attr{"type": "hidden", "name": key, "value": data.Params[key]})
+					}, params),
+					lmth.Toggle(len(data.Scopes) > 0, Ul(lmth.Attr{"class": "scopes"},
+						lmth.Map(func(scope string) lmth.Node {
+							return Li(lmth.Attr{},
+								Label(lmth.Attr{},
+									Input(lmth.Attr{"type": "checkbox", "name": "scope", "value": scope, "checked": "checked"}),
+									lmth.Text(" "+scope),
+								),
+							)
+						}, data.Scopes),
+					)),
+					P(lmth.Attr{},
+						lmth.Text("you will be redirected to "),
+						Code(lmth.Attr{}, lmth.Text(data.RedirectURI)),
+					),
+					lmth.Toggle(data.Password, Label(lmth.Attr{},
+						lmth.Text("password "),
+						Input(lmth.Attr{"type": "password", "name": "password", "autocomplete": "current-password"}),
+					)),
+					lmth.Toggle(data.TOTP, Label(lmth.Attr{},
+						lmth.Text("code "),
+						Input(lmth.Attr{"type": "text", "name": "totp", "inputmode": "numeric", "autocomplete": "one-time-code"}),
+					)),
+					Button(lmth.Attr{"type": "submit", "name": "action", "value": "approve"}, lmth.Text("allow")),
+					Button(lmth.Attr{"type": "submit", "name": "action", "value": "deny"}, lmth.Text("deny")),
+				),
+			),
+		),
+	)
+}
@@ -0,0 +1,157 @@
+package page
+
+import (
+	"fmt"
+
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+	"hawx.me/code/numbersix"
+	"hawx.me/code/tally-ho/internal/htmlutil"
+	"hawx.me/code/tally-ho/internal/mfutil"
+)
+
+// interactions lists the mentions received for an entry. Replies are shown in
+// threads, by matching their in-reply-to against the other replies, followed
+// by any likes, reposts or other mentions.
+func interactions(mentions []numbersix.Group) lmth.Node {
+	var (
+		replies  []numbersix.Group
+		others   []numbersix.Group
+		urls     = map[string]bool{}
+		children = map[string][]numbersix.Group{}
+	)
+
+	for _, mention := range mentions {
+		if mfutil.Has(mention.Properties, "in-reply-to") {
+			replies = append(replies, mention)
+			urls[mentionURL(mention)] = true
+		} else {
+			others = append(others, mention)
+		}
+	}
+
+	var threads []numbersix.Group
+	for _, reply := range replies {
+		if parent, ok := replyParent(reply, urls); ok {
+			children[parent] = append(children[parent], reply)
+		} else {
+			threads = append(threads, reply)
+		}
+	}
+
+	var replyNode func(numbersix.Group) lmth.Node
+	replyNode = func(mention numbersix.Group) lmth.Node {
+		replies := children[mentionURL(mention)]
+
+		return Li(lmth.Attr{"class": "h-cite p-comment"},
+			mentionAuthor(mention),
+			Div(lmth.Attr{"class": "e-content"},
+				mentionContent(mention),
+			),
+			A(lmth.Attr{"class": "u-url", "href": mentionURL(mention)},
+				Time(lmth.Attr{"class": "dt-published", "datetime": templateGet(mention.Properties, "published")},
+					lmth.Text(templateHumanDateTime(mention.Properties, "published")),
+				),
+			),
+			lmth.Toggle(len(replies) > 0,
+				Ol(lmth.Attr{"class": "replies"},
+					lmth.Map(replyNode, replies),
+				),
+			),
+		)
+	}
+
+	return Details(lmth.Attr{"class": "meta"},
+		Summary(lmth.Attr{},
+			lmth.Text(fmt.Sprintf("Interactions (%d)", len(mentions))),
+		),
+		lmth.Toggle(len(threads) > 0,
+			Ol(lmth.Attr{"class": "replies"},
+				lmth.Map(replyNode, threads),
+			),
+		),
+		lmth.Toggle(len(others) > 0,
+			Ol(lmth.Attr{"class": "inner"},
+				lmth.Map(func(mention numbersix.Group) lmth.Node {
+					name := "mentioned by "
+					if mfutil.Has(mention.Properties, "repost-of") {
+						name = "reposted by "
+					} else if mfutil.Has(mention.Properties, "like-of") {
+						name = "liked by "
+					}
+
+					return Li(lmth.Attr{},
+						lmth.Text(name),
+						A(lmth.Attr{"href": mentionURL(mention)},
+							lmth.Text(mentionAuthorName(mention)),
+						),
+					)
+				}, others),
+			),
+		),
+	)
+}
+
+// mentionURL returns the URL of the mention, unsafe URLs are dropped when a
+// mention is received but may have been stored before that was done.
+func mentionURL(mention numbersix.Group) string {
+	if u := templateGet(mention.Properties, "url"); htmlutil.IsWebURL(u) {
+		return u
+	}
+
+	return mention.Subject
+}
+
+func mentionAuthorName(mention numbersix.Group) string {
+	if mfutil.Has(mention.Properties, "author.properties.name") {
+		return templateGet(mention.Properties, "author.properties.name")
+	}
+
+	if mfutil.Has(mention.Properties, "author.properties.url") {
+		return templateGet(mention.Properties, "author.properties.url")
+	}
+
+	return mention.Subject
+}
+
+func mentionAuthor(mention numbersix.Group) lmth.Node {
+	href := templateGet(mention.Properties, "author.properties.url")
+	if !htmlutil.IsWebURL(href) {
+		href = mentionURL(mention)
+	}
+
+	photo := templateGet(mention.Properties, "author.properties.photo")
+
+	return Div(lmth.Attr{"class": "p-author h-card"},
+		lmth.Toggle(htmlutil.IsWebURL(photo),
+			Img(lmth.Attr{"class": "u-photo", "src": photo, "alt": ""}),
+		),
+		A(lmth.Attr{"class": "p-name u-url", "href": href},
+			lmth.Text(mentionAuthorName(mention)),
+		),
+	)
+}
+
+func mentionContent(mention numbersix.Group) lmth.Node {
+	// content is sanitized when the mention is received
+	if mfutil.Has(mention.Properties, "content.html") {
+		return lmth.RawText(templateGet(mention.Properties, "content.html"))
+	}
+
+	return lmth.Text(templateGet(mention.Properties, "name"))
+}
+
+// replyParent finds the URL of the reply that mention is in reply to, if it is
+// one of the known urls.
+func replyParent(mention numbersix.Group, urls map[string]bool) (string, bool) {
+	self := mentionURL(mention)
+
+	for _, value := range mention.Properties["in-reply-to"] {
+		u, ok := mfutil.Get(value, "properties.url", "value", "").(string)
+		if ok && u != self && urls[u] {
+			return u, true
+		}
+	}
+
+	return "", false
+}
@@ -92,9 +92,9 @@ func pageHead(conf BlogData, title string, nodes ...lmth.Node) lmth.Node {
 		Link(lmth.Attr{"rel": "token_endpoint", "href": conf.TokenURL.String()}),
 		Link(lmth.Attr{"rel": "micropub", "href": "/-/micropub"}),
 		Link(lmth.Attr{"rel": "webmention", "href": "/-/webmention"}),
-		Link(lmth.Attr{"rel": "alternative", "href": "/feed/atom", "type": "application/atom+xml"}),
-		Link(lmth.Attr{"rel": "alternative", "href": "/feed/jsonfee", "type": "application/json"}),
-		Link(lmth.Attr{"rel": "alternative", "href": "/feed/rss", "type": "application/rss+xml"}),
+		Link(lmth.Attr{"rel": "alternate", "href": "/feed/atom", "type": "application/atom+xml"}),
+		Link(lmth.Attr{"rel": "alternate", "href": "/feed/jsonfeed", "type": "application/feed+json"}),
+		Link(lmth.Attr{"rel": "alternate", "href": "/feed/rss", "type": "application/rss+xml"}),
 	}
 
 	return Head(lmth.Attr{}, slices.Concat(def, nodes)...)
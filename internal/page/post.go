@@ -2,7 +2,6 @@ package page
 
 import (
 	"fmt"
-	"log/slog"
 	"strings"
 	"time"
 
@@ -88,59 +87,7 @@ func Post(conf BlogData, data PostData) lmth.Node {
 							syndication(),
 							category(),
 						),
-						Details(lmth.Attr{"class": "meta"},
-							Summary(lmth.Attr{},
-								lmth.Text(fmt.Sprintf("Interactions (%d)", len(data.Mentions))),
-								Ol(lmth.Attr{"class": "inner"},
-									lmth.Map(func(mention numbersix.Group) lmth.Node {
-										name := "mentioned by "
-										if mfutil.Has(mention.Properties, "in-reply-to") {
-											name = "reply from "
-										} else if mfutil.Has(mention.Properties, "repost-of") {
-											name = "reposted by "
-										} else if mfutil.Has(mention.Properties, "like-of") {
-											name = "liked by "
-										}
-
-										subject := mention.Subject
-										if mfutil.Has(mention.Properties, "author") {
-											if mfutil.Has(mention.Properties, "author.properties.name") {
-												subject = templateGet(mention.Properties, "author.properties.name")
-											} else {
-												subject = templateGet(mention.Properties, "author.properties.url")
-											}
-										}
-										link := mention.Subject
-										if mfutil.Has(mention.Properties, "url") {
-											link = templateGet(mention.Properties, "url")
-										}
-
-										slog.Info("Link: ", "link", link)
-										replyContents := templateGet(mention.Properties, "name")
-										slog.Info("Reply contents", "replyContents", replyContents)
-										if replyContents == "" {
-											return Li(lmth.Attr{},
-												lmth.Text(name),
-												A(lmth.Attr{"href": link},
-													lmth.Text(subject),
-												),
-											)
-										} else {
-											return Li(lmth.Attr{},
-												lmth.Text(name),
-												A(lmth.Attr{"href": link},
-													lmth.Text(subject),
-												),
-												Br(lmth.Attr{}),
-												Span(lmth.Attr{"class": "span"},
-													lmth.Text(replyContents)),
-											)
-
-										}
-									}, data.Mentions),
-								),
-							),
-						),
+						interactions(data.Mentions),
 					),
 				),
 			),
@@ -1,9 +1,12 @@
 package page
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"hawx.me/code/numbersix"
 )
 
 func TestSyndicationurl(t *testing.T) {
@@ -13,3 +16,62 @@ func TestSyndicationurl(t *testing.T) {
 	assert.NotEqual(t, atProtoUrl, httpsUrl)
 	assert.Equal(t, "https://bsky.app/profile/rosshendry.com/post/3lrjay3eyla2q", httpsUrl)
 }
+
+func TestInteractionsThreadsReplies(t *testing.T) {
+	assert := assert.New(t)
+
+	mentions := []numbersix.Group{
+		{Subject: "https://a.example.com/1", Properties: map[string][]any{
+			"in-reply-to": {"http://example.com/post"},
+			"author":      {map[string]any{"properties": map[string]any{"name": []any{"Alice"}, "photo": []any{"https://a.example.com/me.jpg"}}}},
+			"content":     {map[string]any{"html": "<em>first</em>"}},
+		}},
+		{Subject: "https://b.example.com/2", Properties: map[string][]any{
+			"in-reply-to": {"https://a.example.com/1", "http://example.com/post"},
+			"author":      {map[string]any{"properties": map[string]any{"name": []any{"Bob"}}}},
+			"content":     {map[string]any{"html": "second"}},
+		}},
+		{Subject: "https://c.example.com/3", Properties: map[string][]any{
+			"like-of": {"http://example.com/post"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	_, err := interactions(mentions).WriteTo(&buf)
+	assert.Nil(err)
+
+	out := buf.String()
+	assert.Contains(out, "Interactions (3)")
+	assert.Contains(out, "https://a.example.com/me.jpg")
+	assert.Contains(out, "<em>first</em>")
+	assert.Contains(out, "liked by ")
+
+	// Bob's reply is nested in a list within Alice's
+	assert.Equal(2, strings.Count(out, "replies"))
+	assert.Less(strings.Index(out, "Alice"), strings.LastIndex(out, "replies"))
+	assert.Less(strings.LastIndex(out, "replies"), strings.Index(out, "Bob"))
+}
+
+func TestInteractionsDropUnsafeURLs(t *testing.T) {
+	assert := assert.New(t)
+
+	mentions := []numbersix.Group{
+		{Subject: "https://a.example.com/1", Properties: map[string][]any{
+			"in-reply-to": {"http://example.com/post"},
+			"url":         {"javascript:alert(1)"},
+			"author": {map[string]any{"properties": map[string]any{
+				"name":  []any{"Mallory"},
+				"url":   []any{"javascript:alert(2)"},
+				"photo": []any{"javascript:alert(3)"},
+			}}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	_, err := interactions(mentions).WriteTo(&buf)
+	assert.Nil(err)
+
+	out := buf.String()
+	assert.NotContains(out, "javascript:")
+	assert.Contains(out, "https://a.example.com/1")
+}
@@ -8,13 +8,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	// register sqlite3 for database/sql
 	_ "github.com/mattn/go-sqlite3"
 
 	"hawx.me/code/serve"
+	"hawx.me/code/tally-ho/admin"
 	"hawx.me/code/tally-ho/auth"
 	"hawx.me/code/tally-ho/blog"
+	"hawx.me/code/tally-ho/internal/fetch"
 	"hawx.me/code/tally-ho/media"
 	"hawx.me/code/tally-ho/micropub"
 	"hawx.me/code/tally-ho/silos"
@@ -45,6 +48,27 @@ type config struct {
 	AuthEndpoint     string
 	TokenEndpoint    string
 	BypassValidation bool
+	// AllowPrivate permits requests to private addresses, such as localhost,
+	// which is useful when developing locally.
+	AllowPrivate bool
+	// HubURL is an external WebSub hub to notify of changes.
+	HubURL string
+	// EmbeddedHub runs a WebSub hub at /-/hub.
+	EmbeddedHub bool
+	// HubSignature is the algorithm the embedded hub signs content with, when
+	// the subscriber has not chosen one.
+	HubSignature string
+	// AuthPasswordHash and AuthTOTPSecret are the credentials for logging in to
+	// the built-in IndieAuth server, which is used when either is set.
+	AuthPasswordHash string
+	AuthTOTPSecret   string
+	// AuthDiscoveryTTL and AuthTokenTTL control how long the endpoints
+	// advertised by Me, and the tokens verified with them, are remembered.
+	AuthDiscoveryTTL time.Duration
+	AuthTokenTTL     time.Duration
+	// AuthIntrospectionToken authorizes requests to the introspection endpoint
+	// advertised by Me, if it requires it.
+	AuthIntrospectionToken string
 
 	Flickr, Twitter struct {
 		ConsumerKey       string
@@ -167,16 +191,100 @@ func main() {
 		logger.Info("Not configuring Bluesky syndicator")
 	}
 
+	client := fetch.New(conf.AllowPrivate)
+	if conf.AllowPrivate {
+		logger.Warn("allowing requests to private addresses")
+	}
+
 	hubStore, err := blog.NewHubStore(db)
 	if err != nil {
 		logger.Error("problem initialising hub store", slog.Any("err", err))
 		return
 	}
 
+	mentionQueue, err := blog.NewMentionQueue(db)
+	if err != nil {
+		logger.Error("problem initialising mention queue", slog.Any("err", err))
+		return
+	}
+
+	outboxStore, err := blog.NewOutboxStore(db)
+	if err != nil {
+		logger.Error("problem initialising outbox", slog.Any("err", err))
+		return
+	}
+	outbox := webmention.NewOutbox(outboxStore, webmention.NewSender(client, conf.AllowPrivate))
+
 	mediaEndpointURL, _ := url.Parse("/-/media")
 	hubEndpointURL, _ := url.Parse("/-/hub")
 
-	websubhub := websub.New(baseURL.ResolveReference(hubEndpointURL).String(), hubStore)
+	var (
+		hubURLs       []string
+		hubPublishers blog.HubPublishers
+		websubhub     *websub.Hub
+	)
+
+	if conf.EmbeddedHub {
+		websubhub = websub.New(baseURL.ResolveReference(hubEndpointURL).String(), hubStore, client)
+		websubhub.RestrictTopics(baseURL.ResolveReference(&url.URL{Path: "/"}).String())
+		if conf.HubSignature != "" {
+			if err := websubhub.SignWith(conf.HubSignature); err != nil {
+				logger.Error("hub signature algorithm invalid", slog.Any("err", err))
+				return
+			}
+		}
+
+		hubURLs = append(hubURLs, baseURL.ResolveReference(hubEndpointURL).String())
+		hubPublishers = append(hubPublishers, websubhub)
+	}
+
+	if conf.HubURL != "" {
+		hubURLs = append(hubURLs, conf.HubURL)
+		hubPublishers = append(hubPublishers, websub.NewPing(conf.HubURL, client))
+	}
+
+	verifier := auth.TokenEndpoint(conf.Me, auth.EndpointOptions{
+		Client:             client,
+		DiscoveryTTL:       conf.AuthDiscoveryTTL,
+		TokenTTL:           conf.AuthTokenTTL,
+		IntrospectionToken: conf.AuthIntrospectionToken,
+	})
+	var authServer *auth.Server
+
+	if conf.AuthPasswordHash != "" || conf.AuthTOTPSecret != "" {
+		authStore, err := blog.NewAuthStore(db)
+		if err != nil {
+			logger.Error("problem initialising auth store", slog.Any("err", err))
+			return
+		}
+
+		authServer, err = auth.NewServer(authStore, auth.ServerOptions{
+			Me:           conf.Me,
+			Issuer:       baseURL.String(),
+			PasswordHash: conf.AuthPasswordHash,
+			TOTPSecret:   conf.AuthTOTPSecret,
+		})
+		if err != nil {
+			logger.Error("problem initialising auth server", slog.Any("err", err))
+			return
+		}
+
+		verifier = authServer
+		conf.AuthEndpoint = baseURL.ResolveReference(&url.URL{Path: "/-/auth"}).String()
+		conf.TokenEndpoint = baseURL.ResolveReference(&url.URL{Path: "/-/token"}).String()
+		logger.Info("using built-in indieauth server")
+	}
+
+	appTokenStore, err := blog.NewAppTokenStore(db)
+	if err != nil {
+		logger.Error("problem initialising app token store", slog.Any("err", err))
+		return
+	}
+	appTokens := auth.NewAppTokens(appTokenStore, conf.Me)
+
+	// app tokens can only be used for posting, the admin endpoints continue to
+	// require a token issued with IndieAuth
+	postingVerifier := auth.Verifiers{appTokens, verifier}
 
 	authURL, _ := url.Parse(conf.AuthEndpoint)
 	tokenURL, _ := url.Parse(conf.TokenEndpoint)
@@ -192,8 +300,11 @@ func main() {
 		AuthURL:     authURL,
 		TokenURL:    tokenURL,
 		MediaDir:    conf.MediaDir,
-		HubURL:      baseURL.ResolveReference(hubEndpointURL).String(),
-	}, db, websubhub, blogSilos)
+		HubURLs:     hubURLs,
+		IsOwner: func(r *http.Request) bool {
+			return auth.IsOwner(conf.Me, verifier, r)
+		},
+	}, db, client, hubPublishers, outbox, blogSilos)
 	if err != nil {
 		logger.Error("problem initialising blog", slog.Any("err", err))
 		return
@@ -213,14 +324,34 @@ func main() {
 	http.Handle("/-/micropub", micropub.Endpoint(
 		b,
 		conf.Me,
+		postingVerifier,
 		baseURL.ResolveReference(mediaEndpointURL).String(),
 		micropubSyndicateTo,
 		fw,
 		conf.BypassValidation,
 	))
-	http.Handle("/-/webmention", webmention.Endpoint(b))
-	http.Handle("/-/media", auth.Only(conf.Me, media.Endpoint(fw, auth.HasScope)))
-	http.Handle("/-/hub", websubhub)
+	http.Handle("/-/webmention", webmention.Endpoint(b, mentionQueue, client))
+	http.Handle("/-/webmention/status/",
+		http.StripPrefix("/-/webmention/status/", webmention.Status(mentionQueue)),
+	)
+	http.Handle("/-/media", auth.OnlyWith(conf.Me, postingVerifier, media.Endpoint(fw, auth.HasScope)))
+	if websubhub != nil {
+		http.Handle("/-/hub", websubhub)
+	}
+	if authServer != nil {
+		http.Handle("/-/auth", authServer.Authorization())
+		http.Handle("/-/token", authServer.Token())
+	}
+	// the admin endpoints need a token issued with the admin scope, any other
+	// token for me must not be able to, say, create app tokens
+	onlyAdmin := func(next http.Handler) http.Handler {
+		return auth.OnlyWith(conf.Me, verifier, auth.Require(auth.Admin, next))
+	}
+	http.Handle("/-/admin/mentions", onlyAdmin(admin.Mentions(b)))
+	http.Handle("/-/admin/outbox", onlyAdmin(admin.Outbox(outbox)))
+	http.Handle("/-/admin/subscriptions", onlyAdmin(admin.Subscriptions(hubStore)))
+	http.Handle("/-/admin/tokens", onlyAdmin(admin.Tokens(appTokens)))
+	http.Handle("/-/admin/contacts", onlyAdmin(admin.Contacts(b)))
 
 	serve.Serve(conf.Port, conf.Socket, http.DefaultServeMux)
 }
@@ -11,6 +11,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"sync"
+
+	"hawx.me/code/tally-ho/internal/apierror"
 )
 
 type FileWriter interface {
@@ -66,7 +68,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
 	if r.FormValue("q") != "last" {
-		http.Error(w, "", http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("q must be last"))
 		return
 	}
 
@@ -92,11 +94,12 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		h.logger.Error("parsing media type", slog.Any("err", err))
+		apierror.Write(w, apierror.InvalidRequest("could not parse content-type"))
 		return
 	}
 	if mediaType != "multipart/form-data" {
 		h.logger.Error("bad mediaType")
-		http.Error(w, "expected content-type of multipart/form-data", http.StatusUnsupportedMediaType)
+		apierror.Write(w, apierror.New(http.StatusUnsupportedMediaType, "invalid_request", "expected content-type of multipart/form-data"))
 		return
 	}
 
@@ -105,26 +108,26 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 	part, err := parts.NextPart()
 	if err == io.EOF {
 		h.logger.Error("empty form")
-		http.Error(w, "expected multipart form to contain a part", http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("expected multipart form to contain a part"))
 		return
 	}
 	if err != nil {
 		h.logger.Error("next part", slog.Any("err", err))
-		http.Error(w, "problem reading multipart form", http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("problem reading multipart form"))
 		return
 	}
 
 	mt, ps, er := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
 	if er != nil || mt != "form-data" || ps["name"] != "file" {
 		h.logger.Error("expected only single part")
-		http.Error(w, "request must only contain a part named 'file'", http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("request must only contain a part named 'file'"))
 		return
 	}
 
 	location, err := h.fw.WriteFile(ps["filename"], part.Header.Get("Content-Type"), part)
 	if err != nil {
 		h.logger.Error("write file", slog.Any("err", err))
-		http.Error(w, "problem writing media to file", http.StatusInternalServerError)
+		apierror.Write(w, apierror.ServerError())
 		return
 	}
 
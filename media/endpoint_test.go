@@ -127,6 +127,12 @@ func TestMediaWhenNoFilePart(t *testing.T) {
 	resp := w.Result()
 
 	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	assert.Equal("application/json", resp.Header.Get("Content-Type"))
+
+	var v map[string]string
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal("invalid_request", v["error"])
+	assert.Equal("expected multipart form to contain a part", v["error_description"])
 }
 
 func TestMediaWhenMultipleFileParts(t *testing.T) {
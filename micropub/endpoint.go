@@ -4,6 +4,7 @@
 package micropub
 
 import (
+	"errors"
 	"net/http"
 
 	"hawx.me/code/mux"
@@ -11,8 +12,15 @@ import (
 	"hawx.me/code/tally-ho/media"
 )
 
+// ErrNotFound is returned, possibly wrapped, by a DB when there is no entry for
+// a url.
+var ErrNotFound = errors.New("entry not found")
+
 type DB interface {
 	Entry(url string) (data map[string][]interface{}, err error)
+	Source(query SourceQuery) ([]map[string][]interface{}, error)
+	Categories(filter string) ([]string, error)
+	Contacts(filter string) ([]Contact, error)
 	Create(data map[string][]interface{}) (string, error)
 	Update(url string, replace, add, delete map[string][]interface{}, deleteAlls []string) error
 	Delete(url string) error
@@ -20,16 +28,18 @@ type DB interface {
 }
 
 // Endpoint returns a http.Handler exposing micropub. Only tokens issued for
-// 'me' are allowed access to post or retrieve configuration.
+// 'me', as checked by verifier, are allowed access to post or retrieve
+// configuration.
 func Endpoint(
 	db DB,
 	me string,
+	verifier auth.Verifier,
 	mediaUploadURL string,
 	syndicateTo []SyndicateTo,
 	fw media.FileWriter,
 	bypassValidation bool,
 ) http.Handler {
-	return auth.Only(me, mux.Method{
+	return auth.OnlyWith(me, verifier, mux.Method{
 		"POST": postHandler(db, fw),
 		"GET":  getHandler(db, mediaUploadURL, syndicateTo),
 	})
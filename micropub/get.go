@@ -3,10 +3,19 @@ package micropub
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hawx.me/code/tally-ho/auth"
+	"hawx.me/code/tally-ho/internal/apierror"
 )
 
 type getDB interface {
 	Entry(url string) (data map[string][]interface{}, err error)
+	Source(query SourceQuery) ([]map[string][]interface{}, error)
+	Categories(filter string) ([]string, error)
+	Contacts(filter string) ([]Contact, error)
 }
 
 func getHandler(
@@ -18,18 +27,33 @@ func getHandler(
 	sourceHandler := sourceHandler(db)
 	syndicationHandler := syndicationHandler(syndicateTo)
 	mediaEndpointHandler := mediaEndpointHandler(mediaURL)
+	categoryHandler := categoryHandler(db)
+	contactHandler := contactHandler(db)
+
+	handlers := map[string]struct {
+		op      auth.Operation
+		handler http.Handler
+	}{
+		"category":       {auth.QueryCategory, categoryHandler},
+		"config":         {auth.QueryConfig, configHandler},
+		"contact":        {auth.QueryContact, contactHandler},
+		"media-endpoint": {auth.QueryMediaEndpoint, mediaEndpointHandler},
+		"source":         {auth.QuerySource, sourceHandler},
+		"syndicate-to":   {auth.QuerySyndicateTo, syndicationHandler},
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.FormValue("q") {
-		case "config":
-			configHandler.ServeHTTP(w, r)
-		case "media-endpoint":
-			mediaEndpointHandler.ServeHTTP(w, r)
-		case "source":
-			sourceHandler.ServeHTTP(w, r)
-		case "syndicate-to":
-			syndicationHandler.ServeHTTP(w, r)
+		query, ok := handlers[r.FormValue("q")]
+		if !ok {
+			apierror.Write(w, apierror.InvalidRequest("unknown q: "+r.FormValue("q")))
+			return
 		}
+
+		if !auth.Allow(w, r, query.op) {
+			return
+		}
+
+		query.handler.ServeHTTP(w, r)
 	}
 }
 
@@ -38,6 +62,29 @@ type SyndicateTo struct {
 	Name string `json:"name"`
 }
 
+// PostType is a kind of entry that q=source can be filtered by, see
+// https://github.com/indieweb/micropub-extensions/issues/1.
+type PostType struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// postTypes are the kinds given to entries when they are created.
+var postTypes = []PostType{
+	{"note", "Note"},
+	{"article", "Article"},
+	{"photo", "Photo"},
+	{"video", "Video"},
+	{"reply", "Reply"},
+	{"repost", "Repost"},
+	{"like", "Like"},
+	{"bookmark", "Bookmark"},
+	{"rsvp", "RSVP"},
+	{"checkin", "Checkin"},
+	{"read", "Read"},
+	{"drank", "Drank"},
+}
+
 func configHandler(mediaURL string, syndicateTo []SyndicateTo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -45,19 +92,38 @@ func configHandler(mediaURL string, syndicateTo []SyndicateTo) http.HandlerFunc
 			Q             []string      `json:"q"`
 			MediaEndpoint string        `json:"media-endpoint"`
 			SyndicateTo   []SyndicateTo `json:"syndicate-to"`
+			PostTypes     []PostType    `json:"post-types"`
+			Source        sourceConfig  `json:"source"`
 		}{
 			Q: []string{
+				"category",
 				"config",
+				"contact",
 				"media-endpoint",
 				"source",
 				"syndicate-to",
 			},
 			MediaEndpoint: mediaURL,
 			SyndicateTo:   syndicateTo,
+			PostTypes:     postTypes,
+			Source: sourceConfig{
+				Filters:      []string{"post-type", "category"},
+				Paging:       []string{"limit", "offset", "after"},
+				DefaultLimit: defaultSourceLimit,
+				MaxLimit:     maxSourceLimit,
+			},
 		})
 	}
 }
 
+// sourceConfig describes how q=source can list entries, when no url is given.
+type sourceConfig struct {
+	Filters      []string `json:"filters"`
+	Paging       []string `json:"paging"`
+	DefaultLimit int      `json:"default-limit"`
+	MaxLimit     int      `json:"max-limit"`
+}
+
 func mediaEndpointHandler(mediaURL string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -69,9 +135,37 @@ func mediaEndpointHandler(mediaURL string) http.HandlerFunc {
 	}
 }
 
+const (
+	// defaultSourceLimit is the number of entries listed by q=source when no
+	// limit is given.
+	defaultSourceLimit = 20
+
+	// maxSourceLimit is the most entries that q=source will list at once.
+	maxSourceLimit = 100
+
+	// maxSourceOffset is the most entries that q=source will skip with offset,
+	// older entries can be listed by paging with after.
+	maxSourceOffset = 1000
+)
+
+// SourceQuery filters the entries listed by q=source.
+type SourceQuery struct {
+	// PostType only lists entries of the kind, for instance "note" or "like".
+	PostType string
+	// Category only lists entries in the category.
+	Category string
+	// Before only lists entries published before the time.
+	Before time.Time
+	// BeforeUID, if given, also lists the entries published at Before that have
+	// a uid less than it. Entries are listed newest first, and those published
+	// at the same time by uid, greatest first, so that this can continue a list.
+	BeforeUID string
+	// Limit is the most entries to list.
+	Limit int
+}
+
 func sourceHandler(db getDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		url := r.FormValue("url")
 		properties := r.Form["properties[]"]
 		if len(properties) == 0 {
 			property := r.FormValue("properties")
@@ -80,22 +174,173 @@ func sourceHandler(db getDB) http.HandlerFunc {
 			}
 		}
 
+		url := r.FormValue("url")
+		if url == "" {
+			listSource(w, r, db, properties)
+			return
+		}
+
 		obj, err := db.Entry(url)
 		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
+			writeDBError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(formToJSON(onlyProperties(obj, properties)))
+	}
+}
+
+// listSource responds with the most recent entries, see
+// https://indieweb.org/Micropub-extensions#Query_for_Post_List.
+func listSource(w http.ResponseWriter, r *http.Request, db getDB, properties []string) {
+	limit := defaultSourceLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apierror.Write(w, apierror.InvalidRequest("limit must be a positive number"))
 			return
 		}
+		limit = min(n, maxSourceLimit)
+	}
 
-		if len(properties) > 0 {
-			for key := range obj {
-				if !contains(key, properties) {
-					delete(obj, key)
-				}
+	offset := 0
+	if v := r.FormValue("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			apierror.Write(w, apierror.InvalidRequest("offset must not be negative"))
+			return
+		}
+		if n > maxSourceOffset {
+			apierror.Write(w, apierror.InvalidRequest("offset must be at most "+strconv.Itoa(maxSourceOffset)+", use after to list older entries"))
+			return
+		}
+		offset = n
+	}
+
+	// the cursor is the published time and uid of the last entry listed, as
+	// entries can be published at the same time
+	before, beforeUID := time.Now().UTC(), ""
+	if v := r.FormValue("after"); v != "" {
+		published, uid, _ := strings.Cut(v, ",")
+		t, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			apierror.Write(w, apierror.InvalidRequest("after must be a cursor returned in paging"))
+			return
+		}
+		before, beforeUID = t, uid
+	}
+
+	entries, err := db.Source(SourceQuery{
+		PostType:  r.FormValue("post-type"),
+		Category:  r.FormValue("category"),
+		Before:    before,
+		BeforeUID: beforeUID,
+		Limit:     offset + limit,
+	})
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	// the cursor is taken before removing the properties that were not asked
+	// for, as published may be one of them
+	var after string
+	if len(entries) == offset+limit {
+		last := entries[len(entries)-1]
+		after, _ = last["published"][0].(string)
+		if len(last["uid"]) > 0 {
+			if uid, ok := last["uid"][0].(string); ok {
+				after += "," + uid
+			}
+		}
+	}
+
+	items := []jsonMicroformat{}
+	if offset < len(entries) {
+		for _, entry := range entries[offset:] {
+			items = append(items, formToJSON(onlyProperties(entry, properties)))
+		}
+	}
+
+	type paging struct {
+		After string `json:"after"`
+	}
+
+	v := struct {
+		Items  []jsonMicroformat `json:"items"`
+		Paging *paging           `json:"paging,omitempty"`
+	}{
+		Items: items,
+	}
+	if after != "" {
+		v.Paging = &paging{After: after}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// onlyProperties removes the properties of obj that are not listed, unless none
+// are listed.
+func onlyProperties(obj map[string][]any, properties []string) map[string][]any {
+	if len(properties) > 0 {
+		for key := range obj {
+			if !contains(key, properties) {
+				delete(obj, key)
 			}
 		}
+	}
+
+	return obj
+}
+
+// categoryHandler responds with the categories that have been used, see
+// https://github.com/indieweb/micropub-extensions/issues/5.
+func categoryHandler(db getDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categories, err := db.Categories(r.FormValue("filter"))
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(formToJSON(obj))
+		json.NewEncoder(w).Encode(struct {
+			Categories []string `json:"categories"`
+		}{
+			Categories: categories,
+		})
+	}
+}
+
+// Contact is a person that can be mentioned in an entry.
+type Contact struct {
+	Name     string `json:"name,omitempty"`
+	Nickname string `json:"nickname,omitempty"`
+	URL      string `json:"url"`
+	Photo    string `json:"photo,omitempty"`
+	// Silos maps the name of a silo, like "github", to the username of the
+	// contact on it.
+	Silos map[string]string `json:"silos,omitempty"`
+}
+
+// contactHandler responds with the people that are known, see
+// https://github.com/indieweb/micropub-extensions/issues/32.
+func contactHandler(db getDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contacts, err := db.Contacts(r.FormValue("filter"))
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Contacts []Contact `json:"contacts"`
+		}{
+			Contacts: contacts,
+		})
 	}
 }
 
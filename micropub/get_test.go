@@ -2,16 +2,24 @@ package micropub
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type fakeGetDB struct {
-	entries map[string]map[string][]interface{}
+	entries    map[string]map[string][]interface{}
+	queries    []SourceQuery
+	categories []string
+	contacts   []Contact
+	filters    []string
 }
 
 func (b *fakeGetDB) Entry(url string) (map[string][]interface{}, error) {
@@ -19,7 +27,67 @@ func (b *fakeGetDB) Entry(url string) (map[string][]interface{}, error) {
 		return entry, nil
 	}
 
-	return nil, errors.New("nope")
+	return nil, ErrNotFound
+}
+
+func (b *fakeGetDB) Source(query SourceQuery) ([]map[string][]interface{}, error) {
+	b.queries = append(b.queries, query)
+
+	var entries []map[string][]interface{}
+	for _, entry := range b.entries {
+		published, _ := time.Parse(time.RFC3339, entry["published"][0].(string))
+		uid, _ := entry["uid"][0].(string)
+		if !published.Before(query.Before) && !(published.Equal(query.Before) && uid < query.BeforeUID) {
+			continue
+		}
+		if query.PostType != "" && entry["hx-kind"][0] != query.PostType {
+			continue
+		}
+		if query.Category != "" && !slices.Contains(entry["category"], any(query.Category)) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b map[string][]interface{}) int {
+		if c := strings.Compare(b["published"][0].(string), a["published"][0].(string)); c != 0 {
+			return c
+		}
+		return strings.Compare(b["uid"][0].(string), a["uid"][0].(string))
+	})
+
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	return entries, nil
+}
+
+func (b *fakeGetDB) Categories(filter string) ([]string, error) {
+	b.filters = append(b.filters, filter)
+
+	categories := []string{}
+	for _, category := range b.categories {
+		if strings.HasPrefix(category, filter) {
+			categories = append(categories, category)
+		}
+	}
+
+	return categories, nil
+}
+
+func (b *fakeGetDB) Contacts(filter string) ([]Contact, error) {
+	b.filters = append(b.filters, filter)
+
+	contacts := []Contact{}
+	for _, contact := range b.contacts {
+		if strings.HasPrefix(contact.Name, filter) {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	return contacts, nil
 }
 
 func fakeSyndicators() []SyndicateTo {
@@ -50,12 +118,25 @@ func TestConfigurationConfig(t *testing.T) {
 			UID  string `json:"uid"`
 			Name string `json:"name"`
 		} `json:"syndicate-to"`
+		PostTypes []struct {
+			Type string `json:"type"`
+		} `json:"post-types"`
+		Source struct {
+			Filters []string `json:"filters"`
+			Paging  []string `json:"paging"`
+		} `json:"source"`
 	}
 	json.NewDecoder(resp.Body).Decode(&v)
 
 	assert.Equal("http://media.example.com/", v.MediaEndpoint)
 
-	assert.Equal([]string{"config", "media-endpoint", "source", "syndicate-to"}, v.Q)
+	if assert.NotEmpty(v.PostTypes) {
+		assert.Equal("note", v.PostTypes[0].Type)
+	}
+	assert.Equal([]string{"post-type", "category"}, v.Source.Filters)
+	assert.Equal([]string{"limit", "offset", "after"}, v.Source.Paging)
+
+	assert.Equal([]string{"category", "config", "contact", "media-endpoint", "source", "syndicate-to"}, v.Q)
 
 	if assert.Len(v.SyndicateTo, 1) {
 		assert.Equal("https://fake/", v.SyndicateTo[0].UID)
@@ -75,7 +156,7 @@ func TestConfigurationSource(t *testing.T) {
 		},
 	}
 
-	handler := getHandler(blog, "", fakeSyndicators())
+	handler := withScope("update", getHandler(blog, "", fakeSyndicators()))
 
 	req := httptest.NewRequest("GET", "http://localhost/?q=source&url=https://example.com/weblog/p/1", nil)
 
@@ -109,7 +190,7 @@ func TestConfigurationSourceWithProperties(t *testing.T) {
 		},
 	}
 
-	handler := getHandler(blog, "", fakeSyndicators())
+	handler := withScope("update", getHandler(blog, "", fakeSyndicators()))
 
 	req := httptest.NewRequest("GET", "http://localhost/?q=source&properties=title&url=https://example.com/weblog/p/1", nil)
 
@@ -145,7 +226,7 @@ func TestConfigurationSourceWithManyProperties(t *testing.T) {
 		},
 	}
 
-	handler := getHandler(blog, "", fakeSyndicators())
+	handler := withScope("update", getHandler(blog, "", fakeSyndicators()))
 
 	req := httptest.NewRequest("GET", "http://localhost/?q=source&properties[]=title&properties[]=categories&url=https://example.com/weblog/p/1", nil)
 
@@ -221,3 +302,310 @@ func TestConfigurationMediaEndpoint(t *testing.T) {
 
 	assert.Equal("http://media.example.com/", v.MediaEndpoint)
 }
+
+func TestQueryScopes(t *testing.T) {
+	blog := &fakeGetDB{
+		entries: map[string]map[string][]interface{}{
+			"https://example.com/weblog/p/1": {"h": {"entry"}},
+		},
+	}
+
+	testCases := []struct {
+		query  string
+		scope  string
+		status int
+	}{
+		{"category", "create", http.StatusOK},
+		{"config", "create", http.StatusOK},
+		{"contact", "create", http.StatusOK},
+		{"media-endpoint", "media", http.StatusOK},
+		{"syndicate-to", "create", http.StatusOK},
+		{"source", "update", http.StatusOK},
+		{"source", "create", http.StatusUnauthorized},
+		{"source", "delete", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query+"/"+tc.scope, func(t *testing.T) {
+			assert := assert.New(t)
+
+			handler := withScope(tc.scope, getHandler(blog, "http://media.example.com/", fakeSyndicators()))
+
+			req := httptest.NewRequest("GET", "http://localhost/?q="+tc.query+"&url=https://example.com/weblog/p/1", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			assert.Equal(tc.status, resp.StatusCode)
+
+			if tc.status == http.StatusUnauthorized {
+				var v struct {
+					Error string `json:"error"`
+					Scope string `json:"scope"`
+				}
+				assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+				assert.Equal("insufficient_scope", v.Error)
+				assert.Equal("update", v.Scope)
+			}
+		})
+	}
+}
+
+func TestConfigurationSourceNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeGetDB{entries: map[string]map[string][]interface{}{}}
+
+	handler := withScope("update", getHandler(blog, "", fakeSyndicators()))
+
+	req := httptest.NewRequest("GET", "http://localhost/?q=source&url=https://example.com/weblog/p/404", nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	var v map[string]string
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal("invalid_request", v["error"])
+}
+
+func sourceListDB() *fakeGetDB {
+	entry := func(n int, kind string, categories ...any) map[string][]interface{} {
+		return map[string][]interface{}{
+			"h":         {"entry"},
+			"uid":       {strconv.Itoa(n)},
+			"url":       {fmt.Sprintf("https://example.com/weblog/p/%d", n)},
+			"published": {time.Date(2024, time.March, n, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)},
+			"hx-kind":   {kind},
+			"category":  categories,
+		}
+	}
+
+	return &fakeGetDB{
+		entries: map[string]map[string][]interface{}{
+			"1": entry(1, "note", "go"),
+			"2": entry(2, "like"),
+			"3": entry(3, "note", "go", "cats"),
+			"4": entry(4, "article", "cats"),
+			"5": entry(5, "note"),
+		},
+	}
+}
+
+type sourceList struct {
+	Items []struct {
+		Type       []string
+		Properties map[string][]interface{}
+	}
+	Paging *struct {
+		After string
+	}
+}
+
+func (l sourceList) urls() []any {
+	var urls []any
+	for _, item := range l.Items {
+		urls = append(urls, item.Properties["url"][0])
+	}
+	return urls
+}
+
+func getSourceList(t *testing.T, db *fakeGetDB, query string) sourceList {
+	handler := withScope("update", getHandler(db, "", fakeSyndicators()))
+
+	req := httptest.NewRequest("GET", "http://localhost/?q=source"+query, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var v sourceList
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&v))
+	return v
+}
+
+func TestConfigurationSourceList(t *testing.T) {
+	assert := assert.New(t)
+
+	v := getSourceList(t, sourceListDB(), "")
+
+	assert.Equal([]any{
+		"https://example.com/weblog/p/5",
+		"https://example.com/weblog/p/4",
+		"https://example.com/weblog/p/3",
+		"https://example.com/weblog/p/2",
+		"https://example.com/weblog/p/1",
+	}, v.urls())
+	assert.Equal([]string{"h-entry"}, v.Items[0].Type)
+	assert.Nil(v.Paging)
+}
+
+func TestConfigurationSourceListPaging(t *testing.T) {
+	assert := assert.New(t)
+
+	db := sourceListDB()
+
+	v := getSourceList(t, db, "&limit=2")
+	assert.Equal([]any{
+		"https://example.com/weblog/p/5",
+		"https://example.com/weblog/p/4",
+	}, v.urls())
+	if assert.NotNil(v.Paging) {
+		assert.Equal("2024-03-04T12:00:00Z,4", v.Paging.After)
+	}
+
+	v = getSourceList(t, db, "&limit=2&after="+v.Paging.After)
+	assert.Equal([]any{
+		"https://example.com/weblog/p/3",
+		"https://example.com/weblog/p/2",
+	}, v.urls())
+
+	v = getSourceList(t, db, "&limit=2&after="+v.Paging.After)
+	assert.Equal([]any{
+		"https://example.com/weblog/p/1",
+	}, v.urls())
+	assert.Nil(v.Paging)
+
+	v = getSourceList(t, db, "&limit=2&offset=3")
+	assert.Equal([]any{
+		"https://example.com/weblog/p/2",
+		"https://example.com/weblog/p/1",
+	}, v.urls())
+
+	v = getSourceList(t, db, "&offset=10")
+	assert.Len(v.Items, 0)
+
+	getSourceList(t, db, "&limit=1000")
+	assert.Equal(maxSourceLimit, db.queries[len(db.queries)-1].Limit)
+}
+
+func TestConfigurationSourceListPagingPublishedTogether(t *testing.T) {
+	assert := assert.New(t)
+
+	db := sourceListDB()
+	for _, uid := range []string{"6", "7", "8"} {
+		db.entries[uid] = map[string][]interface{}{
+			"h":         {"entry"},
+			"uid":       {uid},
+			"url":       {"https://example.com/weblog/p/" + uid},
+			"published": {"2024-03-06T12:00:00Z"},
+			"hx-kind":   {"note"},
+		}
+	}
+
+	var urls []any
+	v := getSourceList(t, db, "&limit=2")
+	for v.Paging != nil {
+		urls = append(urls, v.urls()...)
+		v = getSourceList(t, db, "&limit=2&after="+v.Paging.After)
+	}
+	urls = append(urls, v.urls()...)
+
+	assert.Equal([]any{
+		"https://example.com/weblog/p/8",
+		"https://example.com/weblog/p/7",
+		"https://example.com/weblog/p/6",
+		"https://example.com/weblog/p/5",
+		"https://example.com/weblog/p/4",
+		"https://example.com/weblog/p/3",
+		"https://example.com/weblog/p/2",
+		"https://example.com/weblog/p/1",
+	}, urls)
+	assert.Equal("7", db.queries[1].BeforeUID)
+}
+
+func TestConfigurationSourceListFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	db := sourceListDB()
+
+	v := getSourceList(t, db, "&post-type=note")
+	assert.Equal([]any{
+		"https://example.com/weblog/p/5",
+		"https://example.com/weblog/p/3",
+		"https://example.com/weblog/p/1",
+	}, v.urls())
+
+	v = getSourceList(t, db, "&category=cats")
+	assert.Equal([]any{
+		"https://example.com/weblog/p/4",
+		"https://example.com/weblog/p/3",
+	}, v.urls())
+
+	v = getSourceList(t, db, "&post-type=note&category=go&properties=url")
+	assert.Equal([]any{
+		"https://example.com/weblog/p/3",
+		"https://example.com/weblog/p/1",
+	}, v.urls())
+	assert.Len(v.Items[0].Properties, 1)
+}
+
+func TestConfigurationSourceListInvalid(t *testing.T) {
+	for _, query := range []string{"&limit=0", "&limit=many", "&offset=-1", "&offset=1001", "&after=yesterday"} {
+		t.Run(query, func(t *testing.T) {
+			handler := withScope("update", getHandler(sourceListDB(), "", fakeSyndicators()))
+
+			req := httptest.NewRequest("GET", "http://localhost/?q=source"+query, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestConfigurationCategory(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &fakeGetDB{categories: []string{"cats", "code", "go"}}
+	handler := getHandler(db, "", fakeSyndicators())
+
+	req := httptest.NewRequest("GET", "http://localhost/?q=category&filter=c", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("application/json", resp.Header.Get("Content-Type"))
+	assert.Equal([]string{"c"}, db.filters)
+
+	var v struct {
+		Categories []string `json:"categories"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal([]string{"cats", "code"}, v.Categories)
+}
+
+func TestConfigurationContact(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &fakeGetDB{contacts: []Contact{
+		{Name: "Jane", Nickname: "jane", URL: "https://jane.example.com/", Silos: map[string]string{"github": "jane"}},
+		{Name: "John", URL: "https://john.example.com/"},
+		{URL: "https://anon.example.com/"},
+	}}
+	handler := getHandler(db, "", fakeSyndicators())
+
+	req := httptest.NewRequest("GET", "http://localhost/?q=contact&filter=Ja", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal([]string{"Ja"}, db.filters)
+
+	var v struct {
+		Contacts []map[string]any `json:"contacts"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+	assert.Equal([]map[string]any{{
+		"name":     "Jane",
+		"nickname": "jane",
+		"url":      "https://jane.example.com/",
+		"silos":    map[string]any{"github": "jane"},
+	}}, v.Contacts)
+}
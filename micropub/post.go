@@ -2,6 +2,7 @@ package micropub
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"mime"
@@ -11,6 +12,7 @@ import (
 
 	"hawx.me/code/mux"
 	"hawx.me/code/tally-ho/auth"
+	"hawx.me/code/tally-ho/internal/apierror"
 	"hawx.me/code/tally-ho/media"
 )
 
@@ -39,139 +41,169 @@ type micropubPostHandler struct {
 	fw media.FileWriter
 }
 
-func (h *micropubPostHandler) handleJSON(w http.ResponseWriter, r *http.Request) {
-	v := jsonMicroformat{Properties: map[string][]any{}}
+// request is a micropub request, read from any of the supported content types.
+type request struct {
+	action string
+	url    string
+	// data is the properties of an entry to create.
+	data map[string][]any
+	// replace, add, delete and deleteAlls are the changes to make to an entry
+	// for an update.
+	replace, add, delete map[string][]any
+	deleteAlls           []string
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
-		http.Error(w, "could not decode json request: "+err.Error(), http.StatusBadRequest)
+func newRequest() *request {
+	return &request{
+		data:    map[string][]any{},
+		replace: map[string][]any{},
+		add:     map[string][]any{},
+		delete:  map[string][]any{},
+	}
+}
+
+// set records the value of a form field. Fields ending "[]" can be given
+// multiple times to make a list. Updates use the fields "replace[property]",
+// "add[property]" and "delete[property]" to change values, and "delete[]" to
+// remove a property entirely.
+func (req *request) set(key string, value string) {
+	switch key {
+	case "action":
+		req.action = value
+		return
+	case "url":
+		req.url = value
 		return
 	}
 
-	data := jsonToForm(v)
+	if value == "" || reservedKey(key) {
+		return
+	}
 
-	if v.Action == "update" {
-		replace := map[string][]any{}
-		for key, value := range v.Replace {
-			if reservedKey(key) {
-				continue
-			}
+	name, multiple := strings.CutSuffix(key, "[]")
+	if name == "delete" && multiple {
+		req.deleteAlls = append(req.deleteAlls, value)
+		return
+	}
 
-			replace[key] = value
+	values := req.data
+	if op, property, ok := updateKey(name); ok {
+		if reservedKey(property) {
+			return
 		}
 
-		add := map[string][]any{}
-		for key, value := range v.Add {
-			if reservedKey(key) {
-				continue
-			}
-
-			add[key] = value
+		name = property
+		switch op {
+		case "replace":
+			values = req.replace
+		case "add":
+			values = req.add
+		case "delete":
+			values = req.delete
 		}
+	}
 
-		delete := map[string][]any{}
-		var deleteAlls []string
-
-		if ds, ok := v.Delete.([]any); ok {
-			for _, d := range ds {
-				if dd, ok := d.(string); ok {
-					deleteAlls = append(deleteAlls, dd)
-				} else {
-					http.Error(w, "could not decode json request: malformed delete", http.StatusBadRequest)
-					return
-				}
-			}
-		} else if dm, ok := v.Delete.(map[string]any); ok {
-			for key, value := range dm {
-				if reservedKey(key) {
-					continue
-				}
-
-				if vs, ok := value.([]any); ok {
-					delete[key] = vs
-				} else {
-					http.Error(w, "could not decode json request: malformed delete", http.StatusBadRequest)
-					return
-				}
+	if multiple {
+		values[name] = append(values[name], value)
+	} else {
+		values[name] = []any{value}
+	}
+}
+
+// updateKey splits a form field like "replace[content]" into the operation and
+// the property it changes.
+func updateKey(key string) (op, property string, ok bool) {
+	for _, op := range []string{"replace", "add", "delete"} {
+		if rest, ok := strings.CutPrefix(key, op+"["); ok {
+			if property, ok := strings.CutSuffix(rest, "]"); ok && property != "" && !strings.ContainsAny(property, "[]") {
+				return op, property, true
 			}
 		}
+	}
 
-		if !auth.HasScope(w, r, "update") {
-			return
-		}
+	return "", "", false
+}
 
-		if err := h.db.Update(v.URL, replace, add, delete, deleteAlls); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+func (h *micropubPostHandler) handleJSON(w http.ResponseWriter, r *http.Request) {
+	v := jsonMicroformat{Properties: map[string][]any{}}
 
-		w.WriteHeader(http.StatusNoContent)
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		apierror.Write(w, apierror.InvalidRequest("could not decode json request: "+err.Error()))
 		return
 	}
 
-	if v.Action == "delete" {
-		h.delete(w, r, v.URL)
-		return
+	req := newRequest()
+	req.action = v.Action
+	req.url = v.URL
+	req.data = jsonToForm(v)
+
+	for key, value := range v.Replace {
+		if !reservedKey(key) {
+			req.replace[key] = value
+		}
 	}
 
-	if v.Action == "undelete" {
-		h.undelete(w, r, v.URL)
-		return
+	for key, value := range v.Add {
+		if !reservedKey(key) {
+			req.add[key] = value
+		}
 	}
 
-	h.create(w, r, data)
+	if ds, ok := v.Delete.([]any); ok {
+		for _, d := range ds {
+			if dd, ok := d.(string); ok {
+				req.deleteAlls = append(req.deleteAlls, dd)
+			} else {
+				apierror.Write(w, apierror.InvalidRequest("could not decode json request: malformed delete"))
+				return
+			}
+		}
+	} else if dm, ok := v.Delete.(map[string]any); ok {
+		for key, value := range dm {
+			if reservedKey(key) {
+				continue
+			}
+
+			if vs, ok := value.([]any); ok {
+				req.delete[key] = vs
+			} else {
+				apierror.Write(w, apierror.InvalidRequest("could not decode json request: malformed delete"))
+				return
+			}
+		}
+	}
+
+	h.dispatch(w, r, req)
 }
 
 func (h *micropubPostHandler) handleForm(w http.ResponseWriter, r *http.Request) {
-	data := map[string][]any{}
-
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "could not parse form: "+err.Error(), http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("could not parse form: "+err.Error()))
 		return
 	}
-	for key, values := range r.Form {
-		if reservedKey(key) {
-			continue
-		}
 
+	req := newRequest()
+	for key, values := range r.Form {
 		if strings.HasSuffix(key, "[]") {
-			key := key[:len(key)-2]
 			for _, value := range values {
-				if value != "" {
-					data[key] = append(data[key], value)
-				}
+				req.set(key, value)
 			}
 		} else {
-			if values[0] != "" {
-				data[key] = []any{values[0]}
-			}
+			req.set(key, values[0])
 		}
 	}
 
-	if r.FormValue("action") == "delete" {
-		h.delete(w, r, r.FormValue("url"))
-		return
-	}
-
-	if r.FormValue("action") == "undelete" {
-		h.undelete(w, r, r.FormValue("url"))
-		return
-	}
-
-	h.create(w, r, data)
+	h.dispatch(w, r, req)
 }
 
 func (h *micropubPostHandler) handleMultiPart(w http.ResponseWriter, r *http.Request) {
-	if !auth.HasScope(w, r, "create") {
-		return
-	}
-
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
-		slog.Error("micropub parse content type", slog.Any("err", err))
+		apierror.Write(w, apierror.InvalidRequest("could not parse content-type: "+err.Error()))
 		return
 	}
 
-	data := map[string][]any{}
+	req := newRequest()
 	parts := multipart.NewReader(r.Body, params["boundary"])
 
 	for {
@@ -181,7 +213,7 @@ func (h *micropubPostHandler) handleMultiPart(w http.ResponseWriter, r *http.Req
 		}
 		if err != nil {
 			slog.Error("micropub read multi part", slog.Any("err", err))
-			http.Error(w, "", http.StatusInternalServerError)
+			apierror.Write(w, apierror.InvalidRequest("could not read multipart form"))
 			return
 		}
 
@@ -192,56 +224,69 @@ func (h *micropubPostHandler) handleMultiPart(w http.ResponseWriter, r *http.Req
 
 		key := p.FormName()
 
-		switch key {
-		case "photo", "video", "audio":
-			location, err := h.fw.WriteFile(ps["filename"], p.Header.Get("Content-Type"), p)
-			if err != nil {
-				slog.Error("micropub photo", slog.Any("err", err))
-				continue
+		if filename := ps["filename"]; filename != "" && mediaKey(key) {
+			// the action may not have been read yet, so check the scope needed
+			// for the field before writing any files
+			op := auth.Create
+			if _, _, ok := updateKey(strings.TrimSuffix(key, "[]")); ok {
+				op = auth.Update
+			}
+			if !auth.Allow(w, r, op) {
+				return
 			}
 
-			data[key] = []any{location}
-
-		case "photo[]", "video[]", "audio[]":
-			location, err := h.fw.WriteFile(ps["filename"], p.Header.Get("Content-Type"), p)
+			location, err := h.fw.WriteFile(filename, p.Header.Get("Content-Type"), p)
 			if err != nil {
-				slog.Error("micropub-photo", slog.Any("err", err))
+				slog.Error("micropub write file", slog.String("key", key), slog.Any("err", err))
 				continue
 			}
 
-			key := key[:len(key)-2]
-			data[key] = append(data[key], location)
+			req.set(key, location)
+			continue
+		}
 
-		default:
-			if reservedKey(key) {
-				continue
-			}
+		slurp, err := io.ReadAll(p)
+		if err != nil {
+			slog.Error("could not read", slog.Any("err", err))
+			apierror.Write(w, apierror.InvalidRequest("could not read multipart form"))
+			return
+		}
 
-			slurp, err := io.ReadAll(p)
-			if err != nil {
-				slog.Error("could not read", slog.Any("err", err))
-				return
-			}
+		req.set(key, string(slurp))
+	}
 
-			value := string(slurp)
-			if value == "" {
-				continue
-			}
+	h.dispatch(w, r, req)
+}
 
-			if strings.HasSuffix(key, "[]") {
-				key := key[:len(key)-2]
-				data[key] = append(data[key], value)
-			} else {
-				data[key] = []any{value}
-			}
-		}
+// mediaKey checks whether a form field is for a photo, video or audio
+// property, which can be uploaded as a file in a multipart request.
+func mediaKey(key string) bool {
+	name := strings.TrimSuffix(key, "[]")
+	if _, property, ok := updateKey(name); ok {
+		name = property
 	}
 
-	h.create(w, r, data)
+	return name == "photo" || name == "video" || name == "audio"
+}
+
+// dispatch performs the action requested, once the request body has been read.
+func (h *micropubPostHandler) dispatch(w http.ResponseWriter, r *http.Request, req *request) {
+	switch req.action {
+	case "", "create":
+		h.create(w, r, req.data)
+	case "update":
+		h.update(w, r, req)
+	case "delete":
+		h.delete(w, r, req.url)
+	case "undelete":
+		h.undelete(w, r, req.url)
+	default:
+		apierror.Write(w, apierror.InvalidRequest("unknown action: "+req.action))
+	}
 }
 
 func (h *micropubPostHandler) create(w http.ResponseWriter, r *http.Request, data map[string][]any) {
-	if !auth.HasScope(w, r, "create") {
+	if !auth.Allow(w, r, auth.Create) {
 		return
 	}
 
@@ -251,7 +296,7 @@ func (h *micropubPostHandler) create(w http.ResponseWriter, r *http.Request, dat
 
 	location, err := h.db.Create(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDBError(w, err)
 		return
 	}
 
@@ -259,13 +304,26 @@ func (h *micropubPostHandler) create(w http.ResponseWriter, r *http.Request, dat
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (h *micropubPostHandler) update(w http.ResponseWriter, r *http.Request, req *request) {
+	if !auth.Allow(w, r, auth.Update) {
+		return
+	}
+
+	if err := h.db.Update(req.url, req.replace, req.add, req.delete, req.deleteAlls); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *micropubPostHandler) delete(w http.ResponseWriter, r *http.Request, url string) {
-	if !auth.HasScope(w, r, "delete") {
+	if !auth.Allow(w, r, auth.Delete) {
 		return
 	}
 
 	if err := h.db.Delete(url); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDBError(w, err)
 		return
 	}
 
@@ -273,18 +331,29 @@ func (h *micropubPostHandler) delete(w http.ResponseWriter, r *http.Request, url
 }
 
 func (h *micropubPostHandler) undelete(w http.ResponseWriter, r *http.Request, url string) {
-	if !auth.HasScope(w, r, "delete") {
+	if !auth.Allow(w, r, auth.Undelete) {
 		return
 	}
 
 	if err := h.db.Undelete(url); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDBError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// writeDBError responds with an invalid_request error if the entry did not
+// exist, otherwise the error is logged and a server_error is returned.
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, apierror.InvalidRequest(err.Error()))
+		return
+	}
+
+	apierror.Write(w, err)
+}
+
 func reservedKey(key string) bool {
 	return key == "access_token" || key == "action" || key == "url"
 }
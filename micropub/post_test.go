@@ -3,6 +3,9 @@ package micropub
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
@@ -524,6 +527,137 @@ func TestUpdateEntryInvalidDelete(t *testing.T) {
 	}
 }
 
+func TestUpdateEntryForm(t *testing.T) {
+	fields := url.Values{
+		"action":                {"update"},
+		"url":                   {"https://example.com/blog/p/100"},
+		"replace[content]":      {"hello moon"},
+		"add[category][]":       {"space", "moon"},
+		"delete[syndication][]": {"http://somewhere.com"},
+		"delete[]":              {"not-important"},
+		"replace[access_token]": {"abcde"},
+		"replace[mp-syndicate]": {""},
+		"add[syndication]":      {"http://elsewhere.com"},
+		"replace[name][nested]": {"ignored"},
+		"access_token":          {"abcde"},
+	}
+
+	testCases := map[string]*http.Request{
+		"url-encoded-form": newFormRequest(fields),
+		"multipart-form":   newMultipartRequest(fields, nil),
+	}
+
+	for name, req := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			db := &fakePostDB{
+				adds:       map[string][]map[string][]interface{}{},
+				deletes:    map[string][]map[string][]interface{}{},
+				replaces:   map[string][]map[string][]interface{}{},
+				deleteAlls: map[string][][]string{},
+			}
+
+			handler := withScope("update", postHandler(db, nil))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			assert.Equal(http.StatusNoContent, resp.StatusCode)
+			assert.Len(db.datas, 0)
+
+			const entryURL = "https://example.com/blog/p/100"
+
+			if assert.Len(db.replaces[entryURL], 1) {
+				assert.Equal(map[string][]interface{}{
+					"content": {"hello moon"},
+				}, db.replaces[entryURL][0])
+			}
+
+			if assert.Len(db.adds[entryURL], 1) {
+				assert.Equal(map[string][]interface{}{
+					"category":    {"space", "moon"},
+					"syndication": {"http://elsewhere.com"},
+				}, db.adds[entryURL][0])
+			}
+
+			if assert.Len(db.deletes[entryURL], 1) {
+				assert.Equal(map[string][]interface{}{
+					"syndication": {"http://somewhere.com"},
+				}, db.deletes[entryURL][0])
+			}
+
+			if assert.Len(db.deleteAlls[entryURL], 1) {
+				assert.Equal([]string{"not-important"}, db.deleteAlls[entryURL][0])
+			}
+		})
+	}
+}
+
+func TestUpdateEntryMultipartFormWithMedia(t *testing.T) {
+	assert := assert.New(t)
+	db := &fakePostDB{
+		adds:       map[string][]map[string][]interface{}{},
+		deletes:    map[string][]map[string][]interface{}{},
+		replaces:   map[string][]map[string][]interface{}{},
+		deleteAlls: map[string][][]string{},
+	}
+	fw := &fakeFileWriter{}
+
+	handler := withScope("update", postHandler(db, fw))
+
+	req := newMultipartRequest(url.Values{
+		"action": {"update"},
+		"url":    {"https://example.com/blog/p/100"},
+	}, []multipartFile{
+		{"replace[photo]", "new.jpg", "the new photo"},
+		{"add[video][]", "1.mp4", "a video"},
+		{"add[video][]", "2.mp4", "another video"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Len(db.datas, 0)
+	assert.Equal([]string{"the new photo", "a video", "another video"}, fw.data)
+
+	const entryURL = "https://example.com/blog/p/100"
+
+	if assert.Len(db.replaces[entryURL], 1) {
+		assert.Equal(map[string][]interface{}{
+			"photo": {"http://example.com/new.jpg"},
+		}, db.replaces[entryURL][0])
+	}
+
+	if assert.Len(db.adds[entryURL], 1) {
+		assert.Equal(map[string][]interface{}{
+			"video": {"http://example.com/1.mp4", "http://example.com/2.mp4"},
+		}, db.adds[entryURL][0])
+	}
+}
+
+func TestUpdateEntryMultipartFormWithMediaMissingScope(t *testing.T) {
+	assert := assert.New(t)
+	db := &fakePostDB{}
+	fw := &fakeFileWriter{}
+
+	handler := withScope("create", postHandler(db, fw))
+
+	req := newMultipartRequest(url.Values{
+		"action": {"update"},
+		"url":    {"https://example.com/blog/p/100"},
+	}, []multipartFile{{"replace[photo]", "new.jpg", "the new photo"}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	assert.Len(fw.data, 0)
+}
+
 func TestDeleteEntry(t *testing.T) {
 	testCases := map[string]*http.Request{
 		"url-encoded-form": newFormRequest(url.Values{
@@ -593,7 +727,7 @@ func TestUndeleteEntry(t *testing.T) {
 			assert := assert.New(t)
 			db := &fakePostDB{}
 
-			handler := withScope("delete", postHandler(db, nil))
+			handler := withScope("undelete", postHandler(db, nil))
 
 			w := httptest.NewRecorder()
 			handler.ServeHTTP(w, req)
@@ -633,3 +767,220 @@ func TestUndeleteEntryMissingScope(t *testing.T) {
 		})
 	}
 }
+
+func TestPostScopes(t *testing.T) {
+	const entryURL = "https://example.com/blog/p/1"
+
+	requests := map[string]map[string]func() *http.Request{
+		"create": {
+			"json": func() *http.Request {
+				return newJSONRequest(`{"type": ["h-entry"], "properties": {"content": ["hey"]}}`)
+			},
+			"url-encoded-form": func() *http.Request {
+				return newFormRequest(url.Values{"h": {"entry"}, "content": {"hey"}})
+			},
+			"multipart-form": func() *http.Request {
+				return newMultipartRequest(url.Values{"h": {"entry"}, "content": {"hey"}}, nil)
+			},
+			"multipart-form-with-photo": func() *http.Request {
+				return newMultipartRequest(url.Values{"h": {"entry"}}, []multipartFile{{"photo", "a.png", "image"}})
+			},
+		},
+		"update": {
+			"json": func() *http.Request {
+				return newJSONRequest(`{"action": "update", "url": "` + entryURL + `", "replace": {"content": ["hey"]}}`)
+			},
+			"url-encoded-form": func() *http.Request {
+				return newFormRequest(url.Values{"action": {"update"}, "url": {entryURL}, "replace[content]": {"hey"}})
+			},
+			"multipart-form": func() *http.Request {
+				return newMultipartRequest(url.Values{"action": {"update"}, "url": {entryURL}, "replace[content]": {"hey"}}, nil)
+			},
+			"multipart-form-with-photo": func() *http.Request {
+				return newMultipartRequest(url.Values{"action": {"update"}, "url": {entryURL}}, []multipartFile{{"replace[photo]", "a.png", "image"}})
+			},
+		},
+		"delete": {
+			"json": func() *http.Request {
+				return newJSONRequest(`{"action": "delete", "url": "` + entryURL + `"}`)
+			},
+			"url-encoded-form": func() *http.Request {
+				return newFormRequest(url.Values{"action": {"delete"}, "url": {entryURL}})
+			},
+			"multipart-form": func() *http.Request {
+				return newMultipartRequest(url.Values{"action": {"delete"}, "url": {entryURL}}, nil)
+			},
+		},
+		"undelete": {
+			"json": func() *http.Request {
+				return newJSONRequest(`{"action": "undelete", "url": "` + entryURL + `"}`)
+			},
+			"url-encoded-form": func() *http.Request {
+				return newFormRequest(url.Values{"action": {"undelete"}, "url": {entryURL}})
+			},
+			"multipart-form": func() *http.Request {
+				return newMultipartRequest(url.Values{"action": {"undelete"}, "url": {entryURL}}, nil)
+			},
+		},
+	}
+
+	for action, byType := range requests {
+		for contentType, newRequest := range byType {
+			for _, scope := range []string{"create", "update", "delete", "undelete", "media"} {
+				t.Run(action+"/"+contentType+"/"+scope, func(t *testing.T) {
+					assert := assert.New(t)
+					db := &fakePostDB{
+						replaces:   map[string][]map[string][]interface{}{},
+						adds:       map[string][]map[string][]interface{}{},
+						deletes:    map[string][]map[string][]interface{}{},
+						deleteAlls: map[string][][]string{},
+					}
+					fw := &fakeFileWriter{}
+
+					w := httptest.NewRecorder()
+					withScope(scope, postHandler(db, fw)).ServeHTTP(w, newRequest())
+					resp := w.Result()
+
+					if scope != action {
+						assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+						assert.Equal("application/json", resp.Header.Get("Content-Type"))
+
+						var v struct {
+							Error string `json:"error"`
+							Scope string `json:"scope"`
+						}
+						assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+						assert.Equal("insufficient_scope", v.Error)
+						assert.Equal(action, v.Scope)
+
+						assert.Len(db.datas, 0)
+						assert.Len(db.replaces, 0)
+						assert.Len(db.deleted, 0)
+						assert.Len(db.undeleted, 0)
+						assert.Len(fw.data, 0)
+						return
+					}
+
+					switch action {
+					case "create":
+						assert.Equal(http.StatusCreated, resp.StatusCode)
+						assert.Len(db.datas, 1)
+					case "update":
+						assert.Equal(http.StatusNoContent, resp.StatusCode)
+						assert.Len(db.replaces[entryURL], 1)
+					case "delete":
+						assert.Equal(http.StatusNoContent, resp.StatusCode)
+						assert.Equal([]string{entryURL}, db.deleted)
+					case "undelete":
+						assert.Equal(http.StatusNoContent, resp.StatusCode)
+						assert.Equal([]string{entryURL}, db.undeleted)
+					}
+				})
+			}
+		}
+	}
+}
+
+type errorPostDB struct {
+	err error
+}
+
+func (b *errorPostDB) Create(data map[string][]interface{}) (string, error) {
+	return "", b.err
+}
+
+func (b *errorPostDB) Update(url string, replace, add, delete map[string][]interface{}, deleteAlls []string) error {
+	return b.err
+}
+
+func (b *errorPostDB) Delete(url string) error {
+	return b.err
+}
+
+func (b *errorPostDB) Undelete(url string) error {
+	return b.err
+}
+
+func TestPostErrors(t *testing.T) {
+	const entryURL = "https://example.com/blog/p/404"
+	notFound := fmt.Errorf("%w: no data for url: %s", ErrNotFound, entryURL)
+
+	testCases := map[string]struct {
+		scope       string
+		err         error
+		req         func() *http.Request
+		status      int
+		code        string
+		description string
+	}{
+		"malformed json": {
+			scope:  "create",
+			req:    func() *http.Request { return newJSONRequest(`{"type":`) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		"unknown action": {
+			scope:       "create",
+			req:         func() *http.Request { return newJSONRequest(`{"action": "explode", "url": "` + entryURL + `"}`) },
+			status:      http.StatusBadRequest,
+			code:        "invalid_request",
+			description: "unknown action: explode",
+		},
+		"update missing entry": {
+			scope: "update",
+			err:   notFound,
+			req: func() *http.Request {
+				return newJSONRequest(`{"action": "update", "url": "` + entryURL + `", "replace": {"name": ["hey"]}}`)
+			},
+			status:      http.StatusBadRequest,
+			code:        "invalid_request",
+			description: notFound.Error(),
+		},
+		"delete missing entry": {
+			scope:       "delete",
+			err:         notFound,
+			req:         func() *http.Request { return newFormRequest(url.Values{"action": {"delete"}, "url": {entryURL}}) },
+			status:      http.StatusBadRequest,
+			code:        "invalid_request",
+			description: notFound.Error(),
+		},
+		"undelete missing entry": {
+			scope: "undelete",
+			err:   notFound,
+			req: func() *http.Request {
+				return newMultipartRequest(url.Values{"action": {"undelete"}, "url": {entryURL}}, nil)
+			},
+			status:      http.StatusBadRequest,
+			code:        "invalid_request",
+			description: notFound.Error(),
+		},
+		"create fails": {
+			scope:  "create",
+			err:    errors.New("disk full of secrets"),
+			req:    func() *http.Request { return newFormRequest(url.Values{"h": {"entry"}, "content": {"hey"}}) },
+			status: http.StatusInternalServerError,
+			code:   "server_error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			w := httptest.NewRecorder()
+			withScope(tc.scope, postHandler(&errorPostDB{err: tc.err}, nil)).ServeHTTP(w, tc.req())
+
+			resp := w.Result()
+			assert.Equal(tc.status, resp.StatusCode)
+			assert.Equal("application/json", resp.Header.Get("Content-Type"))
+
+			var v map[string]string
+			assert.Nil(json.NewDecoder(resp.Body).Decode(&v))
+			assert.Equal(tc.code, v["error"])
+			if tc.description != "" {
+				assert.Equal(tc.description, v["error_description"])
+			}
+			assert.NotContains(v["error_description"], "secrets")
+		})
+	}
+}
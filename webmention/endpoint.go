@@ -4,18 +4,45 @@
 package webmention
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"slices"
 	"strings"
 
+	"golang.org/x/net/html"
 	"hawx.me/code/mux"
+	"hawx.me/code/tally-ho/internal/fetch"
+	"hawx.me/code/tally-ho/internal/htmlutil"
 	"willnorris.com/go/microformats"
 )
 
+// maxSourceSize limits how much of a source document will be read when
+// verifying a webmention.
+const maxSourceSize = 1 << 20
+
+// maxContentLength limits the number of characters of a mention's content that
+// will be stored.
+const maxContentLength = 2000
+
+// ErrNoLink is returned when a source document does not link to the target of
+// the webmention.
+var ErrNoLink = errors.New("source does not link to target")
+
+// ErrBlocked should be returned by Blog.Mention when the source has been
+// blocked, it will not be retried.
+var ErrBlocked = errors.New("source is blocked")
+
+// ErrPending should be returned by Blog.Mention when the mention has been
+// stored, but is held until it is approved.
+var ErrPending = errors.New("mention is pending approval")
+
 type Blog interface {
 	Entry(url string) (data map[string][]interface{}, err error)
 	Mention(source string, data map[string][]interface{}) error
@@ -26,25 +53,23 @@ type webmention struct {
 	source, target string
 }
 
-// Endpoint receives webmentions, immediately returning a response of Accepted,
-// and processing them asynchronously.
-func Endpoint(blog Blog) http.Handler {
-	return mux.Method{"POST": postHandler(blog)}
+// Endpoint receives webmentions, immediately returning a response of Created,
+// and processing them asynchronously. Received webmentions are persisted in
+// queue, so any that have not been processed will be resumed when the Endpoint
+// is next created.
+//
+// The Location of the response is a status URL that can be requested to find
+// out the result of processing, see Status.
+func Endpoint(blog Blog, queue Queue, client *http.Client) http.Handler {
+	return mux.Method{"POST": postHandler(blog, queue, client)}
 }
 
-func postHandler(blog Blog) http.HandlerFunc {
-	mentions := make(chan webmention, 100)
+func postHandler(blog Blog, queue Queue, client *http.Client) http.HandlerFunc {
+	wake := make(chan struct{}, 1)
 	baseURL := blog.BaseURL()
+	statusURL, _ := url.Parse(baseURL)
 
-	go func() {
-		for mention := range mentions {
-			slog.Info("received webmention", slog.String("target", mention.target), slog.String("source", mention.source))
-
-			if err := processMention(mention, blog); err != nil {
-				slog.Error("error processing mention", slog.Any("err", err))
-			}
-		}
-	}()
+	startWorkers(blog, queue, client, wake)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -64,13 +89,26 @@ func postHandler(blog Blog) http.HandlerFunc {
 			return
 		}
 
-		slog.Info("webmention queued", slog.String("source", source), slog.String("target", target))
-		mentions <- webmention{source: source, target: target}
-		w.WriteHeader(http.StatusAccepted)
+		id, err := queue.Enqueue(source, target)
+		if err != nil {
+			slog.Error("enqueue webmention", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("webmention queued", slog.String("id", id), slog.String("source", source), slog.String("target", target))
+
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+
+		w.Header().Set("Location", statusURL.ResolveReference(&url.URL{Path: statusPath + id}).String())
+		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func processMention(mention webmention, blog Blog) error {
+func processMention(mention webmention, blog Blog, client *http.Client) error {
 	_, err := blog.Entry(mention.target)
 	if err != nil {
 		return errors.New("no such post at 'target'")
@@ -81,46 +119,65 @@ func processMention(mention webmention, blog Blog) error {
 		return errors.New("could not parse 'source'")
 	}
 
-	// use a http client with a shortish timeout or this could block
-	resp, err := http.Get(mention.source)
+	resp, err := client.Get(mention.source)
 	if err != nil {
-		return errors.New("could not retrieve 'source'")
+		if errors.Is(err, fetch.ErrPrivateAddress) {
+			return errors.New("'source' is not a public address")
+		}
+		return temporary(errors.New("could not retrieve 'source'"))
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		return temporary(fmt.Errorf("retrieving 'source' returned %d", resp.StatusCode))
+	}
+
 	if resp.StatusCode == http.StatusGone {
 		if err := blog.Mention(mention.source, map[string][]interface{}{
 			"hx-target": {mention.target},
 			"hx-gone":   {true},
 		}); err != nil {
-			return fmt.Errorf("could not tombstone webmention: %w", err)
+			return mentionError("could not tombstone webmention", err)
 		}
 
 		return nil
 	}
 
-	data := microformats.Parse(resp.Body, source)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return temporary(errors.New("could not read 'source'"))
+	}
 
-	properties := map[string][]interface{}{}
-	for _, item := range data.Items {
-		if slices.Contains(item.Type, "h-entry") {
-			properties = item.Properties
-			break
+	properties, linked := verifySource(resp.Header.Get("Content-Type"), body, source, mention.target)
+	if !linked {
+		if err := blog.Mention(mention.source, map[string][]interface{}{
+			"hx-target": {mention.target},
+			"hx-gone":   {true},
+		}); err != nil {
+			return mentionError("could not tombstone webmention", err)
 		}
+
+		return ErrNoLink
 	}
+
 	if authors, ok := properties["author"]; ok {
 		if author, ok := authors[0].(*microformats.Microformat); ok {
 			a := make(map[string]interface{}, 1)
 			props := make(map[string]interface{}, 1)
 			props["name"] = author.Value
-			props["url"] = author.Properties["url"]
+			props["url"] = webURLs(author.Properties["url"])
 
-			photo := author.Properties["photo"]
-			switch v := photo[0].(type) {
-			case string:
-				props["photo"] = []interface{}{v}
-			case map[string]string:
-				props["photo"] = []interface{}{v["value"]}
+			if photo := author.Properties["photo"]; len(photo) > 0 {
+				var src string
+				switch v := photo[0].(type) {
+				case string:
+					src = v
+				case map[string]string:
+					src = v["value"]
+				}
+				if htmlutil.IsWebURL(src) {
+					props["photo"] = []interface{}{src}
+				}
 			}
 			props["nickname"] = author.Properties["nickname"]
 
@@ -131,13 +188,170 @@ func processMention(mention webmention, blog Blog) error {
 			properties["author"] = []interface{}{a}
 		}
 	}
-	delete(properties, "content")
+	if urls := webURLs(properties["url"]); len(urls) > 0 {
+		properties["url"] = urls
+	} else {
+		delete(properties, "url")
+	}
+
+	if content, ok := sanitizeContent(properties["content"]); ok {
+		properties["content"] = []interface{}{content}
+	} else {
+		delete(properties, "content")
+	}
 
 	properties["hx-target"] = []interface{}{mention.target}
 
 	if err := blog.Mention(mention.source, properties); err != nil {
-		return fmt.Errorf("could not add webmention: %w", err)
+		return mentionError("could not add webmention", err)
 	}
 
 	return nil
 }
+
+// webURLs returns the values that are http or https URLs, as the others could
+// be unsafe to link to.
+func webURLs(values []interface{}) []interface{} {
+	var urls []interface{}
+	for _, value := range values {
+		if s, ok := value.(string); ok && htmlutil.IsWebURL(s) {
+			urls = append(urls, s)
+		}
+	}
+
+	return urls
+}
+
+// sanitizeContent returns the content of a mention with any unsafe HTML
+// removed. Content without any html is escaped.
+func sanitizeContent(content []interface{}) (map[string]interface{}, bool) {
+	if len(content) == 0 {
+		return nil, false
+	}
+
+	var s string
+	switch v := content[0].(type) {
+	case string:
+		s = html.EscapeString(v)
+	case map[string]string:
+		if s = v["html"]; s == "" {
+			s = html.EscapeString(v["value"])
+		}
+	}
+
+	s = htmlutil.Sanitize(s, maxContentLength)
+	if s == "" {
+		return nil, false
+	}
+
+	return map[string]interface{}{"html": s}, true
+}
+
+// mentionError wraps an error returned by Blog.Mention, it is temporary unless
+// the source has been blocked or the mention is pending.
+func mentionError(msg string, err error) error {
+	if errors.Is(err, ErrBlocked) || errors.Is(err, ErrPending) {
+		return err
+	}
+
+	return temporary(fmt.Errorf("%s: %w", msg, err))
+}
+
+// verifySource checks that the body of the source document contains a link to
+// target, following the rules described in
+// https://www.w3.org/TR/webmention/#webmention-verification. The properties of
+// the first h-entry found are also returned.
+func verifySource(contentType string, body []byte, source *url.URL, target string) (map[string][]interface{}, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return map[string][]interface{}{}, false
+		}
+
+		return map[string][]interface{}{}, jsonLinksTo(v, target)
+	}
+
+	// plain text only needs to contain the target, but servers are often lazy
+	// with their content-types so still look for microformats
+	linked := mediaType == "text/plain" && bytes.Contains(body, []byte(target))
+
+	root, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return map[string][]interface{}{}, false
+	}
+
+	linked = linked || len(htmlutil.SearchAll(root, func(node *html.Node) bool {
+		if node.Type != html.ElementNode {
+			return false
+		}
+
+		for _, attr := range []string{"href", "src"} {
+			if htmlutil.Has(node, attr) && resolvesTo(source, htmlutil.Attr(node, attr), target) {
+				return true
+			}
+		}
+
+		return false
+	})) > 0
+
+	data := microformats.ParseNode(root, source)
+
+	properties := map[string][]interface{}{}
+	for _, item := range data.Items {
+		if slices.Contains(item.Type, "h-entry") {
+			properties = item.Properties
+			break
+		}
+	}
+
+	return properties, linked || propertiesLinkTo(properties, target)
+}
+
+func resolvesTo(base *url.URL, ref, target string) bool {
+	refURL, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return false
+	}
+
+	return base.ResolveReference(refURL).String() == target
+}
+
+func propertiesLinkTo(properties map[string][]interface{}, target string) bool {
+	for _, key := range []string{"in-reply-to", "like-of", "repost-of"} {
+		for _, value := range properties[key] {
+			switch v := value.(type) {
+			case string:
+				if v == target {
+					return true
+				}
+			case *microformats.Microformat:
+				if v.Value == target || slices.Contains(v.Properties["url"], interface{}(target)) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func jsonLinksTo(value interface{}, target string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == target
+	case []interface{}:
+		return slices.ContainsFunc(v, func(item interface{}) bool {
+			return jsonLinksTo(item, target)
+		})
+	case map[string]interface{}:
+		for _, item := range v {
+			if jsonLinksTo(item, target) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
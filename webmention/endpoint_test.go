@@ -5,15 +5,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/internal/fetch"
 )
 
 const waitTime = 5 * time.Millisecond
 
+// testClient allows requests to the servers started by httptest.
+var testClient = fetch.New(true)
+
 type mention struct {
 	source string
 	data   map[string][]interface{}
@@ -40,6 +46,77 @@ func (b *fakeBlog) Mention(source string, data map[string][]interface{}) error {
 	return nil
 }
 
+type fakeQueue struct {
+	mu      sync.Mutex
+	jobs    []Job
+	retries []Job
+}
+
+func (q *fakeQueue) Enqueue(source, target string) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := strconv.Itoa(len(q.jobs))
+	q.jobs = append(q.jobs, Job{ID: id, Source: source, Target: target, Status: StatusQueued})
+	return id, nil
+}
+
+func (q *fakeQueue) Claim(now time.Time) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.jobs {
+		if job.Status == StatusQueued {
+			q.jobs[i].Status = StatusProcessing
+			q.jobs[i].Attempts++
+			return q.jobs[i], true, nil
+		}
+	}
+
+	return Job{}, false, nil
+}
+
+func (q *fakeQueue) Finish(id, status, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, _ := strconv.Atoi(id)
+	q.jobs[i].Status = status
+	q.jobs[i].Reason = reason
+	return nil
+}
+
+func (q *fakeQueue) Retry(id string, at time.Time, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, _ := strconv.Atoi(id)
+	q.jobs[i].Reason = reason
+	q.retries = append(q.retries, q.jobs[i])
+	return nil
+}
+
+func (q *fakeQueue) Requeue() error {
+	return nil
+}
+
+func (q *fakeQueue) Job(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, err := strconv.Atoi(id)
+	if err != nil || i >= len(q.jobs) {
+		return Job{}, ErrJobNotFound
+	}
+
+	return q.jobs[i], nil
+}
+
+func (q *fakeQueue) job(id string) Job {
+	job, _ := q.Job(id)
+	return job
+}
+
 func stringHandler(s string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(s))
@@ -87,7 +164,7 @@ func TestMention(t *testing.T) {
 `))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -98,7 +175,7 @@ func TestMention(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -136,7 +213,7 @@ func TestMentionWhenPostUpdated(t *testing.T) {
 `)))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -147,7 +224,7 @@ func TestMentionWhenPostUpdated(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -171,7 +248,7 @@ func TestMentionWhenPostUpdated(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp = w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -206,7 +283,7 @@ func TestMentionWithHCardAndHEntry(t *testing.T) {
 `))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -217,7 +294,7 @@ func TestMentionWithHCardAndHEntry(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -260,7 +337,7 @@ func TestLikeFromBlueskyAndBridgy(t *testing.T) {
 `))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -271,7 +348,7 @@ func TestLikeFromBlueskyAndBridgy(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -331,7 +408,7 @@ func TestMentionFromBlueskyAndBridgy(t *testing.T) {
 `))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -342,7 +419,7 @@ func TestMentionFromBlueskyAndBridgy(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -350,6 +427,7 @@ func TestMentionFromBlueskyAndBridgy(t *testing.T) {
 
 		assert.Equal(map[string][]interface{}{
 			"name":        {"Another reply test"},
+			"content":     {map[string]interface{}{"html": "Another reply test"}},
 			"in-reply-to": {"at://did:plc:2n2izph6uhty5uhdx7l32p67/app.bsky.feed.post/3lrjabiusqo2g", "https://bsky.app/profile/did:plc:2n2izph6uhty5uhdx7l32p67/post/3lrjabiusqo2g", "http://example.com/weblog/post-id"},
 			"hx-target":   {"http://example.com/weblog/post-id"},
 			"url":         {"https://bsky.app/profile/rosshendry.com/post/3lrl2lv4x2222"},
@@ -370,6 +448,152 @@ func TestMentionFromBlueskyAndBridgy(t *testing.T) {
 	}
 }
 
+func TestMentionWithAuthorWithoutPhoto(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`<div class="h-entry">
+  <a class="p-author h-card" href="https://jane.example.com/">Jane</a>
+  <a class="u-like-of" href="http://example.com/weblog/post-id">a post</a>
+</div>`))
+	defer source.Close()
+
+	queue := &fakeQueue{}
+	handler := Endpoint(blog, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {source.URL},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusCreated, w.Code)
+
+	select {
+	case m := <-blog.ch:
+		assert.Equal([]interface{}{map[string]interface{}{
+			"name": "Jane",
+			"properties": map[string]interface{}{
+				"name":     "Jane",
+				"url":      []interface{}{"https://jane.example.com/"},
+				"nickname": []interface{}(nil),
+			},
+		}}, m.data["author"])
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+
+	assert.Eventually(func() bool {
+		return queue.job("0").Status == StatusVerified
+	}, waitTime, time.Millisecond)
+}
+
+func TestMentionDropsUnsafeURLs(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`<div class="h-entry">
+  <div class="p-author h-card">
+    <a class="p-name u-url" href="javascript:alert(1)">Mallory</a>
+    <a class="u-url" href="https://mallory.example.com/">home</a>
+    <img class="u-photo" src="javascript:alert(2)" />
+  </div>
+  <a class="u-url" href="javascript:alert(3)">permalink</a>
+  <a class="u-in-reply-to" href="http://example.com/weblog/post-id">a post</a>
+</div>`))
+	defer source.Close()
+
+	processed := make(chan error, 1)
+
+	go func() {
+		processed <- processMention(webmention{
+			source: source.URL,
+			target: "http://example.com/weblog/post-id",
+		}, blog, testClient)
+	}()
+
+	select {
+	case m := <-blog.ch:
+		assert.NotContains(m.data, "url")
+
+		props := m.data["author"][0].(map[string]interface{})["properties"].(map[string]interface{})
+		assert.Equal([]interface{}{"https://mallory.example.com/"}, props["url"])
+		assert.NotContains(props, "photo")
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+
+	assert.Nil(<-processed)
+}
+
+type panicBlog struct {
+	fakeBlog
+}
+
+func (b *panicBlog) Entry(url string) (map[string][]interface{}, error) {
+	panic("oh no")
+}
+
+func TestMentionFailsWhenProcessingPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	queue := &fakeQueue{}
+	handler := Endpoint(&panicBlog{}, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {"http://source.example.com/"},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusCreated, w.Code)
+
+	assert.Eventually(func() bool {
+		return queue.job("0").Status == StatusFailed
+	}, waitTime, time.Millisecond)
+
+	assert.Equal("panic processing webmention: oh no", queue.job("0").Reason)
+}
+
+type pendingBlog struct {
+	fakeBlog
+}
+
+func (b *pendingBlog) Mention(source string, data map[string][]interface{}) error {
+	return ErrPending
+}
+
+func TestMentionIsPending(t *testing.T) {
+	assert := assert.New(t)
+
+	queue := &fakeQueue{}
+
+	source := httptest.NewServer(stringHandler(`<p>Just a link to <a href="http://example.com/weblog/post-id">this post</a>.</p>`))
+	defer source.Close()
+
+	handler := Endpoint(&pendingBlog{}, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {source.URL},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusCreated, w.Code)
+
+	assert.Eventually(func() bool {
+		return queue.job("0").Status == StatusPending
+	}, waitTime, time.Millisecond)
+
+	assert.Equal("", queue.job("0").Reason)
+	assert.Empty(queue.retries)
+}
+
 func TestMentionWithoutMicroformats(t *testing.T) {
 	assert := assert.New(t)
 
@@ -382,7 +606,7 @@ func TestMentionWithoutMicroformats(t *testing.T) {
 `))
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -393,7 +617,7 @@ func TestMentionWithoutMicroformats(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -415,7 +639,7 @@ func TestMentionOfDeletedPost(t *testing.T) {
 	source := httptest.NewServer(goneHandler())
 	defer source.Close()
 
-	handler := Endpoint(blog)
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
 
 	req := newFormRequest(url.Values{
 		"source": {source.URL},
@@ -426,7 +650,7 @@ func TestMentionOfDeletedPost(t *testing.T) {
 	handler.ServeHTTP(w, req)
 
 	resp := w.Result()
-	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
 
 	select {
 	case m := <-blog.ch:
@@ -440,3 +664,338 @@ func TestMentionOfDeletedPost(t *testing.T) {
 		t.Fatal("failed to get notified")
 	}
 }
+
+func TestMentionWhenSourceDoesNotLinkToTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`
+<div class="h-entry">
+  <h1 class="p-name">A reply to some post</h1>
+  <p>
+    In <a class="u-in-reply-to" href="http://example.com/weblog/other-post-id">this post</a>, I disagree.
+  </p>
+</div>
+`))
+	defer source.Close()
+
+	handler := Endpoint(blog, &fakeQueue{}, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {source.URL},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	select {
+	case m := <-blog.ch:
+		assert.Equal(source.URL, m.source)
+
+		assert.Equal(map[string][]interface{}{
+			"hx-target": {"http://example.com/weblog/post-id"},
+			"hx-gone":   {true},
+		}, m.data)
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+}
+
+func TestMentionWithRelativeLink(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`<p>Just a link to <a href="/weblog/post-id">this post</a>.</p>`))
+	defer source.Close()
+
+	processed := make(chan error, 1)
+
+	go func() {
+		processed <- processMention(webmention{
+			source: source.URL + "/some/post",
+			target: source.URL + "/weblog/post-id",
+		}, &relativeBlog{fakeBlog: blog}, testClient)
+	}()
+
+	select {
+	case m := <-blog.ch:
+		assert.Equal(source.URL+"/some/post", m.source)
+		assert.Equal(map[string][]interface{}{
+			"hx-target": {source.URL + "/weblog/post-id"},
+		}, m.data)
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+
+	assert.Nil(<-processed)
+}
+
+type relativeBlog struct {
+	*fakeBlog
+}
+
+func (b *relativeBlog) Entry(url string) (map[string][]interface{}, error) {
+	return map[string][]interface{}{}, nil
+}
+
+func TestMentionFromJSON(t *testing.T) {
+	testCases := map[string]struct {
+		body     string
+		expected map[string][]interface{}
+	}{
+		"linked": {
+			body: `{"type": "entry", "properties": {"in-reply-to": ["http://example.com/weblog/post-id"]}}`,
+			expected: map[string][]interface{}{
+				"hx-target": {"http://example.com/weblog/post-id"},
+			},
+		},
+		"not-linked": {
+			body: `{"type": "entry", "content": "http://example.com/weblog/post-id is a great post"}`,
+			expected: map[string][]interface{}{
+				"hx-target": {"http://example.com/weblog/post-id"},
+				"hx-gone":   {true},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			blog := &fakeBlog{ch: make(chan mention, 1)}
+
+			source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.body))
+			}))
+			defer source.Close()
+
+			handler := Endpoint(blog, &fakeQueue{}, testClient)
+
+			req := newFormRequest(url.Values{
+				"source": {source.URL},
+				"target": {"http://example.com/weblog/post-id"},
+			})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			assert.Equal(http.StatusCreated, resp.StatusCode)
+
+			select {
+			case m := <-blog.ch:
+				assert.Equal(source.URL, m.source)
+				assert.Equal(tc.expected, m.data)
+			case <-time.After(waitTime):
+				t.Fatal("failed to get notified")
+			}
+		})
+	}
+}
+
+func TestMentionFromPlainText(t *testing.T) {
+	testCases := map[string]struct {
+		body     string
+		expected map[string][]interface{}
+	}{
+		"linked": {
+			body: `I liked http://example.com/weblog/post-id a lot`,
+			expected: map[string][]interface{}{
+				"hx-target": {"http://example.com/weblog/post-id"},
+			},
+		},
+		"not-linked": {
+			body: `I liked http://example.com/weblog/other-post-id a lot`,
+			expected: map[string][]interface{}{
+				"hx-target": {"http://example.com/weblog/post-id"},
+				"hx-gone":   {true},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			blog := &fakeBlog{ch: make(chan mention, 1)}
+
+			source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Write([]byte(tc.body))
+			}))
+			defer source.Close()
+
+			handler := Endpoint(blog, &fakeQueue{}, testClient)
+
+			req := newFormRequest(url.Values{
+				"source": {source.URL},
+				"target": {"http://example.com/weblog/post-id"},
+			})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			assert.Equal(http.StatusCreated, resp.StatusCode)
+
+			select {
+			case m := <-blog.ch:
+				assert.Equal(source.URL, m.source)
+				assert.Equal(tc.expected, m.data)
+			case <-time.After(waitTime):
+				t.Fatal("failed to get notified")
+			}
+		})
+	}
+}
+
+func TestMentionIsQueued(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+	queue := &fakeQueue{}
+
+	source := httptest.NewServer(stringHandler(`<p>Just a link to <a href="http://example.com/weblog/post-id">this post</a>.</p>`))
+	defer source.Close()
+
+	handler := Endpoint(blog, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {source.URL},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.Equal("http://example.com/-/webmention/status/0", resp.Header.Get("Location"))
+
+	select {
+	case <-blog.ch:
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+
+	assert.Eventually(func() bool {
+		return queue.job("0").Status == StatusVerified
+	}, waitTime, time.Millisecond)
+}
+
+func TestMentionIsRetriedWhenSourceUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+	queue := &fakeQueue{}
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer source.Close()
+
+	handler := Endpoint(blog, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {source.URL},
+		"target": {"http://example.com/weblog/post-id"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	assert.Eventually(func() bool {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return len(queue.retries) == 1
+	}, waitTime, time.Millisecond)
+
+	assert.Equal(1, queue.job("0").Attempts)
+	assert.Equal("retrieving 'source' returned 503", queue.job("0").Reason)
+}
+
+func TestMentionFailsWhenTargetMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+	queue := &fakeQueue{}
+
+	handler := Endpoint(blog, queue, testClient)
+
+	req := newFormRequest(url.Values{
+		"source": {"http://source.example.com/"},
+		"target": {"http://example.com/weblog/what"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	assert.Eventually(func() bool {
+		return queue.job("0").Status == StatusFailed
+	}, waitTime, time.Millisecond)
+
+	assert.Equal("no such post at 'target'", queue.job("0").Reason)
+}
+
+func TestMentionContentIsSanitized(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`<div class="h-entry">
+  <a class="u-in-reply-to" href="http://example.com/weblog/post-id">a post</a>
+  <div class="e-content">I <strong>agree</strong><script>alert(1)</script> <a href="javascript:alert(1)" onclick="x()">click</a></div>
+</div>`))
+	defer source.Close()
+
+	processed := make(chan error, 1)
+
+	go func() {
+		processed <- processMention(webmention{
+			source: source.URL,
+			target: "http://example.com/weblog/post-id",
+		}, blog, testClient)
+	}()
+
+	select {
+	case m := <-blog.ch:
+		assert.Equal([]interface{}{map[string]interface{}{
+			"html": `I <strong>agree</strong> <a rel="nofollow ugc">click</a>`,
+		}}, m.data["content"])
+	case <-time.After(waitTime):
+		t.Fatal("failed to get notified")
+	}
+
+	assert.Nil(<-processed)
+}
+
+func TestMentionFromPrivateAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	blog := &fakeBlog{ch: make(chan mention, 1)}
+
+	source := httptest.NewServer(stringHandler(`<a href="http://example.com/weblog/post-id">a link</a>`))
+	defer source.Close()
+
+	err := processMention(webmention{
+		source: source.URL,
+		target: "http://example.com/weblog/post-id",
+	}, blog, fetch.New(false))
+
+	if assert.NotNil(err) {
+		assert.False(isTemporary(err))
+	}
+}
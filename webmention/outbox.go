@@ -0,0 +1,180 @@
+package webmention
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	// StatusSent is the status of a webmention that has been accepted by the
+	// target's endpoint.
+	StatusSent = "sent"
+	// StatusNoEndpoint is the status of a webmention that was not sent as the
+	// target does not have an endpoint.
+	StatusNoEndpoint = "no-endpoint"
+)
+
+// sendDelay is how long to wait before sending a queued webmention, so that
+// the source has been saved before the target tries to verify it.
+const sendDelay = time.Second
+
+// Delivery is the state of sending a webmention from source to target.
+type Delivery struct {
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	Status     string    `json:"status"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Location   string    `json:"location,omitempty"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"lastError,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// OutboxStore persists webmentions waiting to be sent, and the result of
+// sending them.
+type OutboxStore interface {
+	// Add queues a webmention from source to target to be sent after at. If
+	// the pair already exists it is reset, so that it will be sent again.
+	Add(source, target string, at time.Time) error
+
+	// Claim returns a queued delivery that is due to be attempted at now,
+	// marking it as processing and incrementing its attempts. If there are none
+	// due ok is false.
+	Claim(now time.Time) (delivery Delivery, ok bool, err error)
+
+	// Finish records the result of a delivery that will not be attempted again.
+	Finish(delivery Delivery) error
+
+	// Retry records the result of a failed delivery, returning it to the queue
+	// to be attempted again after at.
+	Retry(delivery Delivery, at time.Time) error
+
+	// Deliveries lists the deliveries from source, or all deliveries if source
+	// is empty.
+	Deliveries(source string) ([]Delivery, error)
+
+	// Requeue returns any deliveries that were being processed to the queue. It
+	// is called on startup to resume sending that was interrupted.
+	Requeue() error
+}
+
+// Outbox sends webmentions asynchronously, retrying any that fail with a
+// temporary error.
+type Outbox struct {
+	store  OutboxStore
+	sender *Sender
+	delay  time.Duration
+	wake   chan struct{}
+}
+
+// NewOutbox creates an Outbox and starts sending any webmentions left in store
+// using sender.
+func NewOutbox(store OutboxStore, sender *Sender) *Outbox {
+	o := &Outbox{
+		store:  store,
+		sender: sender,
+		delay:  sendDelay,
+		wake:   make(chan struct{}, 1),
+	}
+
+	if err := store.Requeue(); err != nil {
+		slog.Error("requeue outgoing webmentions", slog.Any("err", err))
+	}
+
+	for range workers {
+		go o.work()
+	}
+
+	return o
+}
+
+// Queue adds a webmention from source to target to be sent.
+func (o *Outbox) Queue(source, target string) error {
+	if err := o.store.Add(source, target, time.Now().UTC().Add(o.delay)); err != nil {
+		return err
+	}
+
+	time.AfterFunc(o.delay, func() {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	})
+
+	return nil
+}
+
+// Deliveries lists the webmentions sent from source, or all if source is
+// empty.
+func (o *Outbox) Deliveries(source string) ([]Delivery, error) {
+	return o.store.Deliveries(source)
+}
+
+func (o *Outbox) work() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for o.sendNext() {
+		}
+
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendNext claims and sends a single webmention from the store, returning false
+// if there was nothing to do.
+func (o *Outbox) sendNext() bool {
+	delivery, ok, err := o.store.Claim(time.Now().UTC())
+	if err != nil {
+		slog.Error("claim outgoing webmention", slog.Any("err", err))
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	result, err := o.sender.Deliver(delivery.Source, delivery.Target)
+	delivery.Endpoint = result.Endpoint
+	delivery.StatusCode = result.StatusCode
+	delivery.Location = result.Location
+	delivery.LastError = ""
+
+	if err == nil {
+		delivery.Status = StatusSent
+		if err := o.store.Finish(delivery); err != nil {
+			slog.Error("finish outgoing webmention", slog.String("source", delivery.Source), slog.String("target", delivery.Target), slog.Any("err", err))
+		}
+		return true
+	}
+
+	if errors.Is(err, ErrNoEndpoint) {
+		delivery.Status = StatusNoEndpoint
+		if err := o.store.Finish(delivery); err != nil {
+			slog.Error("finish outgoing webmention", slog.String("source", delivery.Source), slog.String("target", delivery.Target), slog.Any("err", err))
+		}
+		return true
+	}
+
+	slog.Error("send webmention", slog.String("source", delivery.Source), slog.String("target", delivery.Target), slog.Int("attempts", delivery.Attempts), slog.Any("err", err))
+	delivery.LastError = err.Error()
+
+	if isTemporary(err) && delivery.Attempts < maxAttempts {
+		delivery.Status = StatusQueued
+		if err := o.store.Retry(delivery, time.Now().UTC().Add(backoff(delivery.Attempts))); err != nil {
+			slog.Error("retry outgoing webmention", slog.String("source", delivery.Source), slog.String("target", delivery.Target), slog.Any("err", err))
+		}
+		return true
+	}
+
+	delivery.Status = StatusFailed
+	if err := o.store.Finish(delivery); err != nil {
+		slog.Error("finish outgoing webmention", slog.String("source", delivery.Source), slog.String("target", delivery.Target), slog.Any("err", err))
+	}
+	return true
+}
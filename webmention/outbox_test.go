@@ -0,0 +1,151 @@
+package webmention
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOutboxStore struct {
+	mu         sync.Mutex
+	deliveries []Delivery
+	due        []time.Time
+}
+
+func (s *fakeOutboxStore) Add(source, target string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliveries = append(s.deliveries, Delivery{Source: source, Target: target, Status: StatusQueued})
+	s.due = append(s.due, at)
+	return nil
+}
+
+func (s *fakeOutboxStore) Claim(now time.Time) (Delivery, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, delivery := range s.deliveries {
+		if delivery.Status == StatusQueued && !s.due[i].After(now) {
+			s.deliveries[i].Status = StatusProcessing
+			s.deliveries[i].Attempts++
+			return s.deliveries[i], true, nil
+		}
+	}
+
+	return Delivery{}, false, nil
+}
+
+func (s *fakeOutboxStore) update(delivery Delivery, at time.Time) {
+	for i, d := range s.deliveries {
+		if d.Source == delivery.Source && d.Target == delivery.Target {
+			s.deliveries[i] = delivery
+			s.due[i] = at
+		}
+	}
+}
+
+func (s *fakeOutboxStore) Finish(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(delivery, time.Time{})
+	return nil
+}
+
+func (s *fakeOutboxStore) Retry(delivery Delivery, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(delivery, at)
+	return nil
+}
+
+func (s *fakeOutboxStore) Deliveries(source string) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range s.deliveries {
+		if source == "" || delivery.Source == source {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *fakeOutboxStore) Requeue() error {
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	assert := assert.New(t)
+	reqs := make(chan req, 1)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webmention" {
+			reqs <- req{r.FormValue("source"), r.FormValue("target")}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	}))
+	defer target.Close()
+
+	store := &fakeOutboxStore{}
+	outbox := NewOutbox(store, newTestSender())
+	outbox.delay = 0
+
+	assert.Nil(outbox.Queue("http://example.com/my-post", target.URL))
+
+	select {
+	case r := <-reqs:
+		assert.Equal("http://example.com/my-post", r.source)
+		assert.Equal(target.URL, r.target)
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+	}
+
+	assert.Eventually(func() bool {
+		deliveries, _ := outbox.Deliveries("http://example.com/my-post")
+		return len(deliveries) == 1 && deliveries[0].Status == StatusSent
+	}, time.Second, time.Millisecond)
+
+	deliveries, _ := outbox.Deliveries("")
+	assert.Equal(http.StatusAccepted, deliveries[0].StatusCode)
+	assert.Equal(target.URL+"/webmention", deliveries[0].Endpoint)
+}
+
+func TestOutboxRetriesTemporaryFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webmention" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	}))
+	defer target.Close()
+
+	store := &fakeOutboxStore{}
+	outbox := NewOutbox(store, newTestSender())
+	outbox.delay = 0
+
+	assert.Nil(outbox.Queue("http://example.com/my-post", target.URL))
+
+	assert.Eventually(func() bool {
+		deliveries, _ := outbox.Deliveries("")
+		return len(deliveries) == 1 && deliveries[0].Attempts == 1 && deliveries[0].Status == StatusQueued
+	}, time.Second, time.Millisecond)
+
+	deliveries, _ := outbox.Deliveries("")
+	assert.Equal("endpoint returned 503", deliveries[0].LastError)
+	assert.Equal(http.StatusServiceUnavailable, deliveries[0].StatusCode)
+}
@@ -0,0 +1,172 @@
+package webmention
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrJobNotFound is returned by a Queue when asked for a job that does not
+// exist.
+var ErrJobNotFound = errors.New("webmention job not found")
+
+const (
+	// StatusQueued is the status of a webmention waiting to be processed.
+	StatusQueued = "queued"
+	// StatusProcessing is the status of a webmention currently being processed.
+	StatusProcessing = "processing"
+	// StatusVerified is the status of a webmention that has been processed
+	// successfully.
+	StatusVerified = "verified"
+	// StatusPending is the status of a webmention that has been processed
+	// successfully, but is held until it is approved.
+	StatusPending = "pending"
+	// StatusFailed is the status of a webmention that could not be processed.
+	StatusFailed = "failed"
+)
+
+const (
+	workers      = 4
+	maxAttempts  = 5
+	pollInterval = 10 * time.Second
+)
+
+// Job is a received webmention held in a Queue.
+type Job struct {
+	ID       string
+	Source   string
+	Target   string
+	Status   string
+	Reason   string
+	Attempts int
+}
+
+// Queue persists received webmentions so that they can be processed, and
+// retried, asynchronously.
+type Queue interface {
+	// Enqueue adds a new webmention to the queue with a status of queued,
+	// returning its id.
+	Enqueue(source, target string) (id string, err error)
+
+	// Claim returns a queued job that is due to be attempted at now, marking it
+	// as processing and incrementing its attempts. If there are no jobs due ok
+	// is false.
+	Claim(now time.Time) (job Job, ok bool, err error)
+
+	// Finish sets the status of a job to either verified, pending or failed.
+	Finish(id, status, reason string) error
+
+	// Retry returns a job to the queue, to be attempted again after at.
+	Retry(id string, at time.Time, reason string) error
+
+	// Job returns the job with the given id, or ErrJobNotFound.
+	Job(id string) (Job, error)
+
+	// Requeue returns any jobs that were being processed to the queue. It is
+	// called on startup to resume jobs that were interrupted.
+	Requeue() error
+}
+
+// temporaryError marks a problem processing a webmention that may succeed if
+// attempted later, for instance if the source could not be fetched.
+type temporaryError struct {
+	err error
+}
+
+func (e temporaryError) Error() string { return e.err.Error() }
+func (e temporaryError) Unwrap() error { return e.err }
+
+func temporary(err error) error {
+	return temporaryError{err}
+}
+
+func isTemporary(err error) bool {
+	var t temporaryError
+	return errors.As(err, &t)
+}
+
+// backoff returns how long to wait before making the next attempt at a job.
+func backoff(attempts int) time.Duration {
+	return time.Duration(1<<attempts) * time.Minute
+}
+
+// startWorkers begins processing jobs from queue, a job is checked for when
+// wake is signalled or at least every pollInterval.
+func startWorkers(blog Blog, queue Queue, client *http.Client, wake chan struct{}) {
+	if err := queue.Requeue(); err != nil {
+		slog.Error("requeue webmentions", slog.Any("err", err))
+	}
+
+	for range workers {
+		go func() {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
+			for {
+				for processNext(blog, queue, client) {
+				}
+
+				select {
+				case <-wake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// processNext claims and processes a single job from the queue, returning
+// false if there was nothing to do.
+func processNext(blog Blog, queue Queue, client *http.Client) bool {
+	job, ok, err := queue.Claim(time.Now().UTC())
+	if err != nil {
+		slog.Error("claim webmention", slog.Any("err", err))
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	slog.Info("received webmention", slog.String("target", job.Target), slog.String("source", job.Source), slog.Int("attempts", job.Attempts))
+
+	err = processSafely(webmention{source: job.Source, target: job.Target}, blog, client)
+	if err == nil || errors.Is(err, ErrPending) {
+		status := StatusVerified
+		if err != nil {
+			status = StatusPending
+		}
+
+		if err := queue.Finish(job.ID, status, ""); err != nil {
+			slog.Error("finish webmention", slog.String("id", job.ID), slog.Any("err", err))
+		}
+		return true
+	}
+
+	slog.Error("error processing mention", slog.String("id", job.ID), slog.Any("err", err))
+
+	if isTemporary(err) && job.Attempts < maxAttempts {
+		if err := queue.Retry(job.ID, time.Now().UTC().Add(backoff(job.Attempts)), err.Error()); err != nil {
+			slog.Error("retry webmention", slog.String("id", job.ID), slog.Any("err", err))
+		}
+		return true
+	}
+
+	if err := queue.Finish(job.ID, StatusFailed, err.Error()); err != nil {
+		slog.Error("finish webmention", slog.String("id", job.ID), slog.Any("err", err))
+	}
+	return true
+}
+
+// processSafely calls processMention, returning an error if it panics so that a
+// source that can't be handled fails its job instead of stopping the worker.
+func processSafely(mention webmention, blog Blog, client *http.Client) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic processing webmention: %v", r)
+		}
+	}()
+
+	return processMention(mention, blog, client)
+}
@@ -2,90 +2,227 @@ package webmention
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/tomnomnom/linkheader"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"hawx.me/code/tally-ho/internal/fetch"
 	"hawx.me/code/tally-ho/internal/htmlutil"
 )
 
-func Send(source, target string) error {
-	endpoint, err := discoverEndpoint(target)
+var (
+	// ErrNoEndpoint is returned when the target does not advertise a webmention
+	// endpoint.
+	ErrNoEndpoint = errors.New("no webmention endpoint found")
+
+	// ErrLocalEndpoint is returned when the target advertises a webmention
+	// endpoint on localhost or a loopback address.
+	ErrLocalEndpoint = errors.New("webmention endpoint is a local address")
+)
+
+// endpointTTL is how long a discovered endpoint is remembered for a host.
+const endpointTTL = time.Hour
+
+// Sender sends webmentions, remembering the endpoint discovered for each host
+// of a target for a while.
+type Sender struct {
+	client        *http.Client
+	ttl           time.Duration
+	allowLoopback bool
+
+	mu        sync.Mutex
+	endpoints map[string]cachedEndpoint
+}
+
+type cachedEndpoint struct {
+	endpoint string
+	expires  time.Time
+}
+
+// NewSender returns a Sender that makes requests with client. Unless
+// allowLoopback is true it will not send to endpoints on localhost, which
+// should only be allowed when developing locally.
+func NewSender(client *http.Client, allowLoopback bool) *Sender {
+	return &Sender{
+		client:        client,
+		ttl:           endpointTTL,
+		allowLoopback: allowLoopback,
+		endpoints:     map[string]cachedEndpoint{},
+	}
+}
+
+// Send sends a webmention from source to target.
+func (s *Sender) Send(source, target string) error {
+	_, err := s.Deliver(source, target)
+	return err
+}
+
+// Deliver sends a webmention from source to target, returning the details of
+// the attempt. An error is returned if the endpoint could not be found or did
+// not accept the webmention, which is temporary if retrying may succeed.
+func (s *Sender) Deliver(source, target string) (Delivery, error) {
+	delivery := Delivery{Source: source, Target: target}
+
+	endpoint, err := s.Endpoint(target)
 	if err != nil {
-		return err
+		return delivery, err
 	}
+	delivery.Endpoint = endpoint
 
-	resp, err := http.PostForm(endpoint, url.Values{
+	resp, err := s.client.PostForm(endpoint, url.Values{
 		"source": {source},
 		"target": {target},
 	})
 	if err != nil {
-		return err
+		if errors.Is(err, fetch.ErrPrivateAddress) {
+			return delivery, err
+		}
+		return delivery, temporary(err)
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+
+	if location, err := resp.Location(); err == nil {
+		delivery.Location = location.String()
+	}
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return delivery, temporary(fmt.Errorf("endpoint returned %d", resp.StatusCode))
+	}
+
+	if resp.StatusCode >= 400 {
+		return delivery, fmt.Errorf("endpoint returned %d", resp.StatusCode)
 	}
-	return resp.Body.Close()
+
+	return delivery, nil
 }
 
-func discoverEndpoint(target string) (string, error) {
+// Endpoint returns the webmention endpoint for target, using a previously
+// discovered endpoint for the same host if one is known.
+func (s *Sender) Endpoint(target string) (string, error) {
 	targetURL, err := url.Parse(target)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.Get(target)
+	s.mu.Lock()
+	cached, ok := s.endpoints[targetURL.Host]
+	s.mu.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.endpoint, nil
+	}
+
+	endpoint, err := s.discoverEndpoint(targetURL)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	for _, header := range resp.Header["Link"] {
-		link := hrefByRel("webmention", linkheader.Parse(header))
+	s.mu.Lock()
+	s.endpoints[targetURL.Host] = cachedEndpoint{endpoint: endpoint, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
 
-		if link == "" {
-			continue
-		}
+	return endpoint, nil
+}
 
-		linkURL, err := url.Parse(link)
-		if err != nil {
-			return "", err
+// discoverEndpoint finds the webmention endpoint for target following
+// https://www.w3.org/TR/webmention/#sender-discovers-receiver-webmention-endpoint.
+func (s *Sender) discoverEndpoint(targetURL *url.URL) (string, error) {
+	resp, err := s.client.Get(targetURL.String())
+	if err != nil {
+		if errors.Is(err, fetch.ErrPrivateAddress) {
+			return "", fmt.Errorf("discover endpoint: %w", err)
 		}
+		return "", temporary(fmt.Errorf("discover endpoint: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return "", temporary(fmt.Errorf("discover endpoint: target returned %d", resp.StatusCode))
+	}
+
+	// relative endpoints are resolved against the final URL, after any
+	// redirects have been followed
+	base := resp.Request.URL
 
-		return targetURL.ResolveReference(linkURL).String(), nil
+	if link, ok := hrefByRel("webmention", linkheader.ParseMultiple(resp.Header["Link"])); ok {
+		return s.resolveEndpoint(base, link)
 	}
 
-	root, err := html.Parse(resp.Body)
+	root, err := html.Parse(io.LimitReader(resp.Body, maxSourceSize))
 	if err != nil {
-		return "", err
+		return "", temporary(fmt.Errorf("discover endpoint: %w", err))
 	}
 
 	links := htmlutil.SearchAll(root, func(node *html.Node) bool {
 		return node.Type == html.ElementNode &&
 			(node.DataAtom == atom.Link || node.DataAtom == atom.A) &&
-			htmlutil.HasAttr(node, "rel", "webmention") &&
+			hasRel(htmlutil.Attr(node, "rel"), "webmention") &&
 			htmlutil.Has(node, "href")
 	})
 
 	if len(links) > 0 {
-		webmentionLinkURL, err := url.Parse(htmlutil.Attr(links[0], "href"))
-		if err != nil {
-			return "", err
+		return s.resolveEndpoint(base, htmlutil.Attr(links[0], "href"))
+	}
+
+	return "", ErrNoEndpoint
+}
+
+func (s *Sender) resolveEndpoint(base *url.URL, href string) (string, error) {
+	hrefURL, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", fmt.Errorf("discover endpoint: %w", err)
+	}
+
+	endpoint := base.ResolveReference(hrefURL)
+	endpoint.Fragment = ""
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return "", ErrNoEndpoint
+	}
+
+	if !s.allowLoopback && isLocal(endpoint.Hostname()) {
+		return "", ErrLocalEndpoint
+	}
+
+	return endpoint.String(), nil
+}
+
+func isLocal(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+func hasRel(rels, rel string) bool {
+	for _, r := range strings.Fields(rels) {
+		if strings.EqualFold(r, rel) {
+			return true
 		}
-		return targetURL.ResolveReference(webmentionLinkURL).String(), nil
 	}
 
-	return "", errors.New("nope")
+	return false
 }
 
-func hrefByRel(rel string, links linkheader.Links) string {
+func hrefByRel(rel string, links linkheader.Links) (string, bool) {
 	for _, link := range links {
-		for _, r := range strings.Fields(link.Rel) {
-			if r == rel {
-				return link.URL
-			}
+		if hasRel(link.Rel, rel) {
+			return link.URL, true
 		}
 	}
 
-	return ""
+	return "", false
 }
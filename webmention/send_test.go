@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +15,12 @@ type req struct {
 	source, target string
 }
 
+// newTestSender returns a Sender that allows the loopback endpoints used by
+// httptest.
+func newTestSender() *Sender {
+	return NewSender(testClient, true)
+}
+
 func TestSendLinkHeaderOtherHost(t *testing.T) {
 	assert := assert.New(t)
 	reqs := make(chan req, 1)
@@ -28,7 +35,7 @@ func TestSendLinkHeaderOtherHost(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -54,7 +61,7 @@ func TestSendLinkHeaderAbsolute(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -80,7 +87,7 @@ func TestSendLinkHeaderRelative(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -106,7 +113,7 @@ func TestSendLinkTagAbsolute(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -132,7 +139,7 @@ func TestSendLinkTagRelative(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -158,7 +165,7 @@ func TestSendATagAbsolute(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -184,7 +191,7 @@ func TestSendATagRelative(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -210,7 +217,7 @@ func TestSendLinkHeaderRelQuoted(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -236,7 +243,7 @@ func TestSendLinkTagMultipleValues(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -262,7 +269,7 @@ func TestSendLinkHeaderRelMultipleValues(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -296,7 +303,7 @@ func TestSendMultipleMethods(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -322,7 +329,7 @@ func TestSendToSelf(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -349,7 +356,7 @@ func TestSendToFirst(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -377,7 +384,7 @@ func TestSendMultipleLinkHeaders(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -403,7 +410,7 @@ func TestSendLinkHeaderWithMultipleLinks(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -432,7 +439,7 @@ func TestSendLinkWithNoHref(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -458,7 +465,7 @@ func TestSendLinkWithQueryString(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -490,7 +497,7 @@ func TestSendToRedirect(t *testing.T) {
 	}))
 	defer target.Close()
 
-	err := Send("http://example.com/my-post", target.URL)
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
 	assert.Nil(err)
 
 	select {
@@ -501,3 +508,164 @@ func TestSendToRedirect(t *testing.T) {
 		assert.Fail("timed out")
 	}
 }
+
+func TestDeliver(t *testing.T) {
+	assert := assert.New(t)
+	var target *httptest.Server
+
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webmention" {
+			w.Header().Set("Location", "/webmention/status/1")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	}))
+	defer target.Close()
+
+	delivery, err := newTestSender().Deliver("http://example.com/my-post", target.URL)
+	assert.Nil(err)
+	assert.Equal(Delivery{
+		Source:     "http://example.com/my-post",
+		Target:     target.URL,
+		Endpoint:   target.URL + "/webmention",
+		StatusCode: http.StatusCreated,
+		Location:   target.URL + "/webmention/status/1",
+	}, delivery)
+}
+
+func TestDeliverWhenRejected(t *testing.T) {
+	testCases := map[string]struct {
+		status    int
+		temporary bool
+	}{
+		"bad request":       {http.StatusBadRequest, false},
+		"too many requests": {http.StatusTooManyRequests, true},
+		"server error":      {http.StatusServiceUnavailable, true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/webmention" {
+					w.WriteHeader(tc.status)
+					return
+				}
+
+				w.Header().Set("Link", "</webmention>; rel=webmention")
+			}))
+			defer target.Close()
+
+			delivery, err := newTestSender().Deliver("http://example.com/my-post", target.URL)
+			if assert.NotNil(err) {
+				assert.Equal(tc.temporary, isTemporary(err))
+			}
+			assert.Equal(tc.status, delivery.StatusCode)
+		})
+	}
+}
+
+func TestSendToRedirectRelative(t *testing.T) {
+	assert := assert.New(t)
+	reqs := make(chan req, 1)
+	var target *httptest.Server
+
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved/webmention" {
+			reqs <- req{r.FormValue("source"), r.FormValue("target")}
+			return
+		}
+
+		if r.URL.Path == "/moved/post" {
+			io.WriteString(w, `<link rel="webmention" href="webmention" />`)
+			return
+		}
+
+		http.Redirect(w, r, "/moved/post", http.StatusMovedPermanently)
+	}))
+	defer target.Close()
+
+	err := newTestSender().Send("http://example.com/my-post", target.URL+"/post")
+	assert.Nil(err)
+
+	select {
+	case r := <-reqs:
+		assert.Equal("http://example.com/my-post", r.source)
+		assert.Equal(target.URL+"/post", r.target)
+	case <-time.After(10 * time.Millisecond):
+		assert.Fail("timed out")
+	}
+}
+
+func TestSendNoEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<a href="/webmention">not a webmention endpoint</a>`)
+	}))
+	defer target.Close()
+
+	err := newTestSender().Send("http://example.com/my-post", target.URL)
+	assert.Equal(ErrNoEndpoint, err)
+}
+
+func TestSendToLoopback(t *testing.T) {
+	assert := assert.New(t)
+	reqs := make(chan req, 1)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			reqs <- req{r.FormValue("source"), r.FormValue("target")}
+		}
+
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	}))
+	defer target.Close()
+
+	err := NewSender(testClient, false).Send("http://example.com/my-post", target.URL)
+	assert.Equal(ErrLocalEndpoint, err)
+
+	select {
+	case <-reqs:
+		assert.Fail("should not have sent webmention")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSendCachesEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	reqs := make(chan req, 2)
+	var discoveries atomic.Int32
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webmention" {
+			reqs <- req{r.FormValue("source"), r.FormValue("target")}
+			return
+		}
+
+		discoveries.Add(1)
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	}))
+	defer target.Close()
+
+	sender := newTestSender()
+
+	assert.Nil(sender.Send("http://example.com/my-post", target.URL+"/one"))
+	assert.Nil(sender.Send("http://example.com/my-post", target.URL+"/two"))
+	assert.Equal(int32(1), discoveries.Load())
+
+	assert.Equal(req{"http://example.com/my-post", target.URL + "/one"}, <-reqs)
+	assert.Equal(req{"http://example.com/my-post", target.URL + "/two"}, <-reqs)
+
+	expiring := newTestSender()
+	expiring.ttl = 0
+
+	assert.Nil(expiring.Send("http://example.com/my-post", target.URL+"/one"))
+	<-reqs
+	assert.Nil(expiring.Send("http://example.com/my-post", target.URL+"/one"))
+	<-reqs
+	assert.Equal(int32(3), discoveries.Load())
+}
@@ -0,0 +1,64 @@
+package webmention
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"hawx.me/code/mux"
+)
+
+// statusPath is where Status is expected to be mounted, it is used to build the
+// Location returned when a webmention is received.
+const statusPath = "/-/webmention/status/"
+
+// Status returns a handler that reports the result of processing a received
+// webmention. It expects the request path to contain only the id of the job,
+// so should be mounted using http.StripPrefix.
+//
+// The response is a JSON object with a status of "queued", "accepted",
+// "pending", when it is held until approved, or "rejected", and if rejected a
+// reason.
+func Status(queue Queue) http.Handler {
+	return mux.Method{"GET": statusHandler(queue)}
+}
+
+func statusHandler(queue Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := queue.Job(strings.Trim(r.URL.Path, "/"))
+		if errors.Is(err, ErrJobNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("webmention status", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		status := "queued"
+		switch job.Status {
+		case StatusVerified:
+			status = "accepted"
+		case StatusPending:
+			status = "pending"
+		case StatusFailed:
+			status = "rejected"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+			Source string `json:"source"`
+			Target string `json:"target"`
+			Reason string `json:"reason,omitempty"`
+		}{
+			Status: status,
+			Source: job.Source,
+			Target: job.Target,
+			Reason: job.Reason,
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package webmention
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	queue := &fakeQueue{jobs: []Job{
+		{ID: "0", Source: "http://a.example.com/", Target: "http://example.com/p/1", Status: StatusQueued},
+		{ID: "1", Source: "http://b.example.com/", Target: "http://example.com/p/1", Status: StatusProcessing},
+		{ID: "2", Source: "http://c.example.com/", Target: "http://example.com/p/1", Status: StatusVerified},
+		{ID: "3", Source: "http://d.example.com/", Target: "http://example.com/p/1", Status: StatusFailed, Reason: "source does not link to target"},
+		{ID: "4", Source: "http://e.example.com/", Target: "http://example.com/p/1", Status: StatusPending},
+	}}
+
+	testCases := map[string]string{
+		"0": `{"status":"queued","source":"http://a.example.com/","target":"http://example.com/p/1"}`,
+		"1": `{"status":"queued","source":"http://b.example.com/","target":"http://example.com/p/1"}`,
+		"2": `{"status":"accepted","source":"http://c.example.com/","target":"http://example.com/p/1"}`,
+		"3": `{"status":"rejected","source":"http://d.example.com/","target":"http://example.com/p/1","reason":"source does not link to target"}`,
+		"4": `{"status":"pending","source":"http://e.example.com/","target":"http://example.com/p/1"}`,
+	}
+
+	handler := http.StripPrefix("/-/webmention/status/", Status(queue))
+
+	for id, expected := range testCases {
+		t.Run(id, func(t *testing.T) {
+			assert := assert.New(t)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/-/webmention/status/"+id, nil))
+
+			resp := w.Result()
+			assert.Equal(http.StatusOK, resp.StatusCode)
+			assert.Equal("application/json", resp.Header.Get("Content-Type"))
+			assert.JSONEq(expected, w.Body.String())
+		})
+	}
+}
+
+func TestStatusNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := http.StripPrefix("/-/webmention/status/", Status(&fakeQueue{}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/-/webmention/status/what", nil))
+
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+}
@@ -2,56 +2,111 @@ package websub
 
 import (
 	"bytes"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha512"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	defaultLease = 10 * 24 * time.Hour
 	maxLease     = 28 * 24 * time.Hour
+
+	// workers is the number of verifications and deliveries that can be made
+	// at the same time.
+	workers = 4
+	// queueSize is the number of tasks that can wait for a worker before
+	// queueing more blocks, or subscription requests are refused.
+	queueSize = 64
+	// maxAttempts is the number of times a delivery is attempted before the
+	// subscription is expired.
+	maxAttempts = 5
+	// sweepInterval is how often subscriptions with an ended lease are deleted.
+	sweepInterval = time.Hour
 )
 
+const (
+	// StatusDelivered is the status of a subscription when content was last
+	// accepted by the subscriber.
+	StatusDelivered = "delivered"
+	// StatusFailed is the status of a subscription when the last attempt to
+	// deliver content failed.
+	StatusFailed = "failed"
+)
+
+// Subscription is a subscriber to a topic, along with statistics about the
+// content delivered to it.
+type Subscription struct {
+	Callback       string     `json:"callback"`
+	Topic          string     `json:"topic"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	Deliveries     int        `json:"deliveries"`
+	Failures       int        `json:"failures"`
+	LastDeliveryAt *time.Time `json:"lastDeliveryAt,omitempty"`
+	LastStatus     string     `json:"lastStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Algorithm      string     `json:"algorithm,omitempty"`
+}
+
 type Subscriber struct {
-	Callback string
-	Secret   string
+	Callback  string
+	Secret    string
+	Algorithm string
 }
 
 type SubscribersIter interface {
 	Close() error
-	Data() (callback, secret string, err error)
+	Data() (callback, secret, algorithm string, err error)
 	Err() error
 	Next() bool
 }
 
 type HubStore interface {
-	Subscribe(callback, topic string, expiresAt time.Time, secret string) error
+	// Subscribe records the subscription of callback to topic until expiresAt.
+	// Content is signed with secret, if given, using algorithm, or the hub's
+	// algorithm if empty.
+	Subscribe(callback, topic string, expiresAt time.Time, secret, algorithm string) error
 	Subscribers(topic string) (SubscribersIter, error)
 	Unsubscribe(callback, topic string) error
+
+	// Delivered records an attempt to deliver content for topic to callback,
+	// err is nil if the attempt succeeded.
+	Delivered(callback, topic string, err error) error
+
+	// Expire deletes the subscriptions with a lease that ended before now,
+	// returning the number deleted.
+	Expire(now time.Time) (int64, error)
 }
 
-func New(baseURL string, store HubStore) *Hub {
-	noRedirectClient := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-		Timeout: 10 * time.Second,
+// New creates a Hub that makes requests to subscribers and topics with client.
+func New(baseURL string, store HubStore, client *http.Client) *Hub {
+	noRedirectClient := *client
+	noRedirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
 	hub := &Hub{
 		baseURL:          baseURL,
 		store:            store,
 		generator:        challengeGenerator(30),
-		noRedirectClient: noRedirectClient,
+		algorithm:        DefaultAlgorithm,
+		backoff:          backoff,
+		client:           client,
+		noRedirectClient: &noRedirectClient,
+		tasks:            make(chan func(), queueSize),
+	}
+
+	for range workers {
+		go hub.work()
 	}
+	go hub.sweep()
 
 	return hub
 }
@@ -60,7 +115,76 @@ type Hub struct {
 	baseURL          string
 	store            HubStore
 	generator        func() ([]byte, error)
+	algorithm        string
+	backoff          func(attempts int) time.Duration
+	client           *http.Client
 	noRedirectClient *http.Client
+	topicPrefixes    []string
+
+	tasks   chan func()
+	pending sync.WaitGroup
+}
+
+// backoff returns how long to wait before the next attempt at a delivery.
+func backoff(attempts int) time.Duration {
+	return time.Duration(1<<attempts) * time.Minute
+}
+
+func (h *Hub) work() {
+	for task := range h.tasks {
+		task()
+		h.pending.Done()
+	}
+}
+
+func (h *Hub) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := h.store.Expire(time.Now())
+		if err != nil {
+			slog.Error("expire subscriptions", slog.Any("err", err))
+			continue
+		}
+
+		if expired > 0 {
+			slog.Info("expired subscriptions", slog.Int64("count", expired))
+		}
+	}
+}
+
+// queue adds task to be run by a worker.
+func (h *Hub) queue(task func()) {
+	h.pending.Add(1)
+	h.tasks <- task
+}
+
+// tryQueue adds task to be run by a worker, unless the queue is full in which
+// case it returns false.
+func (h *Hub) tryQueue(task func()) bool {
+	h.pending.Add(1)
+
+	select {
+	case h.tasks <- task:
+		return true
+	default:
+		h.pending.Done()
+		return false
+	}
+}
+
+// queueAfter adds task to be run by a worker once delay has passed.
+func (h *Hub) queueAfter(delay time.Duration, task func()) {
+	h.pending.Add(1)
+	time.AfterFunc(delay, func() {
+		h.tasks <- task
+	})
+}
+
+// wait blocks until all queued tasks, including retries, have been run.
+func (h *Hub) wait() {
+	h.pending.Wait()
 }
 
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -75,6 +199,7 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		topic        = r.FormValue("hub.topic")
 		leaseSeconds = r.FormValue("hub.lease_seconds")
 		secret       = r.FormValue("hub.secret")
+		algorithm    = r.FormValue("hub.signature_algorithm")
 	)
 
 	callbackURL, err := url.Parse(callback)
@@ -88,14 +213,33 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	topicURL, err := url.Parse(topic)
+	if err != nil || !topicURL.IsAbs() {
+		http.Error(w, "hub.topic must be a url", http.StatusBadRequest)
+		return
+	}
+
 	if len(secret) > 200 {
 		http.Error(w, "hub.secret must be less than 200 bytes in length", http.StatusBadRequest)
 		return
 	}
 
-	challenge, err := h.generator()
-	if err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+	if _, ok := algorithms[algorithm]; algorithm != "" && !ok {
+		http.Error(w, ErrUnknownAlgorithm.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.allowedTopic(topic) {
+		slog.Info("denied subscription", slog.String("callback", callback), slog.String("topic", topic))
+		if !h.tryQueue(func() {
+			if err := h.deny(callbackURL, topic, "hub.topic is not published by this hub"); err != nil {
+				slog.Warn("send subscription denial", slog.String("callback", callback), slog.Any("err", err))
+			}
+		}) {
+			h.busy(w)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
@@ -110,48 +254,138 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	query := callbackURL.Query()
+	if !h.tryQueue(func() {
+		h.confirm(callbackURL, mode, topic, lease, secret, algorithm)
+	}) {
+		h.busy(w)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// busy responds to a subscription request that could not be queued, asking the
+// subscriber to try again later.
+func (h *Hub) busy(w http.ResponseWriter) {
+	slog.Warn("refused subscription request, queue is full")
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, "hub is busy, try again later", http.StatusServiceUnavailable)
+}
+
+// confirm verifies the intent of the subscriber, then records the change to
+// the subscription.
+func (h *Hub) confirm(callbackURL *url.URL, mode, topic string, lease time.Duration, secret, algorithm string) {
+	callback := callbackURL.String()
+
+	if err := h.verify(callbackURL, mode, topic, lease); err != nil {
+		slog.Warn("could not verify "+mode, slog.String("callback", callback), slog.String("topic", topic), slog.Any("err", err))
+		return
+	}
+
+	if mode == "unsubscribe" {
+		if err := h.store.Unsubscribe(callback, topic); err != nil {
+			slog.Error("unsubscribe", slog.String("callback", callback), slog.String("topic", topic), slog.Any("err", err))
+			return
+		}
+
+		slog.Info("confirmed unsubscription", slog.String("callback", callback), slog.String("topic", topic))
+		return
+	}
+
+	if err := h.store.Subscribe(callback, topic, time.Now().Add(lease), secret, algorithm); err != nil {
+		slog.Error("subscribe", slog.String("callback", callback), slog.String("topic", topic), slog.Any("err", err))
+		return
+	}
+
+	slog.Info("confirmed subscription", slog.String("callback", callback), slog.String("topic", topic), slog.Duration("lease", lease))
+}
+
+// RestrictTopics denies any subscription to a topic that does not start with
+// one of the prefixes.
+func (h *Hub) RestrictTopics(prefixes ...string) {
+	h.topicPrefixes = prefixes
+}
+
+func (h *Hub) allowedTopic(topic string) bool {
+	if len(h.topicPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range h.topicPrefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var errChallenge = errors.New("could not generate challenge")
+
+// verify checks that the subscriber at callback intended to make the request,
+// see https://www.w3.org/TR/websub/#hub-verifies-intent.
+func (h *Hub) verify(callbackURL *url.URL, mode, topic string, lease time.Duration) error {
+	challenge, err := h.generator()
+	if err != nil {
+		return errChallenge
+	}
+
+	verifyURL := *callbackURL
+	query := verifyURL.Query()
 	query.Add("hub.mode", mode)
 	query.Add("hub.topic", topic)
 	query.Add("hub.challenge", string(challenge))
-	query.Add("hub.lease_seconds", strconv.Itoa(int(lease.Seconds())))
-	callbackURL.RawQuery = query.Encode()
+	if mode == "subscribe" {
+		query.Add("hub.lease_seconds", strconv.Itoa(int(lease.Seconds())))
+	}
+	verifyURL.RawQuery = query.Encode()
 
-	slog.Info("confirm subscription", slog.String("url", callbackURL.String()))
+	slog.Info("confirm "+mode, slog.String("url", verifyURL.String()))
 
-	resp, err := http.Get(callbackURL.String())
+	resp, err := h.client.Get(verifyURL.String())
 	if err != nil {
-		http.Error(w, "problem requesting hub.callback", http.StatusBadRequest)
-		return
+		return errors.New("problem requesting hub.callback")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		http.Error(w, "hub.callback returned a non-200 response", http.StatusBadRequest)
-		return
+		return errors.New("hub.callback returned a non-200 response")
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+		return errors.New("problem reading hub.callback response")
 	}
 
 	if !bytes.Equal(data, challenge) {
-		http.Error(w, "hub.challenge must match", http.StatusBadRequest)
-		return
+		return errors.New("hub.challenge must match")
 	}
 
-	if err := h.store.Subscribe(callback, topic, time.Now().Add(lease), secret); err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
-		return
+	return nil
+}
+
+// deny tells the subscriber at callback that its subscription to topic was not
+// accepted, see https://www.w3.org/TR/websub/#subscription-validation.
+func (h *Hub) deny(callbackURL *url.URL, topic, reason string) error {
+	denyURL := *callbackURL
+	query := denyURL.Query()
+	query.Add("hub.mode", "denied")
+	query.Add("hub.topic", topic)
+	query.Add("hub.reason", reason)
+	denyURL.RawQuery = query.Encode()
+
+	resp, err := h.client.Get(denyURL.String())
+	if err != nil {
+		return err
 	}
 
-	slog.Info("confirmed subscription", slog.String("callback", callback), slog.String("topic", topic), slog.Duration("lease", lease))
-	w.WriteHeader(http.StatusAccepted)
+	return resp.Body.Close()
 }
 
+// Publish fetches the content of topic and queues it to be delivered to each
+// subscriber. Deliveries that fail are retried with a backoff, and the
+// subscription is expired if they continue to fail.
 func (h *Hub) Publish(topic string) error {
-	resp, err := http.Get(topic)
+	resp, err := h.client.Get(topic)
 	if err != nil {
 		return err
 	}
@@ -161,55 +395,147 @@ func (h *Hub) Publish(topic string) error {
 		return errors.New("could not retrieve topic: " + topic)
 	}
 
-	contentType := resp.Header.Get("Content-Type")
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
-	subscribers, err := h.store.Subscribers(topic)
+	subscribers, err := h.subscribers(topic)
 	if err != nil {
 		return err
 	}
-	defer subscribers.Close()
 
-	client := h.noRedirectClient
-	link := `<` + h.baseURL + `>; rel="hub", <` + topic + `>; rel="self"`
+	// the content is delivered with the type of the topic, so that a
+	// subscriber can tell a feed from a page
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	content := content{
+		topic:       topic,
+		contentType: contentType,
+		body:        body,
+	}
+
+	for _, subscriber := range subscribers {
+		h.queueDelivery(subscriber, content, 1)
+	}
+
+	return nil
+}
+
+type content struct {
+	topic       string
+	contentType string
+	body        []byte
+}
+
+func (h *Hub) subscribers(topic string) ([]Subscriber, error) {
+	iter, err := h.store.Subscribers(topic)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
 
-	for subscribers.Next() {
-		callback, secret, err := subscribers.Data()
+	var subscribers []Subscriber
+	for iter.Next() {
+		callback, secret, algorithm, err := iter.Data()
 		if err != nil {
 			continue
 		}
 
-		req, err := http.NewRequest("POST", callback, bytes.NewReader(body))
-		if err != nil {
-			continue
+		subscribers = append(subscribers, Subscriber{Callback: callback, Secret: secret, Algorithm: algorithm})
+	}
+
+	return subscribers, iter.Err()
+}
+
+func (h *Hub) queueDelivery(subscriber Subscriber, content content, attempt int) {
+	task := func() {
+		h.deliver(subscriber, content, attempt)
+	}
+
+	if attempt == 1 {
+		h.queue(task)
+	} else {
+		h.queueAfter(h.backoff(attempt-1), task)
+	}
+}
+
+// deliver makes an attempt at sending content to subscriber, see
+// https://www.w3.org/TR/websub/#content-distribution.
+func (h *Hub) deliver(subscriber Subscriber, content content, attempt int) {
+	logger := slog.With(slog.String("callback", subscriber.Callback), slog.String("topic", content.topic))
+
+	err := h.post(subscriber, content)
+	if err := h.store.Delivered(subscriber.Callback, content.topic, err); err != nil {
+		logger.Error("record delivery", slog.Any("err", err))
+	}
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, errGone) {
+		logger.Info("subscriber gone")
+		if err := h.store.Unsubscribe(subscriber.Callback, content.topic); err != nil {
+			logger.Error("unsubscribe", slog.Any("err", err))
 		}
+		return
+	}
 
-		req.Header.Add("Content-Type", contentType)
-		req.Header.Add("Link", link)
+	if attempt < maxAttempts {
+		logger.Warn("delivery failed", slog.Int("attempt", attempt), slog.Any("err", err))
+		h.queueDelivery(subscriber, content, attempt+1)
+		return
+	}
 
-		if secret != "" {
-			mac := hmac.New(sha512.New, []byte(secret))
-			if _, err := mac.Write(body); err != nil {
-				continue
-			}
-			req.Header.Add("X-Hub-Signature", "sha512="+hex.EncodeToString(mac.Sum(nil)))
+	logger.Warn("expiring subscription after failed deliveries", slog.Int("attempt", attempt), slog.Any("err", err))
+	if err := h.store.Unsubscribe(subscriber.Callback, content.topic); err != nil {
+		logger.Error("unsubscribe", slog.Any("err", err))
+	}
+}
+
+var errGone = errors.New("subscriber returned 410")
+
+func (h *Hub) post(subscriber Subscriber, content content) error {
+	req, err := http.NewRequest("POST", subscriber.Callback, bytes.NewReader(content.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", content.contentType)
+	req.Header.Add("Link", `<`+h.baseURL+`>; rel="hub", <`+content.topic+`>; rel="self"`)
+
+	if subscriber.Secret != "" {
+		algorithm := subscriber.Algorithm
+		if algorithm == "" {
+			algorithm = h.algorithm
 		}
 
-		resp, err := client.Do(req)
+		sig, err := signature(algorithm, subscriber.Secret, content.body)
 		if err != nil {
-			continue
+			return err
 		}
-		defer resp.Body.Close()
+		req.Header.Add("X-Hub-Signature", sig)
+	}
 
-		if resp.StatusCode == http.StatusGone {
-			h.store.Unsubscribe(callback, topic)
-		}
+	resp, err := h.noRedirectClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusGone {
+		return errGone
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("subscriber returned %d", resp.StatusCode)
 	}
 
-	return subscribers.Err()
+	return nil
 }
 
 const letters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
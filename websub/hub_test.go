@@ -7,10 +7,13 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/internal/fetch"
 )
 
 type fakeSubIter struct {
@@ -19,10 +22,10 @@ type fakeSubIter struct {
 }
 
 func (i *fakeSubIter) Close() error { return nil }
-func (i *fakeSubIter) Data() (string, string, error) {
+func (i *fakeSubIter) Data() (string, string, string, error) {
 	here := i.subs[i.current-1]
 
-	return here.callback, here.secret, nil
+	return here.callback, here.secret, here.algorithm, nil
 }
 func (i *fakeSubIter) Err() error { return nil }
 func (i *fakeSubIter) Next() bool {
@@ -39,24 +42,48 @@ type fakeSub struct {
 	topic     string
 	expiresAt time.Time
 	secret    string
+	algorithm string
 }
 
 type fakeHubStore struct {
-	subs   []fakeSub
-	unsubs []fakeSub
+	mu         sync.Mutex
+	subs       []fakeSub
+	unsubs     []fakeSub
+	deliveries []error
 }
 
-func (s *fakeHubStore) Subscribe(callback, topic string, expiresAt time.Time, secret string) error {
-	s.subs = append(s.subs, fakeSub{callback, topic, expiresAt, secret})
+func (s *fakeHubStore) Delivered(callback, topic string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliveries = append(s.deliveries, err)
+	return nil
+}
+
+func (s *fakeHubStore) Expire(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeHubStore) Subscribe(callback, topic string, expiresAt time.Time, secret, algorithm string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subs = append(s.subs, fakeSub{callback, topic, expiresAt, secret, algorithm})
 	return nil
 }
 
 func (s *fakeHubStore) Subscribers(topic string) (SubscribersIter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return &fakeSubIter{current: 0, subs: s.subs}, nil
 }
 
 func (s *fakeHubStore) Unsubscribe(callback, topic string) error {
-	s.unsubs = append(s.unsubs, fakeSub{callback, topic, time.Now(), ""})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unsubs = append(s.unsubs, fakeSub{callback, topic, time.Now(), "", ""})
 	return nil
 }
 
@@ -71,7 +98,7 @@ func TestSubscribe(t *testing.T) {
 	challenge := []byte{1, 2, 3, 4}
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 	hub.generator = func() ([]byte, error) {
 		return challenge, nil
 	}
@@ -94,6 +121,7 @@ func TestSubscribe(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusAccepted, resp.StatusCode)
@@ -123,7 +151,7 @@ func TestSubscribeWithSecret(t *testing.T) {
 	challenge := []byte{1, 2, 3, 4}
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 	hub.generator = func() ([]byte, error) {
 		return challenge, nil
 	}
@@ -147,6 +175,7 @@ func TestSubscribeWithSecret(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusAccepted, resp.StatusCode)
@@ -176,7 +205,7 @@ func TestSubscribeWithLongSecret(t *testing.T) {
 	challenge := []byte{1, 2, 3, 4}
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 	hub.generator = func() ([]byte, error) {
 		return challenge, nil
 	}
@@ -200,6 +229,7 @@ func TestSubscribeWithLongSecret(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusBadRequest, resp.StatusCode)
@@ -210,7 +240,7 @@ func TestSubscribeWithSpecificLease(t *testing.T) {
 	challenge := []byte{1, 2, 3, 4}
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 	hub.generator = func() ([]byte, error) {
 		return challenge, nil
 	}
@@ -234,6 +264,7 @@ func TestSubscribeWithSpecificLease(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusAccepted, resp.StatusCode)
@@ -262,7 +293,7 @@ func TestSubscribeWithTooLongLease(t *testing.T) {
 	challenge := []byte{1, 2, 3, 4}
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 	hub.generator = func() ([]byte, error) {
 		return challenge, nil
 	}
@@ -286,6 +317,7 @@ func TestSubscribeWithTooLongLease(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusAccepted, resp.StatusCode)
@@ -312,7 +344,8 @@ func TestSubscribeWithTooLongLease(t *testing.T) {
 func TestSubscribeWhenRespondingWithWrongChallenge(t *testing.T) {
 	assert := assert.New(t)
 
-	hub := New("", nil)
+	store := &fakeHubStore{}
+	hub := New("", store, fetch.New(true))
 
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet && r.URL.Path == "/unguessable-path-unique-per-subscription" {
@@ -329,20 +362,23 @@ func TestSubscribeWhenRespondingWithWrongChallenge(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
-	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Len(store.subs, 0)
 }
 
 func TestSubscribeNotPostRequest(t *testing.T) {
 	assert := assert.New(t)
 
-	hub := New("", nil)
+	hub := New("", nil, fetch.New(true))
 
 	req := httptest.NewRequest("GET", "http://localhost/", nil)
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
@@ -351,7 +387,7 @@ func TestSubscribeNotPostRequest(t *testing.T) {
 func TestSubscribeBadCallback(t *testing.T) {
 	assert := assert.New(t)
 
-	hub := New("", nil)
+	hub := New("", nil, fetch.New(true))
 
 	req := newFormRequest(url.Values{
 		"hub.callback": {"this-aint-a-url"},
@@ -361,6 +397,7 @@ func TestSubscribeBadCallback(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusBadRequest, resp.StatusCode)
@@ -369,7 +406,7 @@ func TestSubscribeBadCallback(t *testing.T) {
 func TestSubscribeBadMode(t *testing.T) {
 	assert := assert.New(t)
 
-	hub := New("", nil)
+	hub := New("", nil, fetch.New(true))
 
 	req := newFormRequest(url.Values{
 		"hub.callback": {"http://example.com/callback"},
@@ -379,6 +416,7 @@ func TestSubscribeBadMode(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
 	assert.Equal(http.StatusBadRequest, resp.StatusCode)
@@ -387,7 +425,8 @@ func TestSubscribeBadMode(t *testing.T) {
 func TestSubscribeBadVerificationResponse(t *testing.T) {
 	assert := assert.New(t)
 
-	hub := New("", nil)
+	store := &fakeHubStore{}
+	hub := New("", store, fetch.New(true))
 
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -402,16 +441,18 @@ func TestSubscribeBadVerificationResponse(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	hub.ServeHTTP(w, req)
+	hub.wait()
 
 	resp := w.Result()
-	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Len(store.subs, 0)
 }
 
 func TestPublish(t *testing.T) {
 	assert := assert.New(t)
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 
 	type request struct {
 		body    string
@@ -431,10 +472,11 @@ func TestPublish(t *testing.T) {
 	}))
 	defer c.Close()
 
-	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "")
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
 
 	err := hub.Publish(c.URL)
 	assert.Nil(err)
+	hub.wait()
 
 	select {
 	case r := <-req:
@@ -450,7 +492,7 @@ func TestPublishWithSignature(t *testing.T) {
 	assert := assert.New(t)
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 
 	type request struct {
 		body    string
@@ -470,10 +512,11 @@ func TestPublishWithSignature(t *testing.T) {
 	}))
 	defer c.Close()
 
-	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "catgifs")
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "catgifs", "")
 
 	err := hub.Publish(c.URL)
 	assert.Nil(err)
+	hub.wait()
 
 	select {
 	case r := <-req:
@@ -490,7 +533,8 @@ func TestPublishReturnsRedirect(t *testing.T) {
 	assert := assert.New(t)
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+	hub.backoff = func(int) time.Duration { return 0 }
 
 	type request struct {
 		body    string
@@ -513,17 +557,21 @@ func TestPublishReturnsRedirect(t *testing.T) {
 	}))
 	defer c.Close()
 
-	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "")
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
 
 	err := hub.Publish(c.URL)
 	assert.Nil(err)
+	hub.wait()
+
+	// a redirect is a failed delivery
+	assert.Len(store.unsubs, 1)
 }
 
 func TestPublishReturnsGone(t *testing.T) {
 	assert := assert.New(t)
 
 	store := &fakeHubStore{}
-	hub := New("http://hub.example.com/", store)
+	hub := New("http://hub.example.com/", store, fetch.New(true))
 
 	type request struct {
 		body    string
@@ -541,10 +589,11 @@ func TestPublishReturnsGone(t *testing.T) {
 	}))
 	defer c.Close()
 
-	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "")
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
 
 	err := hub.Publish(c.URL)
 	assert.Nil(err)
+	hub.wait()
 
 	if assert.Len(store.unsubs, 1) {
 		unsub := store.unsubs[0]
@@ -553,3 +602,439 @@ func TestPublishReturnsGone(t *testing.T) {
 		assert.Equal(c.URL, unsub.topic)
 	}
 }
+
+func TestUnsubscribe(t *testing.T) {
+	assert := assert.New(t)
+	challenge := []byte{1, 2, 3, 4}
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+	hub.generator = func() ([]byte, error) {
+		return challenge, nil
+	}
+
+	verification := make(chan url.Values, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/unguessable-path-unique-per-subscription" {
+			verification <- r.URL.Query()
+			w.Write(challenge)
+		}
+	}))
+	defer s.Close()
+
+	req := newFormRequest(url.Values{
+		"hub.callback": {s.URL + "/unguessable-path-unique-per-subscription?keep=me"},
+		"hub.mode":     {"unsubscribe"},
+		"hub.topic":    {"http://example.com/category/cats"},
+	})
+
+	w := httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+	hub.wait()
+
+	resp := w.Result()
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+
+	select {
+	case v := <-verification:
+		assert.Equal("me", v.Get("keep"))
+		assert.Equal("unsubscribe", v.Get("hub.mode"))
+		assert.Equal("http://example.com/category/cats", v.Get("hub.topic"))
+		assert.Equal(string(challenge), v.Get("hub.challenge"))
+		assert.False(v.Has("hub.lease_seconds"))
+	case <-time.After(time.Millisecond):
+		assert.Fail("timed out")
+	}
+
+	assert.Len(store.subs, 0)
+	if assert.Len(store.unsubs, 1) {
+		unsub := store.unsubs[0]
+		assert.Equal(s.URL+"/unguessable-path-unique-per-subscription?keep=me", unsub.callback)
+		assert.Equal("http://example.com/category/cats", unsub.topic)
+	}
+}
+
+func TestUnsubscribeWhenRespondingWithWrongChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("", store, fetch.New(true))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("this-is-not-the-challenge"))
+	}))
+	defer s.Close()
+
+	req := newFormRequest(url.Values{
+		"hub.callback": {s.URL},
+		"hub.mode":     {"unsubscribe"},
+		"hub.topic":    {"http://example.com/category/cats"},
+	})
+
+	w := httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+	hub.wait()
+
+	resp := w.Result()
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	assert.Len(store.unsubs, 0)
+}
+
+func TestSubscribeBadTopic(t *testing.T) {
+	assert := assert.New(t)
+
+	hub := New("", nil, fetch.New(true))
+
+	req := newFormRequest(url.Values{
+		"hub.callback": {"http://example.com/callback"},
+		"hub.mode":     {"subscribe"},
+		"hub.topic":    {"cats"},
+	})
+
+	w := httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+	hub.wait()
+
+	resp := w.Result()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSubscribeDenied(t *testing.T) {
+	for _, mode := range []string{"subscribe", "unsubscribe"} {
+		t.Run(mode, func(t *testing.T) {
+			assert := assert.New(t)
+
+			store := &fakeHubStore{}
+			hub := New("http://hub.example.com/", store, fetch.New(true))
+			hub.RestrictTopics("http://example.com/")
+
+			notification := make(chan url.Values, 1)
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				notification <- r.URL.Query()
+			}))
+			defer s.Close()
+
+			req := newFormRequest(url.Values{
+				"hub.callback": {s.URL + "/callback?keep=me"},
+				"hub.mode":     {mode},
+				"hub.topic":    {"http://example.org/category/cats"},
+			})
+
+			w := httptest.NewRecorder()
+			hub.ServeHTTP(w, req)
+			hub.wait()
+
+			resp := w.Result()
+			assert.Equal(http.StatusAccepted, resp.StatusCode)
+
+			select {
+			case v := <-notification:
+				assert.Equal("me", v.Get("keep"))
+				assert.Equal("denied", v.Get("hub.mode"))
+				assert.Equal("http://example.org/category/cats", v.Get("hub.topic"))
+				assert.Equal("hub.topic is not published by this hub", v.Get("hub.reason"))
+				assert.False(v.Has("hub.challenge"))
+			case <-time.After(time.Millisecond):
+				assert.Fail("timed out")
+			}
+
+			assert.Len(store.subs, 0)
+			assert.Len(store.unsubs, 0)
+		})
+	}
+}
+
+func TestPublishRetriesFailedDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+
+	var backoffs []int
+	hub.backoff = func(attempts int) time.Duration {
+		backoffs = append(backoffs, attempts)
+		return 0
+	}
+
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("i-am-content"))
+	}))
+	defer c.Close()
+
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
+
+	err := hub.Publish(c.URL)
+	assert.Nil(err)
+	hub.wait()
+
+	assert.Equal(int32(3), requests.Load())
+	assert.Equal([]int{1, 2}, backoffs)
+	assert.Len(store.unsubs, 0)
+
+	if assert.Len(store.deliveries, 3) {
+		assert.NotNil(store.deliveries[0])
+		assert.NotNil(store.deliveries[1])
+		assert.Nil(store.deliveries[2])
+	}
+}
+
+func TestPublishExpiresFailingSubscriber(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+	hub.backoff = func(int) time.Duration { return 0 }
+
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("i-am-content"))
+	}))
+	defer c.Close()
+
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
+
+	err := hub.Publish(c.URL)
+	assert.Nil(err)
+	hub.wait()
+
+	assert.Equal(int32(maxAttempts), requests.Load())
+	if assert.Len(store.unsubs, 1) {
+		assert.Equal(s.URL, store.unsubs[0].callback)
+		assert.Equal(c.URL, store.unsubs[0].topic)
+	}
+}
+
+func TestSubscribeRespondsBeforeVerifying(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+	}))
+	defer s.Close()
+
+	req := newFormRequest(url.Values{
+		"hub.callback": {s.URL},
+		"hub.mode":     {"subscribe"},
+		"hub.topic":    {"http://example.com/category/cats"},
+	})
+
+	w := httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusAccepted, w.Result().StatusCode)
+
+	close(release)
+	hub.wait()
+
+	assert.Len(store.subs, 1)
+}
+
+func TestPublishWithSignatureAlgorithm(t *testing.T) {
+	signatures := map[string]string{
+		"sha1":   "sha1=3193326f64f85f33a9653a58aa6cfe9dd1c7b4b5",
+		"sha256": "sha256=3c7a4c85ad49595f0bc94733f4493ba29d3dfdc0d1d54a129f94e816f15d1de9",
+		"sha384": "sha384=b93b70a5789e0cae6c5bb3591b7422eaf1c9188f8073373cd18742d9014e34c4feb53e753098f61f81de5aaef31bc64a",
+		"sha512": "sha512=1c02e2bb4ac82bee90b65021299a87c2c8691f2b4fd5e72f2f8c169ee6732ec6baa4276bfe22fd9da1f4ea07e05c64229878a45256884fd507234dfafd3f6c81",
+	}
+
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plainest")
+		w.Write([]byte("i-am-content"))
+	}))
+	defer c.Close()
+
+	for algorithm, expected := range signatures {
+		t.Run("subscriber chooses "+algorithm, func(t *testing.T) {
+			assert := assert.New(t)
+
+			store := &fakeHubStore{}
+			hub := New("http://hub.example.com/", store, fetch.New(true))
+
+			sig := make(chan string, 1)
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sig <- r.Header.Get("X-Hub-Signature")
+			}))
+			defer s.Close()
+
+			store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "catgifs", algorithm)
+
+			assert.Nil(hub.Publish(c.URL))
+			hub.wait()
+
+			select {
+			case v := <-sig:
+				assert.Equal(expected, v)
+			case <-time.After(time.Millisecond):
+				assert.Fail("timed out")
+			}
+		})
+
+		t.Run("hub chooses "+algorithm, func(t *testing.T) {
+			assert := assert.New(t)
+
+			store := &fakeHubStore{}
+			hub := New("http://hub.example.com/", store, fetch.New(true))
+			assert.Nil(hub.SignWith(algorithm))
+
+			sig := make(chan string, 1)
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sig <- r.Header.Get("X-Hub-Signature")
+			}))
+			defer s.Close()
+
+			store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "catgifs", "")
+
+			assert.Nil(hub.Publish(c.URL))
+			hub.wait()
+
+			select {
+			case v := <-sig:
+				assert.Equal(expected, v)
+			case <-time.After(time.Millisecond):
+				assert.Fail("timed out")
+			}
+		})
+	}
+}
+
+func TestSignWithUnknownAlgorithm(t *testing.T) {
+	hub := New("http://hub.example.com/", nil, fetch.New(true))
+
+	assert.Equal(t, ErrUnknownAlgorithm, hub.SignWith("md5"))
+}
+
+func TestSubscribeWithSignatureAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+	}))
+	defer s.Close()
+
+	req := newFormRequest(url.Values{
+		"hub.callback":            {s.URL},
+		"hub.mode":                {"subscribe"},
+		"hub.topic":               {"http://example.com/category/cats"},
+		"hub.secret":              {"catgifs"},
+		"hub.signature_algorithm": {"sha256"},
+	})
+
+	w := httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+	hub.wait()
+
+	assert.Equal(http.StatusAccepted, w.Result().StatusCode)
+	if assert.Len(store.subs, 1) {
+		assert.Equal("sha256", store.subs[0].algorithm)
+	}
+
+	req = newFormRequest(url.Values{
+		"hub.callback":            {s.URL},
+		"hub.mode":                {"subscribe"},
+		"hub.topic":               {"http://example.com/category/cats"},
+		"hub.secret":              {"catgifs"},
+		"hub.signature_algorithm": {"md5"},
+	})
+
+	w = httptest.NewRecorder()
+	hub.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestPublishDetectsContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+
+	contentType := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType <- r.Header.Get("Content-Type")
+	}))
+	defer s.Close()
+
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><feed xmlns="http://www.w3.org/2005/Atom"></feed>`))
+	}))
+	defer c.Close()
+
+	store.Subscribe(s.URL, c.URL, time.Now().Add(time.Second), "", "")
+
+	assert.Nil(hub.Publish(c.URL))
+	hub.wait()
+
+	select {
+	case v := <-contentType:
+		assert.Equal("text/xml; charset=utf-8", v)
+	case <-time.After(time.Millisecond):
+		assert.Fail("timed out")
+	}
+}
+
+func TestSubscribeWhenQueueIsFull(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &fakeHubStore{}
+	hub := New("http://hub.example.com/", store, fetch.New(true))
+
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+	}))
+	defer s.Close()
+
+	// each worker holds one task while verifying, so at most this many requests
+	// can be accepted before the queue is full
+	var accepted, refused int
+	for range workers + queueSize + 1 {
+		req := newFormRequest(url.Values{
+			"hub.callback": {s.URL},
+			"hub.mode":     {"subscribe"},
+			"hub.topic":    {"http://example.com/category/cats"},
+		})
+
+		w := httptest.NewRecorder()
+		hub.ServeHTTP(w, req)
+
+		switch w.Result().StatusCode {
+		case http.StatusAccepted:
+			accepted++
+		case http.StatusServiceUnavailable:
+			assert.Equal("60", w.Result().Header.Get("Retry-After"))
+			refused++
+		}
+	}
+
+	assert.GreaterOrEqual(accepted, queueSize)
+	assert.GreaterOrEqual(refused, 1)
+
+	close(release)
+	hub.wait()
+}
@@ -0,0 +1,41 @@
+package websub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Ping notifies an external hub that a topic has changed, so that the hub can
+// fetch it and deliver the content to its subscribers.
+type Ping struct {
+	hubURL string
+	client *http.Client
+}
+
+// NewPing creates a Ping that makes requests to the hub at hubURL with client.
+func NewPing(hubURL string, client *http.Client) *Ping {
+	return &Ping{hubURL: hubURL, client: client}
+}
+
+// Publish tells the hub that topic has changed. Both hub.topic and hub.url are
+// sent, as hubs differ in which they expect.
+func (p *Ping) Publish(topic string) error {
+	resp, err := p.client.PostForm(p.hubURL, url.Values{
+		"hub.mode":  {"publish"},
+		"hub.topic": {topic},
+		"hub.url":   {topic},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hub returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
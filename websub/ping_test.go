@@ -0,0 +1,48 @@
+package websub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"hawx.me/code/tally-ho/internal/fetch"
+)
+
+func TestPing(t *testing.T) {
+	assert := assert.New(t)
+
+	pings := make(chan url.Values, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		pings <- r.PostForm
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	err := NewPing(s.URL, fetch.New(true)).Publish("http://example.com/feed/atom")
+	assert.Nil(err)
+
+	select {
+	case v := <-pings:
+		assert.Equal("publish", v.Get("hub.mode"))
+		assert.Equal("http://example.com/feed/atom", v.Get("hub.topic"))
+		assert.Equal("http://example.com/feed/atom", v.Get("hub.url"))
+	case <-time.After(time.Millisecond):
+		assert.Fail("timed out")
+	}
+}
+
+func TestPingWhenHubErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	err := NewPing(s.URL, fetch.New(true)).Publish("http://example.com/feed/atom")
+	assert.NotNil(err)
+}
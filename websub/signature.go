@@ -0,0 +1,54 @@
+package websub
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+)
+
+// DefaultAlgorithm is used to sign content when neither the subscriber nor the
+// hub has chosen an algorithm.
+const DefaultAlgorithm = "sha512"
+
+// ErrUnknownAlgorithm is returned when choosing a signature algorithm that is
+// not supported.
+var ErrUnknownAlgorithm = errors.New("hub.signature_algorithm must be one of sha1, sha256, sha384 or sha512")
+
+// algorithms are the signature methods listed in
+// https://www.w3.org/TR/websub/#signature-validation.
+var algorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// SignWith sets the algorithm used to sign content for subscribers that have
+// not chosen one.
+func (h *Hub) SignWith(algorithm string) error {
+	if _, ok := algorithms[algorithm]; !ok {
+		return ErrUnknownAlgorithm
+	}
+
+	h.algorithm = algorithm
+	return nil
+}
+
+// signature returns the value of the X-Hub-Signature header for body.
+func signature(algorithm, secret string, body []byte) (string, error) {
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return "", ErrUnknownAlgorithm
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	if _, err := mac.Write(body); err != nil {
+		return "", err
+	}
+
+	return algorithm + "=" + hex.EncodeToString(mac.Sum(nil)), nil
+}